@@ -0,0 +1,201 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// JetStream backs KV buckets and object stores with streams using these prefixes
+var internalStreamPrefixes = []string{"KV_", "OBJ_"}
+
+// StreamSummary A row of SHOW STREAMS
+type StreamSummary struct {
+	Name      string   `json:"name"`
+	Subjects  []string `json:"subjects"`
+	Retention string   `json:"retention"`
+	Messages  uint64   `json:"messages"`
+	Bytes     uint64   `json:"bytes"`
+	Consumers int      `json:"consumers"`
+}
+
+// TableSummary A row of SHOW TABLES. Tables are JetStream KV buckets.
+type TableSummary struct {
+	Name    string        `json:"name"`
+	Keys    uint64        `json:"keys"`
+	History int64         `json:"history"`
+	TTL     time.Duration `json:"ttl"`
+	Bytes   uint64        `json:"bytes"`
+}
+
+// ConsumerSummary A consumer reading a stream, shown by DESCRIBE EXTENDED
+type ConsumerSummary struct {
+	Name          string   `json:"name"`
+	Durable       bool     `json:"durable"`
+	FilterSubject []string `json:"filter_subjects,omitempty"`
+	Pending       uint64   `json:"pending"`
+	AckPending    int      `json:"ack_pending"`
+}
+
+// StreamDescription The result of DESCRIBE. Consumers and Queries are only set for DESCRIBE EXTENDED.
+type StreamDescription struct {
+	StreamSummary
+	Description string            `json:"description,omitempty"`
+	Storage     string            `json:"storage"`
+	Replicas    int               `json:"replicas"`
+	MaxAge      time.Duration     `json:"max_age"`
+	FirstSeq    uint64            `json:"first_seq"`
+	LastSeq     uint64            `json:"last_seq"`
+	FirstTime   time.Time         `json:"first_time"`
+	LastTime    time.Time         `json:"last_time"`
+	Schema      []Field           `json:"schema"`
+	Consumers   []ConsumerSummary `json:"consumers,omitempty"`
+	Queries     []string          `json:"queries,omitempty"`
+}
+
+func isInternalStream(name string) bool {
+	for _, prefix := range internalStreamPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func summarise(info *jetstream.StreamInfo) StreamSummary {
+	return StreamSummary{
+		Name:      info.Config.Name,
+		Subjects:  info.Config.Subjects,
+		Retention: info.Config.Retention.String(),
+		Messages:  info.State.Msgs,
+		Bytes:     info.State.Bytes,
+		Consumers: info.State.Consumers,
+	}
+}
+
+// ListStreams Every user stream, excluding the streams behind KV buckets and object stores
+func ListStreams(ctx context.Context, js jetstream.JetStream) ([]StreamSummary, error) {
+	lister := js.ListStreams(ctx)
+	var streams []StreamSummary
+	for info := range lister.Info() {
+		if isInternalStream(info.Config.Name) {
+			continue
+		}
+		streams = append(streams, summarise(info))
+	}
+	if err := lister.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list streams: %w", err)
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].Name < streams[j].Name })
+	return streams, nil
+}
+
+// StreamForSubjects The one stream that stores all of `subjects`, which may have wildcards. It's an error for
+// a subject to be stored by no stream or by several.
+func StreamForSubjects(ctx context.Context, js jetstream.JetStream, subjects []string) (string, error) {
+	stream := ""
+	for _, subject := range subjects {
+		lister := js.StreamNames(ctx, jetstream.WithStreamListSubject(subject))
+		var names []string
+		for name := range lister.Name() {
+			if !isInternalStream(name) {
+				names = append(names, name)
+			}
+		}
+		if err := lister.Err(); err != nil {
+			return "", fmt.Errorf("failed to find the stream of subject %s: %w", subject, err)
+		}
+		sort.Strings(names)
+		switch {
+		case len(names) == 0:
+			return "", fmt.Errorf("no stream stores subject %s", subject)
+		case len(names) > 1:
+			return "", fmt.Errorf("subject %s is stored by more than one stream: %s", subject, strings.Join(names, ", "))
+		case stream != "" && names[0] != stream:
+			return "", fmt.Errorf("subjects %s are stored by different streams, %s and %s", strings.Join(subjects, ", "), stream, names[0])
+		}
+		stream = names[0]
+	}
+	return stream, nil
+}
+
+// ListTables Every KV bucket
+func ListTables(ctx context.Context, js jetstream.JetStream) ([]TableSummary, error) {
+	lister := js.KeyValueStores(ctx)
+	var tables []TableSummary
+	for status := range lister.Status() {
+		tables = append(tables, TableSummary{
+			Name:    status.Bucket(),
+			Keys:    status.Values(),
+			History: status.History(),
+			TTL:     status.TTL(),
+			Bytes:   status.Bytes(),
+		})
+	}
+	if err := lister.Error(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables, nil
+}
+
+// Describe Describe a stream, inferring its schema from its most recent messages.
+func Describe(ctx context.Context, js jetstream.JetStream, name string, extended bool) (*StreamDescription, error) {
+	stream, err := js.Stream(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find stream %s: %w", name, err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe stream %s: %w", name, err)
+	}
+
+	description := &StreamDescription{
+		StreamSummary: summarise(info),
+		Description:   info.Config.Description,
+		Storage:       info.Config.Storage.String(),
+		Replicas:      info.Config.Replicas,
+		MaxAge:        info.Config.MaxAge,
+		FirstSeq:      info.State.FirstSeq,
+		LastSeq:       info.State.LastSeq,
+		FirstTime:     info.State.FirstTime,
+		LastTime:      info.State.LastTime,
+	}
+
+	samples, err := sampleLatest(ctx, stream, info, describeSampleSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range InferFromMessages(name, samples).Fields {
+		if !strings.Contains(field.Name, ".") {
+			description.Schema = append(description.Schema, Field{Name: field.Name, Type: field.Type.String()})
+		}
+	}
+
+	if extended {
+		lister := stream.ListConsumers(ctx)
+		for consumer := range lister.Info() {
+			// A copy, as appending to the config's own slice could write into its backing array
+			filters := slices.Clone(consumer.Config.FilterSubjects)
+			if consumer.Config.FilterSubject != "" {
+				filters = append(filters, consumer.Config.FilterSubject)
+			}
+			description.Consumers = append(description.Consumers, ConsumerSummary{
+				Name:          consumer.Name,
+				Durable:       consumer.Config.Durable != "",
+				FilterSubject: filters,
+				Pending:       consumer.NumPending,
+				AckPending:    consumer.NumAckPending,
+			})
+		}
+		if err := lister.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list consumers of %s: %w", name, err)
+		}
+	}
+	return description, nil
+}
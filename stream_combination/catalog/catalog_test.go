@@ -0,0 +1,71 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeStreams Answers each StreamNames call with the next of `names`, as the streams storing the subject asked for
+type fakeStreams struct {
+	jetstream.JetStream
+	names [][]string
+	err   error
+	calls int
+}
+
+func (js *fakeStreams) StreamNames(ctx context.Context, opts ...jetstream.StreamListOpt) jetstream.StreamNameLister {
+	names := make(chan string, len(js.names[js.calls]))
+	for _, name := range js.names[js.calls] {
+		names <- name
+	}
+	close(names)
+	js.calls++
+	return fakeNameLister{names: names, err: js.err}
+}
+
+type fakeNameLister struct {
+	names chan string
+	err   error
+}
+
+func (l fakeNameLister) Name() <-chan string { return l.names }
+func (l fakeNameLister) Err() error          { return l.err }
+
+func TestStreamForSubjects(t *testing.T) {
+	tests := []struct {
+		name     string
+		subjects []string
+		names    [][]string
+		err      error
+		expected string
+		// message The error, or "" if the stream is found
+		message string
+	}{
+		{"one stream", []string{"orders.*.created"}, [][]string{{"ORDERS"}}, nil, "ORDERS", ""},
+		{"several subjects of a stream", []string{"orders.eu", "orders.us"}, [][]string{{"ORDERS"}, {"ORDERS"}}, nil, "ORDERS", ""},
+		// The stream behind a KV bucket stores `$KV.>`, which isn't where queries read from
+		{"internal streams", []string{">"}, [][]string{{"KV_nsql_queries", "ORDERS"}}, nil, "ORDERS", ""},
+		{"no stream", []string{"payments.>"}, [][]string{{}}, nil, "", "no stream stores subject payments.>"},
+		{"several streams", []string{"orders.>"}, [][]string{{"ORDERS_US", "ORDERS_EU"}}, nil, "",
+			"subject orders.> is stored by more than one stream: ORDERS_EU, ORDERS_US"},
+		{"subjects of different streams", []string{"orders.eu", "payments.eu"}, [][]string{{"ORDERS"}, {"PAYMENTS"}}, nil, "",
+			"subjects orders.eu, payments.eu are stored by different streams, ORDERS and PAYMENTS"},
+		{"listing fails", []string{"orders.eu"}, [][]string{{}}, errors.New("timeout"), "",
+			"failed to find the stream of subject orders.eu: timeout"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stream, err := StreamForSubjects(context.Background(), &fakeStreams{names: test.names, err: test.err}, test.subjects)
+			message := ""
+			if err != nil {
+				message = err.Error()
+			}
+			if stream != test.expected || message != test.message {
+				t.Errorf("found %q with error %q, expected %q with error %q", stream, message, test.expected, test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"stream_combination/parser"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	describeSampleSize = 10
+	DefaultSampleSize  = 100
+	// MaxDistinct Distinct values are only counted up to this, to bound memory on high cardinality fields
+	MaxDistinct = 1000
+)
+
+// Field A top-level field seen in a stream's messages
+type Field struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// InferredField A field seen in sampled messages. Fields of nested objects are named by their path, e.g. `customer.city`.
+type InferredField struct {
+	Name     string      `json:"name"`
+	Type     parser.Type `json:"type"`
+	Nullable bool        `json:"nullable"`
+	Mixed    bool        `json:"mixed,omitempty"` // seen with incompatible types, so typed as STRING
+	Present  int         `json:"present"`         // objects the field had a non-null value in
+	Distinct int         `json:"distinct"`        // distinct values seen, up to MaxDistinct
+}
+
+// InferredSchema The schema of a stream as inferred from a sample of its messages
+type InferredSchema struct {
+	Stream  string          `json:"stream"`
+	Sampled int             `json:"sampled"`
+	Skipped int             `json:"skipped"` // messages that weren't JSON objects
+	Fields  []InferredField `json:"fields"`
+	root    *fieldStats
+}
+
+// Schema The inferred schema in the form stored in the catalog
+func (is *InferredSchema) Schema() parser.Schema {
+	return parser.Schema{Stream: is.Stream, Columns: is.root.toType().Fields}
+}
+
+// fieldStats What was seen of one field across the sampled messages
+type fieldStats struct {
+	kind     parser.TypeKind
+	mixed    bool
+	present  int
+	nulls    int
+	distinct map[string]struct{}
+	objects  int // STRUCT: how many objects were seen, for the nullability of its fields
+	fields   map[string]*fieldStats
+	element  *fieldStats // ARRAY: all elements, merged
+}
+
+func newFieldStats() *fieldStats {
+	return &fieldStats{distinct: make(map[string]struct{})}
+}
+
+func (fs *fieldStats) observe(value interface{}) {
+	if value == nil {
+		fs.nulls++
+		return
+	}
+	fs.present++
+	switch v := value.(type) {
+	case map[string]interface{}:
+		fs.merge(parser.TypeStruct)
+		fs.objects++
+		if fs.fields == nil {
+			fs.fields = make(map[string]*fieldStats)
+		}
+		for name, fieldValue := range v {
+			field, ok := fs.fields[name]
+			if !ok {
+				field = newFieldStats()
+				fs.fields[name] = field
+			}
+			field.observe(fieldValue)
+		}
+	case []interface{}:
+		fs.merge(parser.TypeArray)
+		if fs.element == nil {
+			fs.element = newFieldStats()
+		}
+		for _, element := range v {
+			fs.element.observe(element)
+		}
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			fs.merge(parser.TypeTimestamp)
+		} else {
+			fs.merge(parser.TypeString)
+		}
+		fs.count(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			fs.merge(parser.TypeBigInt)
+		} else {
+			fs.merge(parser.TypeDouble)
+		}
+		fs.count(v)
+	case bool:
+		fs.merge(parser.TypeBoolean)
+		fs.count(v)
+	}
+}
+
+func (fs *fieldStats) count(value interface{}) {
+	if len(fs.distinct) < MaxDistinct {
+		fs.distinct[fmt.Sprint(value)] = struct{}{}
+	}
+}
+
+// merge Widen the field's type to cover `kind`: integers widen to DOUBLE and timestamps to STRING.
+// Anything else incompatible is typed as STRING and marked mixed.
+func (fs *fieldStats) merge(kind parser.TypeKind) {
+	switch {
+	case fs.kind == parser.TypeUnknown || fs.kind == kind || fs.mixed:
+		if !fs.mixed {
+			fs.kind = kind
+		}
+	case fs.kind == parser.TypeBigInt && kind == parser.TypeDouble, fs.kind == parser.TypeDouble && kind == parser.TypeBigInt:
+		fs.kind = parser.TypeDouble
+	case fs.kind == parser.TypeTimestamp && kind == parser.TypeString, fs.kind == parser.TypeString && kind == parser.TypeTimestamp:
+		fs.kind = parser.TypeString
+	default:
+		fs.kind = parser.TypeString
+		fs.mixed = true
+	}
+}
+
+// sortedFields Field names in a stable order, as JSON objects have none
+func (fs *fieldStats) sortedFields() []string {
+	names := make([]string, 0, len(fs.fields))
+	for name := range fs.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (fs *fieldStats) toType() parser.Type {
+	t := parser.Type{Kind: fs.kind}
+	switch {
+	case fs.mixed:
+	case fs.kind == parser.TypeStruct:
+		for _, name := range fs.sortedFields() {
+			t.Fields = append(t.Fields, parser.ColumnDefinition{Name: name, Type: fs.fields[name].toType()})
+		}
+	case fs.kind == parser.TypeArray:
+		element := parser.Type{Kind: parser.TypeUnknown}
+		if fs.element != nil {
+			element = fs.element.toType()
+		}
+		t.Element = &element
+	}
+	return t
+}
+
+// flatten The fields of a STRUCT and, recursively, of its STRUCT fields
+func (fs *fieldStats) flatten(prefix string) []InferredField {
+	var fields []InferredField
+	for _, name := range fs.sortedFields() {
+		field := fs.fields[name]
+		path := prefix + name
+		fields = append(fields, InferredField{
+			Name:     path,
+			Type:     field.toType(),
+			Nullable: field.present < fs.objects,
+			Mixed:    field.mixed,
+			Present:  field.present,
+			Distinct: len(field.distinct),
+		})
+		if field.kind == parser.TypeStruct && !field.mixed {
+			fields = append(fields, field.flatten(path+".")...)
+		}
+	}
+	return fields
+}
+
+// InferFromMessages Infer the schema of JSON objects. Messages that aren't JSON objects are skipped.
+func InferFromMessages(stream string, messages [][]byte) *InferredSchema {
+	root := newFieldStats()
+	inferred := &InferredSchema{Stream: stream, root: root}
+	for _, message := range messages {
+		var data map[string]interface{}
+		if err := json.Unmarshal(message, &data); err != nil {
+			inferred.Skipped++
+			continue
+		}
+		inferred.Sampled++
+		root.observe(data)
+	}
+	inferred.Fields = root.flatten("")
+	return inferred
+}
+
+// InferSchema Sample up to `n` of the newest messages in a stream and infer their schema.
+func InferSchema(ctx context.Context, js jetstream.JetStream, name string, n int) (*InferredSchema, error) {
+	stream, err := js.Stream(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find stream %s: %w", name, err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe stream %s: %w", name, err)
+	}
+
+	var samples [][]byte
+	if info.Config.AllowDirect {
+		// Direct gets can be answered by any replica, without creating a consumer
+		samples, err = sampleLatest(ctx, stream, info, n)
+	} else {
+		samples, err = sampleWithConsumer(ctx, stream, info, n)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return InferFromMessages(name, samples), nil
+}
+
+// sampleLatest Read up to `n` of the newest messages in a stream, skipping deleted sequences.
+func sampleLatest(ctx context.Context, stream jetstream.Stream, info *jetstream.StreamInfo, n int) ([][]byte, error) {
+	var samples [][]byte
+	for seq := info.State.LastSeq; seq >= info.State.FirstSeq && seq > 0 && len(samples) < n; seq-- {
+		msg, err := stream.GetMsg(ctx, seq)
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message %d: %w", seq, err)
+		}
+		samples = append(samples, msg.Data)
+	}
+	return samples, nil
+}
+
+// sampleWithConsumer Read the messages in the last `n` sequences of a stream with an ephemeral ordered consumer.
+func sampleWithConsumer(ctx context.Context, stream jetstream.Stream, info *jetstream.StreamInfo, n int) ([][]byte, error) {
+	if info.State.Msgs == 0 {
+		return nil, nil
+	}
+	start := info.State.FirstSeq
+	if info.State.LastSeq >= uint64(n) {
+		start = max(start, info.State.LastSeq-uint64(n)+1)
+	}
+	consumer, err := stream.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{
+		DeliverPolicy: jetstream.DeliverByStartSequencePolicy,
+		OptStartSeq:   start,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sampling consumer on %s: %w", info.Config.Name, err)
+	}
+	batch, err := consumer.Fetch(n, jetstream.FetchMaxWait(2*time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample %s: %w", info.Config.Name, err)
+	}
+	var samples [][]byte
+	for msg := range batch.Messages() {
+		samples = append(samples, msg.Data())
+		if meta, err := msg.Metadata(); err == nil && meta.Sequence.Stream >= info.State.LastSeq {
+			break
+		}
+	}
+	if err := batch.Error(); err != nil {
+		return nil, fmt.Errorf("failed to sample %s: %w", info.Config.Name, err)
+	}
+	return samples, nil
+}
@@ -0,0 +1,80 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"stream_combination/parser"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const DefaultSchemaBucket = "nsql_schemas"
+
+// Schemas Declared stream schemas, stored in a JetStream KV bucket keyed by stream name
+type Schemas struct {
+	kv jetstream.KeyValue
+}
+
+func NewSchemas(ctx context.Context, js jetstream.JetStream, bucket string) (*Schemas, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "nsql stream schemas",
+		History:     5,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open schema catalog %s: %w", bucket, err)
+	}
+	return &Schemas{kv: kv}, nil
+}
+
+func (s *Schemas) Put(ctx context.Context, schema parser.Schema) error {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("error marshalling schema of %s: %w", schema.Stream, err)
+	}
+	if _, err := s.kv.Put(ctx, schema.Stream, data); err != nil {
+		return fmt.Errorf("failed to store schema of %s: %w", schema.Stream, err)
+	}
+	return nil
+}
+
+// Get The schema of a stream, or false if none is declared
+func (s *Schemas) Get(ctx context.Context, stream string) (parser.Schema, bool, error) {
+	var schema parser.Schema
+	entry, err := s.kv.Get(ctx, stream)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return schema, false, nil
+	}
+	if err != nil {
+		return schema, false, fmt.Errorf("failed to read schema of %s: %w", stream, err)
+	}
+	if err := json.Unmarshal(entry.Value(), &schema); err != nil {
+		return schema, false, fmt.Errorf("error unmarshalling schema of %s: %w", stream, err)
+	}
+	return schema, true, nil
+}
+
+// All Every declared schema, by stream name
+func (s *Schemas) All(ctx context.Context) (map[string]parser.Schema, error) {
+	watcher, err := s.kv.WatchAll(ctx, jetstream.IgnoreDeletes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schemas: %w", err)
+	}
+	defer watcher.Stop()
+
+	schemas := make(map[string]parser.Schema)
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			// nil marks the end of the initial values
+			break
+		}
+		var schema parser.Schema
+		if err := json.Unmarshal(entry.Value(), &schema); err != nil {
+			return nil, fmt.Errorf("error unmarshalling schema of %s: %w", entry.Key(), err)
+		}
+		schemas[entry.Key()] = schema
+	}
+	return schemas, nil
+}
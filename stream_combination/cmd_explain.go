@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"stream_combination/engine"
+	"stream_combination/parser"
+)
+
+func explainCommand(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	file := fs.String("f", "", "query file")
+	dot := fs.Bool("dot", false, "print the processor DAG as Graphviz DOT")
+	fs.Parse(args)
+
+	query, err := readQuery(*file)
+	if err != nil {
+		return err
+	}
+	statement, err := parser.ParseStatement(query)
+	if err != nil {
+		return err
+	}
+	explain, ok := statement.(*parser.Explain)
+	if !ok {
+		explain = &parser.Explain{Statement: statement}
+	}
+	explain.Graphviz = explain.Graphviz || *dot
+
+	plan, err := engine.Explain(nil, explain)
+	if err != nil {
+		return err
+	}
+	fmt.Print(plan)
+	return nil
+}
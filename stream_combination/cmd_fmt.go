@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"stream_combination/parser"
+)
+
+func fmtCommand(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	file := fs.String("f", "", "query file")
+	write := fs.Bool("w", false, "write the result to the query file instead of stdout")
+	fs.Parse(args)
+
+	query, err := readQuery(*file)
+	if err != nil {
+		return err
+	}
+	statement, err := parser.ParseStatement(query)
+	if err != nil {
+		return err
+	}
+	formatted := parser.FormatStatement(statement) + "\n"
+	if *write && *file != "" && *file != "-" {
+		return os.WriteFile(*file, []byte(formatted), 0644)
+	}
+	fmt.Print(formatted)
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"stream_combination/catalog"
+	"stream_combination/engine"
+	"stream_combination/models"
+	"stream_combination/parser"
+	"stream_combination/processor"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var keywords = []string{
+	"CREATE", "STREAM", "EMIT", "CHANGES", "SHOW", "QUERIES", "STREAMS", "TABLES", "DESCRIBE", "EXTENDED", "TERMINATE", "EXPLAIN", "GRAPHVIZ", "INFER", "SCHEMA", "SAVE", "WITH", "ARRAY", "MAP", "STRUCT", "SELECT", "FROM", "WHERE", "GROUP", "BY", "LIMIT", "PARALLELISM", "AS", "INNER", "JOIN", "WITHIN", "ON",
+	"AND", "OR", "NOT", "LIKE", "IN", "IS", "TRUE", "FALSE", "NULL",
+	"HOUR", "HOURS", "MINUTE", "MINUTES", "SECOND", "SECONDS", "DAY", "DAYS",
+	"BOOLEAN", "INT", "BIGINT", "DOUBLE", "STRING", "TIMESTAMP",
+}
+
+func replCommand(args []string) error {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	registryBucket := fs.String("registry", engine.DefaultRegistryBucket, "KV bucket of persistent queries")
+	bufferSize := fs.Int("buffer-size", processor.DefaultBufferSize, "events buffered by each processor of a query")
+	var conn connectionFlags
+	conn.register(fs)
+	fs.Parse(args)
+
+	nc, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer drain(nc, 10*time.Second)
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("error connecting to JetStream: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queryEngine, err := openEngine(ctx, js, *registryBucket)
+	if err != nil {
+		return err
+	}
+	defer queryEngine.Close()
+	queryEngine.SetBufferSize(*bufferSize)
+
+	completer := &replCompleter{}
+	completer.refreshStreams(js)
+	completer.refreshColumns(queryEngine.Schemas())
+
+	home, _ := os.UserHomeDir()
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:                 "nsql> ",
+		HistoryFile:            filepath.Join(home, ".nsql_history"),
+		AutoComplete:           completer,
+		DisableAutoSaveHistory: true, // Statements span lines, so history is saved per statement
+		InterruptPrompt:        "^C",
+		EOFPrompt:              "exit",
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	fmt.Printf("Connected to %s. Statements end with ';', Ctrl-C cancels a running query, Ctrl-D exits.\n", nc.ConnectedUrl())
+
+	var statement []string
+	for {
+		line, err := rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			// Discard the partial statement
+			statement = statement[:0]
+			rl.SetPrompt("nsql> ")
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if len(statement) == 0 {
+			switch strings.ToLower(trimmed) {
+			case "":
+				continue
+			case "exit", "quit":
+				return nil
+			case "help":
+				fmt.Println("Enter a query ending in ';', e.g. SELECT StringPayload FROM streamA;")
+				continue
+			}
+		}
+
+		statement = append(statement, line)
+		if !strings.HasSuffix(trimmed, ";") {
+			rl.SetPrompt("   -> ")
+			continue
+		}
+
+		query := strings.Join(statement, "\n")
+		statement = statement[:0]
+		rl.SetPrompt("nsql> ")
+		rl.SaveHistory(query)
+
+		if err := runStatement(ctx, queryEngine, strings.TrimSuffix(strings.TrimSpace(query), ";")); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		completer.refreshStreams(js)
+		completer.refreshColumns(queryEngine.Schemas())
+	}
+}
+
+// runStatement Run a push query, or execute any other statement and print its result.
+func runStatement(ctx context.Context, queryEngine *engine.Engine, sql string) error {
+	statement, err := parser.ParseStatement(sql)
+	if err != nil {
+		return err
+	}
+	if node, ok := statement.(*parser.SelectNode); ok {
+		return runPushQuery(queryEngine, sql, node)
+	}
+	result, err := queryEngine.Execute(ctx, sql)
+	if err != nil {
+		return err
+	}
+	printResult(os.Stdout, result)
+	return nil
+}
+
+// runPushQuery Run a query, printing rows until it fails or Ctrl-C cancels it.
+func runPushQuery(queryEngine *engine.Engine, sql string, node parser.Node) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Ctrl-C cancels only this query while it's running
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	query, err := queryEngine.StartTransient(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	table := newTableWriter(os.Stdout, resultColumns(node))
+	table.Header()
+	rows := 0
+	defer func() {
+		table.Footer()
+		fmt.Printf("%d rows\n", rows)
+	}()
+
+	for {
+		select {
+		case event := <-query.Rows():
+			table.Row(event)
+			rows++
+		case <-query.Done():
+			return query.Err()
+		case <-interrupts:
+			return nil
+		}
+	}
+}
+
+func resultColumns(node parser.Node) []string {
+	selectNode, ok := node.(*parser.SelectNode)
+	if !ok {
+		return []string{"*"}
+	}
+	columns := make([]string, len(selectNode.Fields))
+	for i, field := range selectNode.Fields {
+		columns[i] = field.OutputName()
+	}
+	return columns
+}
+
+// replCompleter Complete keywords, the names of JetStream streams, the columns of their schemas and pseudo-columns
+type replCompleter struct {
+	mu      sync.Mutex
+	streams []string
+	columns []string
+}
+
+func (rc *replCompleter) refreshStreams(js jetstream.JetStream) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	lister := js.StreamNames(ctx)
+	var streams []string
+	for name := range lister.Name() {
+		streams = append(streams, name)
+	}
+	if lister.Err() != nil {
+		// Keep completing the previous list
+		return
+	}
+	sort.Strings(streams)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.streams = streams
+}
+
+func (rc *replCompleter) refreshColumns(schemas *catalog.Schemas) {
+	if schemas == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	all, err := schemas.All(ctx)
+	if err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, schema := range all {
+		addColumnNames(seen, schema.Columns)
+	}
+	columns := make([]string, 0, len(seen))
+	for column := range seen {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.columns = columns
+}
+
+// addColumnNames Columns and the fields of nested STRUCTs, which are completed after the `.`
+func addColumnNames(seen map[string]bool, columns []parser.ColumnDefinition) {
+	for _, column := range columns {
+		seen[column.Name] = true
+		if column.Type.Kind == parser.TypeStruct {
+			addColumnNames(seen, column.Type.Fields)
+		}
+	}
+}
+
+func (rc *replCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	start := pos
+	for start > 0 && isIdentifierRune(line[start-1]) {
+		start--
+	}
+	prefix := string(line[start:pos])
+	if prefix == "" {
+		return nil, 0
+	}
+
+	rc.mu.Lock()
+	candidates := append(append(append([]string{}, keywords...), rc.streams...), rc.columns...)
+	candidates = append(append(candidates, models.PseudoColumns...), models.SubjectTokenColumn)
+	rc.mu.Unlock()
+
+	var suffixes [][]rune
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) && candidate != prefix {
+			suffixes = append(suffixes, []rune(candidate[len(prefix):]+" "))
+		}
+	}
+	return suffixes, len(prefix)
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"stream_combination/engine"
+	"stream_combination/models"
+	"stream_combination/parser"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTableWriter(t *testing.T) {
+	var buf bytes.Buffer
+	table := newTableWriter(&buf, []string{"id", "name"})
+	table.Header()
+	table.Row(models.NewEvent(time.Now(), map[string]interface{}{"id": 1, "name": "a name longer than the column is wide"}))
+	table.Row(models.NewEvent(time.Now(), map[string]interface{}{"id": 2}))
+	table.Footer()
+
+	border := "+" + strings.Repeat("-", 18) + "+" + strings.Repeat("-", 18) + "+"
+	expected := strings.Join([]string{
+		border,
+		"| id               | name             |",
+		border,
+		"| 1                | a name longer... |",
+		"| 2                |                  |",
+		border,
+	}, "\n") + "\n"
+	if buf.String() != expected {
+		t.Errorf("table\n%s\nexpected\n%s", buf.String(), expected)
+	}
+}
+
+func TestTableWriterSelectAll(t *testing.T) {
+	var buf bytes.Buffer
+	table := newTableWriter(&buf, []string{"*"})
+	table.Row(models.NewEvent(time.Now(), map[string]interface{}{"id": 1}))
+	if got := buf.String(); !strings.Contains(got, `{"id":1}`) {
+		t.Errorf("row %q, expected the whole event as JSON", got)
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   engine.Result
+		expected string
+	}{
+		{"message", engine.Result{Message: "Query CSAS_TOTALS terminated"}, "Query CSAS_TOTALS terminated\n"},
+		// Columns are as wide as their widest value, rather than the minimum width of a push query
+		{"rows", engine.Result{Columns: []string{"Stream", "Subjects"}, Rows: [][]string{{"orders", "orders.>"}, {"purchases_by_user", "purchases.*"}}},
+			"+-------------------+-------------+\n" +
+				"| Stream            | Subjects    |\n" +
+				"+-------------------+-------------+\n" +
+				"| orders            | orders.>    |\n" +
+				"| purchases_by_user | purchases.* |\n" +
+				"+-------------------+-------------+\n" +
+				"2 rows\n",
+		},
+		{"no rows", engine.Result{Columns: []string{"Query"}},
+			"+-------+\n| Query |\n+-------+\n+-------+\n0 rows\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printResult(&buf, &test.result)
+			if buf.String() != test.expected {
+				t.Errorf("printed\n%s\nexpected\n%s", buf.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestResultColumns(t *testing.T) {
+	alias := "total"
+	node := &parser.SelectNode{Fields: []parser.Column{{Field: "id"}, {Field: "amount", Alias: &alias}}}
+	if got := resultColumns(node); !reflect.DeepEqual(got, []string{"id", "total"}) {
+		t.Errorf("columns %v, expected the output name of each field", got)
+	}
+	if got := resultColumns(parser.WhereNode{}); !reflect.DeepEqual(got, []string{"*"}) {
+		t.Errorf("columns %v of a statement that isn't a SELECT, expected the whole event", got)
+	}
+}
+
+func TestReplCompleter(t *testing.T) {
+	completer := &replCompleter{streams: []string{"orders", "order_items"}, columns: []string{"order_id"}}
+	tests := []struct {
+		line     string
+		expected []string
+		length   int
+	}{
+		{"SELECT * FROM ord", []string{"ers ", "er_items ", "er_id "}, 3},
+		{"SEL", []string{"ECT "}, 3},
+		{"SELECT _subject_t", []string{"oken "}, 10},
+		{"SELECT _s", []string{"ubject ", "tream ", "eq ", "ubject_token "}, 2},
+		{"SELECT ", nil, 0},
+		{"SELECT orders", nil, 6},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			suffixes, length := completer.Do([]rune(test.line), len(test.line))
+			var got []string
+			for _, suffix := range suffixes {
+				got = append(got, string(suffix))
+			}
+			if !reflect.DeepEqual(got, test.expected) || length != test.length {
+				t.Errorf("completed %q from %d characters, expected %q from %d", got, length, test.expected, test.length)
+			}
+		})
+	}
+}
+
+func TestAddColumnNames(t *testing.T) {
+	seen := make(map[string]bool)
+	addColumnNames(seen, []parser.ColumnDefinition{
+		{Name: "id", Type: parser.Type{Kind: parser.TypeInt}},
+		{Name: "address", Type: parser.Type{Kind: parser.TypeStruct, Fields: []parser.ColumnDefinition{
+			{Name: "city", Type: parser.Type{Kind: parser.TypeString}},
+		}}},
+	})
+	if expected := map[string]bool{"id": true, "address": true, "city": true}; !reflect.DeepEqual(seen, expected) {
+		t.Errorf("columns %v, expected %v with the fields of the STRUCT", seen, expected)
+	}
+}
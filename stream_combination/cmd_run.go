@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"stream_combination/catalog"
+	"stream_combination/engine"
+	"stream_combination/parser"
+	"stream_combination/processor"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	file := fs.String("f", "", "query (.sql) or pipeline (.yaml) file")
+	var conn connectionFlags
+	conn.register(fs)
+	fs.Parse(args)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	nc, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer drain(nc, 10*time.Second)
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("error connecting to JetStream: %w", err)
+	}
+
+	// The pipeline outlives the signal, so that it can drain after one
+	pipelineCtx, cancelPipeline := context.WithCancel(context.Background())
+	defer cancelPipeline()
+
+	errorCh := make(chan error, 10)
+	var pipeline *processor.StreamProcessor
+	switch filepath.Ext(*file) {
+	case ".yaml", ".yml":
+		pipeline, err = buildFromConfig(pipelineCtx, js, *file, errorCh)
+	default:
+		pipeline, err = buildFromQuery(pipelineCtx, js, *file, errorCh)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Run pipeline in background and listen for errors
+	go func() {
+		if err := pipeline.Run(pipelineCtx); err != nil && pipelineCtx.Err() == nil && ctx.Err() == nil {
+			errorCh <- fmt.Errorf("pipeline error: %w", err)
+		}
+	}()
+
+	select {
+	case err := <-errorCh:
+		return err
+	case <-ctx.Done():
+		log.Println("Shutting down")
+		stopCtx, cancelStop := context.WithTimeout(context.Background(), engine.DrainTimeout)
+		defer cancelStop()
+		return pipeline.Stop(stopCtx)
+	}
+}
+
+func buildFromQuery(ctx context.Context, js jetstream.JetStream, file string, errorCh chan<- error) (*processor.StreamProcessor, error) {
+	query, err := readQuery(file)
+	if err != nil {
+		return nil, err
+	}
+	node, err := parser.ParseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepareQuery(ctx, js, node.(*parser.SelectNode)); err != nil {
+		return nil, err
+	}
+	builder := processor.NewProcessorBuilder(js)
+	if err := parser.Apply(node, builder); err != nil {
+		return nil, err
+	}
+	return builder.Build(ctx, errorCh)
+}
+
+// prepareQuery Find the streams of sources given as subjects, e.g. `FROM 'orders.*'`, and apply the schema catalog's
+// properties to the query's sources and type check it, as the engine does for queries run by the server
+func prepareQuery(ctx context.Context, js jetstream.JetStream, query *parser.SelectNode) error {
+	err := parser.ResolveSubjects(query, func(subjects []string) (string, error) {
+		return catalog.StreamForSubjects(ctx, js, subjects)
+	})
+	if err != nil {
+		return err
+	}
+	schemas, err := catalog.NewSchemas(ctx, js, catalog.DefaultSchemaBucket)
+	if err != nil {
+		return err
+	}
+	all, err := schemas.All(ctx)
+	if err != nil {
+		return err
+	}
+	parser.ApplyCatalogProperties(query, all)
+	return parser.Check(query, all)
+}
+
+func buildFromConfig(ctx context.Context, js jetstream.JetStream, file string, errorCh chan<- error) (*processor.StreamProcessor, error) {
+	cfg, err := processor.LoadProcessorConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	return processor.BuildFromConfig(ctx, js, cfg, errorCh)
+}
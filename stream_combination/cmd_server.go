@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"stream_combination/engine"
+	"stream_combination/processor"
+	"stream_combination/server"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func serverCommand(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	listen := fs.String("listen", envOrDefault("NSQL_LISTEN", ":8080"), "HTTP listen address")
+	registryBucket := fs.String("registry", engine.DefaultRegistryBucket, "KV bucket of persistent queries")
+	bufferSize := fs.Int("buffer-size", processor.DefaultBufferSize, "events buffered by each processor of a query")
+	instance := fs.String("instance", envOrDefault("NSQL_INSTANCE", ""), "ID of this instance in a cluster sharing persistent queries, or empty to run them all here")
+	clusterBucket := fs.String("cluster", engine.DefaultClusterBucket, "KV bucket of the cluster's instances")
+	leaseBucket := fs.String("leases", engine.DefaultLeaseBucket, "KV bucket of the leases of singleton queries")
+	checkpointBucket := fs.String("checkpoints", engine.DefaultCheckpointBucket, "object store of the checkpoints of singleton queries")
+	progressBucket := fs.String("progress", engine.DefaultProgressBucket, "KV bucket of how far the owners of join partitions have read")
+	var conn connectionFlags
+	conn.register(fs)
+	fs.Parse(args)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	nc, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer drain(nc, 10*time.Second)
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("error connecting to JetStream: %w", err)
+	}
+
+	queryEngine, err := openEngine(ctx, js, *registryBucket)
+	if err != nil {
+		return err
+	}
+	defer queryEngine.Close()
+	queryEngine.SetBufferSize(*bufferSize)
+	if *instance != "" {
+		cluster, err := engine.NewCluster(ctx, js, *clusterBucket, *instance)
+		if err != nil {
+			return err
+		}
+		progress, err := engine.NewProgress(ctx, js, *progressBucket)
+		if err != nil {
+			return err
+		}
+		queryEngine.SetCluster(cluster, progress)
+		leases, err := engine.NewLeases(ctx, js, *leaseBucket, *instance)
+		if err != nil {
+			return err
+		}
+		checkpoints, err := engine.NewCheckpoints(ctx, js, *checkpointBucket)
+		if err != nil {
+			return err
+		}
+		queryEngine.SetLeases(leases, checkpoints)
+	}
+	if err := queryEngine.Restore(ctx); err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{
+		Addr:    *listen,
+		Handler: server.New(queryEngine),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", *listen)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	}
+
+	// Stopping queries first ends any push queries still streaming
+	queryEngine.Close()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"stream_combination/parser"
+	"stream_combination/processor"
+)
+
+func validateCommand(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	file := fs.String("f", "", "query file")
+	schemaFile := fs.String("schema", "", "file of CREATE STREAM (...) declarations to type check against")
+	fs.Parse(args)
+
+	query, err := readQuery(*file)
+	if err != nil {
+		return err
+	}
+	schemas, err := readSchemas(*schemaFile)
+	if err != nil {
+		return err
+	}
+	if err := checkQuery(query, schemas); err != nil {
+		var semanticErrors parser.SemanticErrors
+		if errors.As(err, &semanticErrors) {
+			for _, semanticError := range semanticErrors {
+				fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", displayName(*file), semanticError.Line, semanticError.Column, semanticError.Message)
+			}
+			return fmt.Errorf("%d errors", len(semanticErrors))
+		}
+		return err
+	}
+	fmt.Printf("%s: OK\n", displayName(*file))
+	return nil
+}
+
+// checkQuery Parse a query, type check it against `schemas`, plan it and validate its DAG, without connecting to NATS.
+func checkQuery(query string, schemas map[string]parser.Schema) error {
+	node, err := parser.ParseSQL(query)
+	if err != nil {
+		return err
+	}
+	if err := parser.Check(node.(*parser.SelectNode), schemas); err != nil {
+		return err
+	}
+	builder := processor.NewProcessorBuilder(nil)
+	if err := parser.Apply(node, builder); err != nil {
+		return err
+	}
+	if errs := builder.Validate(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// readSchemas Schemas from a file of declarations ending in `;`. Without a file, nothing is type checked.
+func readSchemas(file string) (map[string]parser.Schema, error) {
+	schemas := make(map[string]parser.Schema)
+	if file == "" {
+		return schemas, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", file, err)
+	}
+	for _, text := range parser.SplitStatements(string(data)) {
+		statement, err := parser.ParseStatement(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		declare, ok := statement.(*parser.DeclareStream)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected CREATE STREAM (...) declarations, got %T", file, statement)
+		}
+		schemas[declare.Name] = declare.Schema()
+	}
+	return schemas, nil
+}
+
+func displayName(file string) string {
+	if file == "" || file == "-" {
+		return "<stdin>"
+	}
+	return file
+}
@@ -0,0 +1,128 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"stream_combination/models"
+
+	"github.com/hamba/avro/v2"
+)
+
+// avroCodec Avro binary records, without a container file header or schema registry prefix
+type avroCodec struct {
+	schema avro.Schema
+}
+
+func newAvroCodec(cfg Config) (*avroCodec, error) {
+	if cfg.Schema == "" {
+		return nil, errors.New("avro requires SCHEMA, an Avro schema file")
+	}
+	data, err := os.ReadFile(cfg.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Avro schema: %w", err)
+	}
+	schema, err := avro.Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema %s: %w", cfg.Schema, err)
+	}
+	if schema.Type() != avro.Record {
+		return nil, fmt.Errorf("Avro schema %s must be a record, got %s", cfg.Schema, schema.Type())
+	}
+	return &avroCodec{schema: schema}, nil
+}
+
+func newAvroDecoder(cfg Config) (Decoder, error) {
+	return newAvroCodec(cfg)
+}
+
+func newAvroEncoder(cfg Config) (Encoder, error) {
+	return newAvroCodec(cfg)
+}
+
+func (ac *avroCodec) Decode(data []byte) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	if err := avro.Unmarshal(ac.schema, data, &fields); err != nil {
+		return nil, fmt.Errorf("error decoding Avro: %w", err)
+	}
+	return normalise(fields)
+}
+
+func (ac *avroCodec) Encode(event models.EventLike) ([]byte, error) {
+	fields, err := eventFields(event)
+	if err != nil {
+		return nil, err
+	}
+	data, err := avro.Marshal(ac.schema, coerceAvro(ac.schema, fields))
+	if err != nil {
+		return nil, fmt.Errorf("error encoding Avro: %w", err)
+	}
+	return data, nil
+}
+
+func (ac *avroCodec) ContentType() string {
+	return "application/avro"
+}
+
+// coerceAvro Convert JSON decoded values, where every number is a float64, to the Go types the schema expects.
+func coerceAvro(schema avro.Schema, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch s := schema.(type) {
+	case *avro.RecordSchema:
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		coerced := make(map[string]interface{}, len(s.Fields()))
+		for _, field := range s.Fields() {
+			coerced[field.Name()] = coerceAvro(field.Type(), fields[field.Name()])
+		}
+		return coerced
+	case *avro.ArraySchema:
+		items, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+		coerced := make([]interface{}, len(items))
+		for i, item := range items {
+			coerced[i] = coerceAvro(s.Items(), item)
+		}
+		return coerced
+	case *avro.MapSchema:
+		entries, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		coerced := make(map[string]interface{}, len(entries))
+		for key, entry := range entries {
+			coerced[key] = coerceAvro(s.Values(), entry)
+		}
+		return coerced
+	case *avro.UnionSchema:
+		// Nullable fields are the common case: use the first type that isn't null
+		for _, member := range s.Types() {
+			if member.Type() != avro.Null {
+				return coerceAvro(member, value)
+			}
+		}
+		return value
+	case *avro.PrimitiveSchema:
+		number, ok := value.(float64)
+		if !ok {
+			return value
+		}
+		switch s.Type() {
+		case avro.Int:
+			return int(number)
+		case avro.Long:
+			return int64(number)
+		case avro.Float:
+			return float32(number)
+		}
+		return value
+	default:
+		return value
+	}
+}
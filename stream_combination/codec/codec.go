@@ -0,0 +1,122 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"stream_combination/models"
+	"strings"
+)
+
+// Decoder Turns a message payload into the fields of an event
+type Decoder interface {
+	Decode(data []byte) (map[string]interface{}, error)
+}
+
+// Encoder Serialises an event as a message payload
+type Encoder interface {
+	Encode(event models.EventLike) ([]byte, error)
+	ContentType() string
+}
+
+// Config How payloads are serialised. Schema is a file: a descriptor set for protobuf, or an Avro schema.
+type Config struct {
+	Format    string   `yaml:"format"`
+	Schema    string   `yaml:"schema,omitempty"`
+	Message   string   `yaml:"message,omitempty"`   // Protobuf: fully qualified message name
+	Columns   []string `yaml:"columns,omitempty"`   // CSV: column names, in order
+	Delimiter string   `yaml:"delimiter,omitempty"` // CSV: defaults to ','
+	// ContentTypeHeader Choose the format of each message from this header, when it's set
+	ContentTypeHeader string `yaml:"content_type_header,omitempty"`
+}
+
+// Format A payload format, with the constructors of its decoder and encoder
+type Format struct {
+	NewDecoder func(cfg Config) (Decoder, error)
+	NewEncoder func(cfg Config) (Encoder, error)
+}
+
+var formats = map[string]Format{
+	"json":        {NewDecoder: newJSONDecoder, NewEncoder: newJSONEncoder},
+	"protobuf":    {NewDecoder: newProtobufDecoder, NewEncoder: newProtobufEncoder},
+	"avro":        {NewDecoder: newAvroDecoder, NewEncoder: newAvroEncoder},
+	"msgpack":     {NewDecoder: newMsgpackDecoder, NewEncoder: newMsgpackEncoder},
+	"messagepack": {NewDecoder: newMsgpackDecoder, NewEncoder: newMsgpackEncoder},
+	"csv":         {NewDecoder: newCSVDecoder, NewEncoder: newCSVEncoder},
+	"raw":         {NewDecoder: newRawDecoder, NewEncoder: newRawEncoder},
+}
+
+// Register Add a format, or replace a built-in one. Names are case-insensitive.
+func Register(name string, format Format) {
+	formats[strings.ToLower(name)] = format
+}
+
+func lookup(name string) (Format, error) {
+	if name == "" {
+		name = "json"
+	}
+	format, ok := formats[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(formats))
+		for known := range formats {
+			names = append(names, known)
+		}
+		sort.Strings(names)
+		return Format{}, fmt.Errorf("unknown format %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return format, nil
+}
+
+// NewDecoder The decoder for a format. Without a format, payloads are JSON.
+func NewDecoder(cfg Config) (Decoder, error) {
+	format, err := lookup(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	return format.NewDecoder(cfg)
+}
+
+// NewEncoder The encoder for a format. Without a format, payloads are JSON.
+func NewEncoder(cfg Config) (Encoder, error) {
+	format, err := lookup(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	return format.NewEncoder(cfg)
+}
+
+// ConfigFromProperties The format set in a `WITH (FORMAT='...', SCHEMA='...')` clause. Other properties are ignored.
+func ConfigFromProperties(properties map[string]string) Config {
+	cfg := Config{
+		Format:            properties["FORMAT"],
+		Schema:            properties["SCHEMA"],
+		Message:           properties["MESSAGE"],
+		Delimiter:         properties["DELIMITER"],
+		ContentTypeHeader: properties["CONTENT_TYPE_HEADER"],
+	}
+	if columns := properties["COLUMNS"]; columns != "" {
+		for _, column := range strings.Split(columns, ",") {
+			cfg.Columns = append(cfg.Columns, strings.TrimSpace(column))
+		}
+	}
+	return cfg
+}
+
+// normalise Convert decoded values to the types JSON decoding produces, e.g. float64 for every number,
+// so expressions behave the same whatever the payload format.
+func normalise(value interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// eventFields The fields of an event, as JSON decoding would produce them
+func eventFields(event models.EventLike) (map[string]interface{}, error) {
+	return normalise(event)
+}
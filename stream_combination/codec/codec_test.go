@@ -0,0 +1,195 @@
+package codec
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"stream_combination/models"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const avroSchema = `{"type": "record", "name": "Order", "fields": [
+	{"name": "id", "type": "long"},
+	{"name": "item", "type": "string"},
+	{"name": "price", "type": ["null", "double"]}
+]}`
+
+// writeFile Write `data` to a file of the test's temporary directory, returning its path
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// orderDescriptorSet A descriptor set with an `orders.Order` message, as `protoc --descriptor_set_out` writes it
+func orderDescriptorSet(t *testing.T) []byte {
+	t.Helper()
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   kind.Enum(),
+		}
+	}
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("orders.proto"),
+		Package: proto.String("orders"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Order"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+				field("item", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("price", 3, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE),
+			},
+		}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	order := map[string]interface{}{"id": float64(1), "item": "book", "price": 9.5}
+	tests := []struct {
+		name        string
+		cfg         func(t *testing.T) Config
+		fields      map[string]interface{}
+		contentType string
+		// expected The decoded fields, when they aren't `fields`
+		expected map[string]interface{}
+	}{
+		{"json", func(*testing.T) Config { return Config{} }, order, "application/json", nil},
+		{"msgpack", func(*testing.T) Config { return Config{Format: "msgpack"} }, order, "application/msgpack", nil},
+		{"messagepack alias", func(*testing.T) Config { return Config{Format: "MessagePack"} }, order, "application/msgpack", nil},
+		// CSV values stay strings
+		{"csv", func(*testing.T) Config { return Config{Format: "csv", Columns: []string{"id", "item", "price"}} }, order, "text/csv",
+			map[string]interface{}{"id": "1", "item": "book", "price": "9.5"}},
+		{"csv with a delimiter", func(*testing.T) Config {
+			return Config{Format: "csv", Columns: []string{"id", "item"}, Delimiter: ";"}
+		}, map[string]interface{}{"id": "1", "item": "a;b"}, "text/csv", nil},
+		{"raw", func(*testing.T) Config { return Config{Format: "raw"} },
+			map[string]interface{}{RawField: "hello"}, "application/octet-stream", nil},
+		{"avro", func(t *testing.T) Config {
+			return Config{Format: "avro", Schema: writeFile(t, "order.avsc", []byte(avroSchema))}
+		}, order, "application/avro", nil},
+		{"avro null union", func(t *testing.T) Config {
+			return Config{Format: "avro", Schema: writeFile(t, "order.avsc", []byte(avroSchema))}
+		}, map[string]interface{}{"id": float64(2), "item": "pen", "price": nil}, "application/avro", nil},
+		{"protobuf", func(t *testing.T) Config {
+			return Config{Format: "protobuf", Schema: writeFile(t, "orders.pb", orderDescriptorSet(t)), Message: "orders.Order"}
+		}, order, "application/x-protobuf", nil},
+		// Unpopulated fields are decoded with their zero values, and unknown columns are dropped
+		{"protobuf unpopulated and unknown fields", func(t *testing.T) Config {
+			return Config{Format: "protobuf", Schema: writeFile(t, "orders.pb", orderDescriptorSet(t)), Message: "orders.Order"}
+		}, map[string]interface{}{"id": float64(3), "note": "dropped"}, "application/x-protobuf",
+			map[string]interface{}{"id": float64(3), "item": "", "price": float64(0)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := test.cfg(t)
+			encoder, err := NewEncoder(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoder, err := NewDecoder(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoder.ContentType() != test.contentType {
+				t.Errorf("content type %s, expected %s", encoder.ContentType(), test.contentType)
+			}
+			data, err := encoder.Encode(models.NewEvent(time.Now(), test.fields))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decoder.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := test.expected
+			if expected == nil {
+				expected = test.fields
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("decoded %v, expected %v", got, expected)
+			}
+		})
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"unknown format", Config{Format: "xml"}},
+		{"csv without columns", Config{Format: "csv"}},
+		{"csv delimiter of two characters", Config{Format: "csv", Columns: []string{"id"}, Delimiter: "||"}},
+		{"avro without a schema", Config{Format: "avro"}},
+		{"avro schema missing", Config{Format: "avro", Schema: "missing.avsc"}},
+		{"protobuf without a message", Config{Format: "protobuf", Schema: "orders.pb"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewDecoder(test.cfg); err == nil {
+				t.Error("expected the decoder to fail")
+			}
+			if _, err := NewEncoder(test.cfg); err == nil {
+				t.Error("expected the encoder to fail")
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		data string
+	}{
+		{"invalid json", Config{}, `{"id": `},
+		{"invalid msgpack", Config{Format: "msgpack"}, "\xc1"},
+		{"csv with too many values", Config{Format: "csv", Columns: []string{"id"}}, "1,2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoder, err := NewDecoder(test.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := decoder.Decode([]byte(test.data)); err == nil {
+				t.Error("expected decoding to fail")
+			}
+		})
+	}
+}
+
+func TestRawEncodeRequiresPayload(t *testing.T) {
+	encoder, _ := NewEncoder(Config{Format: "raw"})
+	if _, err := encoder.Encode(models.NewEvent(time.Now(), map[string]interface{}{"id": 1})); err == nil {
+		t.Errorf("expected an event without a %s field to fail", RawField)
+	}
+}
+
+func TestConfigFromProperties(t *testing.T) {
+	cfg := ConfigFromProperties(map[string]string{
+		"FORMAT":     "csv",
+		"COLUMNS":    "id, item ,price",
+		"DELIMITER":  ";",
+		"PARTITIONS": "4",
+	})
+	expected := Config{Format: "csv", Columns: []string{"id", "item", "price"}, Delimiter: ";"}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("config %+v, expected %+v", cfg, expected)
+	}
+}
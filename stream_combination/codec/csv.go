@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"stream_combination/models"
+	"strings"
+	"unicode/utf8"
+)
+
+// csvCodec One CSV record per message, with the columns named by Config.Columns
+type csvCodec struct {
+	columns   []string
+	delimiter rune
+}
+
+func newCSVCodec(cfg Config) (*csvCodec, error) {
+	if len(cfg.Columns) == 0 {
+		return nil, errors.New("CSV requires COLUMNS, the names of its columns in order")
+	}
+	delimiter := ','
+	if cfg.Delimiter != "" {
+		if utf8.RuneCountInString(cfg.Delimiter) != 1 {
+			return nil, fmt.Errorf("CSV delimiter must be one character, got %q", cfg.Delimiter)
+		}
+		delimiter, _ = utf8.DecodeRuneInString(cfg.Delimiter)
+	}
+	return &csvCodec{columns: cfg.Columns, delimiter: delimiter}, nil
+}
+
+func newCSVDecoder(cfg Config) (Decoder, error) {
+	return newCSVCodec(cfg)
+}
+
+func newCSVEncoder(cfg Config) (Encoder, error) {
+	return newCSVCodec(cfg)
+}
+
+// Decode Values stay strings, and are compared as numbers or booleans when they parse as one
+func (cc *csvCodec) Decode(data []byte) (map[string]interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = cc.delimiter
+	reader.FieldsPerRecord = -1
+	record, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error decoding CSV: %w", err)
+	}
+	if len(record) > len(cc.columns) {
+		return nil, fmt.Errorf("CSV record has %d values, expected at most %d", len(record), len(cc.columns))
+	}
+	fields := make(map[string]interface{}, len(cc.columns))
+	for i, value := range record {
+		fields[cc.columns[i]] = value
+	}
+	return fields, nil
+}
+
+func (cc *csvCodec) Encode(event models.EventLike) ([]byte, error) {
+	record := make([]string, len(cc.columns))
+	for i, column := range cc.columns {
+		if value := event.GetField(column); value != nil {
+			record[i] = fmt.Sprintf("%v", value)
+		}
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Comma = cc.delimiter
+	if err := writer.Write(record); err != nil {
+		return nil, err
+	}
+	writer.Flush()
+	return []byte(strings.TrimSuffix(buf.String(), "\n")), writer.Error()
+}
+
+func (cc *csvCodec) ContentType() string {
+	return "text/csv"
+}
@@ -0,0 +1,33 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"stream_combination/models"
+)
+
+type jsonCodec struct{}
+
+func newJSONDecoder(Config) (Decoder, error) {
+	return jsonCodec{}, nil
+}
+
+func newJSONEncoder(Config) (Encoder, error) {
+	return jsonCodec{}, nil
+}
+
+func (jsonCodec) Decode(data []byte) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("error decoding JSON: %w", err)
+	}
+	return fields, nil
+}
+
+func (jsonCodec) Encode(event models.EventLike) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"fmt"
+	"stream_combination/models"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type msgpackCodec struct{}
+
+func newMsgpackDecoder(Config) (Decoder, error) {
+	return msgpackCodec{}, nil
+}
+
+func newMsgpackEncoder(Config) (Encoder, error) {
+	return msgpackCodec{}, nil
+}
+
+func (msgpackCodec) Decode(data []byte) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	if err := msgpack.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("error decoding MessagePack: %w", err)
+	}
+	return normalise(fields)
+}
+
+func (msgpackCodec) Encode(event models.EventLike) ([]byte, error) {
+	fields, err := eventFields(event)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(fields)
+}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
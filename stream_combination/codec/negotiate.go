@@ -0,0 +1,93 @@
+package codec
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// contentTypes The format of each content type, without parameters such as `charset`
+var contentTypes = map[string]string{
+	"application/json":         "json",
+	"application/msgpack":      "msgpack",
+	"application/x-msgpack":    "msgpack",
+	"application/vnd.msgpack":  "msgpack",
+	"application/protobuf":     "protobuf",
+	"application/x-protobuf":   "protobuf",
+	"application/avro":         "avro",
+	"avro/binary":              "avro",
+	"text/csv":                 "csv",
+	"application/octet-stream": "raw",
+}
+
+// RegisterContentType Decode payloads with this content type using the named format.
+func RegisterContentType(contentType string, format string) {
+	contentTypes[strings.ToLower(contentType)] = format
+}
+
+// UnknownContentTypeError A message's content type has no registered format
+type UnknownContentTypeError struct {
+	ContentType string
+}
+
+func (e UnknownContentTypeError) Error() string {
+	return fmt.Sprintf("unknown content type %q", e.ContentType)
+}
+
+// Negotiator Chooses a decoder per message from its content type, using the configured format when it has none.
+// Decoders are created on first use, with the options of the configured format, e.g. its SCHEMA.
+type Negotiator struct {
+	cfg      Config
+	fallback Decoder
+	mu       sync.Mutex
+	decoders map[string]Decoder
+}
+
+func NewNegotiator(cfg Config) (*Negotiator, error) {
+	fallback, err := NewDecoder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Negotiator{
+		cfg:      cfg,
+		fallback: fallback,
+		decoders: make(map[string]Decoder),
+	}, nil
+}
+
+func (n *Negotiator) Decode(contentType string, data []byte) (map[string]interface{}, error) {
+	decoder, err := n.decoder(contentType)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.Decode(data)
+}
+
+func (n *Negotiator) decoder(contentType string) (Decoder, error) {
+	if contentType == "" {
+		return n.fallback, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, UnknownContentTypeError{ContentType: contentType}
+	}
+	format, ok := contentTypes[mediaType]
+	if !ok {
+		return nil, UnknownContentTypeError{ContentType: contentType}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if decoder, ok := n.decoders[format]; ok {
+		return decoder, nil
+	}
+	cfg := n.cfg
+	cfg.Format = format
+	decoder, err := NewDecoder(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("content type %s: %w", contentType, err)
+	}
+	n.decoders[format] = decoder
+	return decoder, nil
+}
@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNegotiator(t *testing.T) {
+	negotiator, err := NewNegotiator(Config{Format: "csv", Columns: []string{"id", "item"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		data        string
+		expected    map[string]interface{}
+		unknown     bool
+	}{
+		{"no content type uses the configured format", "", "1,book", map[string]interface{}{"id": "1", "item": "book"}, false},
+		{"json", "application/json", `{"id": 1}`, map[string]interface{}{"id": float64(1)}, false},
+		{"parameters are ignored", "application/json; charset=utf-8", `{"id": 2}`, map[string]interface{}{"id": float64(2)}, false},
+		{"configured options apply to other formats", "text/csv", "3,pen", map[string]interface{}{"id": "3", "item": "pen"}, false},
+		{"raw", "application/octet-stream", "bytes", map[string]interface{}{RawField: "bytes"}, false},
+		{"unknown", "application/xml", "<order/>", nil, true},
+		{"invalid", "not a content type;", "", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := negotiator.Decode(test.contentType, []byte(test.data))
+			var unknown UnknownContentTypeError
+			if test.unknown {
+				if !errors.As(err, &unknown) {
+					t.Errorf("error %v, expected an unknown content type", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("decoded %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"stream_combination/models"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protobufCodec Messages of one type from a descriptor set, e.g. from `protoc --include_imports --descriptor_set_out`.
+// Fields are named as in the .proto file.
+type protobufCodec struct {
+	message protoreflect.MessageType
+}
+
+func newProtobufCodec(cfg Config) (*protobufCodec, error) {
+	if cfg.Schema == "" || cfg.Message == "" {
+		return nil, errors.New("protobuf requires SCHEMA, a descriptor set file, and MESSAGE, the message name")
+	}
+	data, err := os.ReadFile(cfg.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("error reading descriptor set: %w", err)
+	}
+	var descriptorSet descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &descriptorSet); err != nil {
+		return nil, fmt.Errorf("error parsing descriptor set %s: %w", cfg.Schema, err)
+	}
+	files, err := protodesc.NewFiles(&descriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %w", cfg.Schema, err)
+	}
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(cfg.Message))
+	if err != nil {
+		return nil, fmt.Errorf("message %s not found in %s: %w", cfg.Message, cfg.Schema, err)
+	}
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", cfg.Message)
+	}
+	return &protobufCodec{message: dynamicpb.NewMessageType(messageDescriptor)}, nil
+}
+
+func newProtobufDecoder(cfg Config) (Decoder, error) {
+	return newProtobufCodec(cfg)
+}
+
+func newProtobufEncoder(cfg Config) (Encoder, error) {
+	return newProtobufCodec(cfg)
+}
+
+func (pc *protobufCodec) Decode(data []byte) (map[string]interface{}, error) {
+	message := pc.message.New().Interface()
+	if err := proto.Unmarshal(data, message); err != nil {
+		return nil, fmt.Errorf("error decoding protobuf: %w", err)
+	}
+	// The canonical JSON mapping takes care of enums, well-known types and 64-bit integers
+	jsonData, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return jsonCodec{}.Decode(jsonData)
+}
+
+func (pc *protobufCodec) Encode(event models.EventLike) ([]byte, error) {
+	jsonData, err := jsonCodec{}.Encode(event)
+	if err != nil {
+		return nil, err
+	}
+	message := pc.message.New().Interface()
+	// Columns that aren't in the message are dropped
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(jsonData, message); err != nil {
+		return nil, fmt.Errorf("error encoding protobuf: %w", err)
+	}
+	return proto.Marshal(message)
+}
+
+func (pc *protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
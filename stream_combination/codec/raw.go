@@ -0,0 +1,35 @@
+package codec
+
+import (
+	"fmt"
+	"stream_combination/models"
+)
+
+// RawField The field raw payloads are read into and written from
+const RawField = "payload"
+
+type rawCodec struct{}
+
+func newRawDecoder(Config) (Decoder, error) {
+	return rawCodec{}, nil
+}
+
+func newRawEncoder(Config) (Encoder, error) {
+	return rawCodec{}, nil
+}
+
+func (rawCodec) Decode(data []byte) (map[string]interface{}, error) {
+	return map[string]interface{}{RawField: string(data)}, nil
+}
+
+func (rawCodec) Encode(event models.EventLike) ([]byte, error) {
+	value := event.GetField(RawField)
+	if value == nil {
+		return nil, fmt.Errorf("raw output requires a %s field", RawField)
+	}
+	return []byte(fmt.Sprintf("%v", value)), nil
+}
+
+func (rawCodec) ContentType() string {
+	return "application/octet-stream"
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"stream_combination/processor"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const DefaultCheckpointBucket = "nsql_checkpoints"
+
+// CheckpointInterval How often the leader of a singleton query checkpoints it while it runs
+const CheckpointInterval = 30 * time.Second
+
+// Checkpoints The state of singleton queries, e.g. their join windows, stored in an object store as one object
+// per processor, named `queryID/key` after the keys of ProcessorBuilder.Checkpointers
+type Checkpoints struct {
+	store jetstream.ObjectStore
+}
+
+func NewCheckpoints(ctx context.Context, js jetstream.JetStream, bucket string) (*Checkpoints, error) {
+	store, err := js.CreateOrUpdateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket:      bucket,
+		Description: "nsql query checkpoints",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint store %s: %w", bucket, err)
+	}
+	return &Checkpoints{store: store}, nil
+}
+
+// Save Checkpoint each processor of a query, then, once every checkpoint is saved, commit them, which acks the
+// messages they cover. Nothing is committed if any fails, so its messages are covered by the next checkpoint
+// instead, or redelivered to the next run of the query.
+func (c *Checkpoints) Save(ctx context.Context, queryID string, checkpointers map[string]processor.Checkpointer) error {
+	var commits []func()
+	for key, checkpointer := range checkpointers {
+		data, commit, err := checkpointer.Checkpoint()
+		if err != nil {
+			return fmt.Errorf("failed to checkpoint query %s: %w", queryID, err)
+		}
+		if _, err := c.store.PutBytes(ctx, queryID+"/"+key, data); err != nil {
+			return fmt.Errorf("failed to save checkpoint of query %s: %w", queryID, err)
+		}
+		commits = append(commits, commit)
+	}
+	for _, commit := range commits {
+		commit()
+	}
+	return nil
+}
+
+// Restore Restore each processor from its last checkpoint, leaving those that were never checkpointed empty
+func (c *Checkpoints) Restore(ctx context.Context, queryID string, checkpointers map[string]processor.Checkpointer) error {
+	for key, checkpointer := range checkpointers {
+		data, err := c.store.GetBytes(ctx, queryID+"/"+key)
+		if errors.Is(err, jetstream.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read checkpoint of query %s: %w", queryID, err)
+		}
+		if err := checkpointer.Restore(data); err != nil {
+			return fmt.Errorf("failed to restore query %s: %w", queryID, err)
+		}
+	}
+	return nil
+}
+
+// Delete Remove every checkpoint of a query
+func (c *Checkpoints) Delete(ctx context.Context, queryID string) error {
+	objects, err := c.store.List(ctx)
+	if errors.Is(err, jetstream.ErrNoObjectsFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list checkpoints: %w", err)
+	}
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Name, queryID+"/") {
+			continue
+		}
+		if err := c.store.Delete(ctx, object.Name); err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			return fmt.Errorf("failed to delete checkpoint of query %s: %w", queryID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const DefaultClusterBucket = "nsql_instances"
+
+const (
+	// HeartbeatInterval How often an instance refreshes its entry in the cluster bucket
+	HeartbeatInterval = 5 * time.Second
+	// MemberTimeout How long an instance is a member after its last heartbeat
+	MemberTimeout = 3 * HeartbeatInterval
+)
+
+// member The entry of an instance in the cluster bucket
+type member struct {
+	ID      string    `json:"id"`
+	Started time.Time `json:"started"`
+}
+
+// Cluster The engine instances sharing the registry's persistent queries. Each instance keeps an entry in a KV
+// bucket alive with heartbeats, and watches the bucket for the others.
+type Cluster struct {
+	kv      jetstream.KeyValue
+	self    member
+	mu      sync.Mutex
+	members map[string]time.Time // instance ID -> when its last heartbeat was seen
+}
+
+func NewCluster(ctx context.Context, js jetstream.JetStream, bucket string, instanceID string) (*Cluster, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "nsql engine instances",
+		TTL:         MemberTimeout, // entries of instances that stopped without leaving expire
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cluster bucket %s: %w", bucket, err)
+	}
+	return &Cluster{
+		kv:      kv,
+		self:    member{ID: instanceID, Started: time.Now()},
+		members: make(map[string]time.Time),
+	}, nil
+}
+
+// Self The ID of this instance
+func (c *Cluster) Self() string {
+	return c.self.ID
+}
+
+// Members The IDs of the instances seen within MemberTimeout, including this one, sorted
+func (c *Cluster) Members() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := []string{c.self.ID}
+	for id, seen := range c.members {
+		if id != c.self.ID && time.Since(seen) < MemberTimeout {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Owner The member that owns `key`, by rendezvous hashing, so every instance agrees on the owner given the same
+// members, and only the keys of an instance that joins or leaves move.
+func (c *Cluster) Owner(key string) string {
+	return owner(key, c.Members())
+}
+
+func owner(key string, members []string) string {
+	var best string
+	var bestScore uint64
+	for _, id := range members {
+		// FNV hashes of IDs that differ only in early bytes keep their order for every key, so a hash that mixes well
+		sum := sha256.Sum256([]byte(id + "\x00" + key))
+		if score := binary.BigEndian.Uint64(sum[:8]); best == "" || score > bestScore {
+			best, bestScore = id, score
+		}
+	}
+	return best
+}
+
+// Join Announce this instance, and call `onChange` with the members once they're known, then whenever an instance
+// joins or leaves, until `ctx` is done, when this instance leaves.
+func (c *Cluster) Join(ctx context.Context, onChange func(members []string)) error {
+	if err := c.heartbeat(ctx); err != nil {
+		return err
+	}
+	watcher, err := c.kv.WatchAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to watch cluster: %w", err)
+	}
+	go func() {
+		defer watcher.Stop()
+		defer c.leave()
+		ticker := time.NewTicker(HeartbeatInterval)
+		defer ticker.Stop()
+		members := c.Members()
+		changed := func() {
+			if current := c.Members(); !slices.Equal(current, members) {
+				members = current
+				slog.Info("Cluster members changed", "members", members)
+				onChange(members)
+			}
+		}
+		for {
+			select {
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				if entry == nil {
+					// nil marks the end of the initial values, which are the first members
+					members = c.Members()
+					onChange(members)
+					continue
+				}
+				c.mu.Lock()
+				if op := entry.Operation(); op == jetstream.KeyValueDelete || op == jetstream.KeyValuePurge {
+					delete(c.members, entry.Key())
+				} else {
+					c.members[entry.Key()] = time.Now()
+				}
+				c.mu.Unlock()
+				changed()
+			case <-ticker.C:
+				if err := c.heartbeat(ctx); err != nil {
+					slog.Warn("Cluster heartbeat failed", "error", err)
+				}
+				// Instances that stopped without leaving drop out once they miss their heartbeats
+				changed()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *Cluster) heartbeat(ctx context.Context) error {
+	data, err := json.Marshal(c.self)
+	if err != nil {
+		return err
+	}
+	if _, err := c.kv.Put(ctx, c.self.ID, data); err != nil {
+		return fmt.Errorf("failed to announce instance %s: %w", c.self.ID, err)
+	}
+	return nil
+}
+
+// leave Remove this instance's entry, so the others rebalance without waiting for it to time out
+func (c *Cluster) leave() {
+	ctx, cancel := context.WithTimeout(context.Background(), HeartbeatInterval)
+	defer cancel()
+	if err := c.kv.Delete(ctx, c.self.ID); err != nil {
+		slog.Warn("Failed to leave cluster", "instance", c.self.ID, "error", err)
+	}
+}
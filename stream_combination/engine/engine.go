@@ -0,0 +1,553 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"stream_combination/catalog"
+	"stream_combination/codec"
+	"stream_combination/models"
+	"stream_combination/parser"
+	"stream_combination/processor"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type QueryState string
+
+const (
+	QueryStateRunning    QueryState = "RUNNING"
+	QueryStateFailed     QueryState = "FAILED"
+	QueryStateTerminated QueryState = "TERMINATED"
+)
+
+// Query A running query, with one StreamProcessor and one error channel of its own
+type Query struct {
+	ID         string
+	SQL        string
+	Persistent bool
+	Created    time.Time
+
+	mu        sync.Mutex
+	state     QueryState
+	lastError error
+	errorCh   chan error
+	cancel    context.CancelFunc
+	done      chan struct{}
+	rows      <-chan models.EventLike // nil for persistent queries
+	pipeline  *processor.StreamProcessor
+	placement Placement
+	// checkpointers The processors with state, which are checkpointed while a singleton query runs
+	checkpointers map[string]processor.Checkpointer
+}
+
+// QueryInfo A snapshot of a Query, as reported by the API
+type QueryInfo struct {
+	ID         string     `json:"id"`
+	SQL        string     `json:"sql"`
+	State      QueryState `json:"state"`
+	Persistent bool       `json:"persistent"`
+	Created    time.Time  `json:"created"`
+	Error      string     `json:"error,omitempty"`
+	// Buffers How full each processor's buffer is, while the query runs on this instance
+	Buffers []processor.BufferUsage `json:"buffers,omitempty"`
+	// Partitions The join partitions this instance runs, when the query is shared by a cluster
+	Partitions []int `json:"partitions,omitempty"`
+}
+
+func (q *Query) Info() QueryInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	info := QueryInfo{
+		ID:         q.ID,
+		SQL:        q.SQL,
+		State:      q.state,
+		Persistent: q.Persistent,
+		Created:    q.Created,
+	}
+	if q.lastError != nil {
+		info.Error = q.lastError.Error()
+	}
+	if q.pipeline != nil && q.state == QueryStateRunning {
+		info.Buffers = q.pipeline.Saturation()
+	}
+	info.Partitions = q.placement.Partitions
+	return info
+}
+
+// Rows Results of a transient query. Read alongside Done, as the channel is never closed.
+func (q *Query) Rows() <-chan models.EventLike {
+	return q.rows
+}
+
+// Done Closed once the query has stopped, whether terminated or failed.
+func (q *Query) Done() <-chan struct{} {
+	return q.done
+}
+
+// Err The error that failed the query, if any
+func (q *Query) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.lastError
+}
+
+func (q *Query) record() QueryRecord {
+	info := q.Info()
+	return QueryRecord{
+		ID:      info.ID,
+		SQL:     info.SQL,
+		State:   info.State,
+		Error:   info.Error,
+		Created: info.Created,
+	}
+}
+
+func (q *Query) stop(state QueryState, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.state != QueryStateRunning {
+		return
+	}
+	q.state = state
+	q.lastError = err
+	q.cancel()
+	close(q.done)
+}
+
+// DrainTimeout How long a terminated persistent query has to drain the events it has read before it's forced to stop
+const DrainTimeout = 10 * time.Second
+
+// terminate Stop reading, let the rows already read reach the sink and then stop the query, forcing it to stop
+// after `timeout`. Transient queries are stopped straight away, as nothing reads their rows once they're terminated.
+func (q *Query) terminate(timeout time.Duration) {
+	if q.Persistent && q.pipeline != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := q.pipeline.Stop(ctx); err != nil {
+			slog.Warn("Query stopped before draining", "id", q.ID, "error", err)
+		}
+	}
+	q.stop(QueryStateTerminated, nil)
+}
+
+// watch Fail the query on the first error reported by its processors.
+func (q *Query) watch(ctx context.Context) {
+	select {
+	case err := <-q.errorCh:
+		slog.Error("Query failed", "id", q.ID, "error", err)
+		q.stop(QueryStateFailed, err)
+	case <-ctx.Done():
+		q.stop(QueryStateTerminated, nil)
+	}
+}
+
+// Engine Runs queries against JetStream, each with its own StreamProcessor
+type Engine struct {
+	js       jetstream.JetStream
+	registry *Registry        // nil when persistent queries aren't stored
+	schemas  *catalog.Schemas // nil when queries aren't type checked
+	cluster  *Cluster         // nil when this instance runs every persistent query by itself
+	// progress How far the owners of join partitions have read, or nil to read from the start of the stream
+	progress *Progress
+	// leases Elect the instance of the cluster running each singleton query, or nil to share them like any other
+	leases      *Leases
+	checkpoints *Checkpoints                  // nil when singleton queries aren't checkpointed
+	leading     map[string]context.CancelFunc // singleton query ID -> stops competing for its lease
+	leaders     sync.WaitGroup                // the goroutines competing for leases
+	// rebalancing Held while this instance's shares of persistent queries are started and stopped
+	rebalancing sync.Mutex
+	mu          sync.Mutex
+	queries     map[string]*Query
+	// bufferSize The buffer size of each query's processors, unless the query sets its own
+	bufferSize int
+}
+
+func New(js jetstream.JetStream, registry *Registry, schemas *catalog.Schemas) *Engine {
+	return &Engine{
+		js:       js,
+		registry: registry,
+		schemas:  schemas,
+		queries:  make(map[string]*Query),
+		leading:  make(map[string]context.CancelFunc),
+	}
+}
+
+// SetBufferSize Set the buffer size of each processor in queries started afterwards, which `CREATE STREAM`
+// overrides with `WITH (BUFFER_SIZE=...)`.
+func (e *Engine) SetBufferSize(size int) {
+	e.bufferSize = size
+}
+
+// Schemas The schema catalog queries are type checked against, or nil
+func (e *Engine) Schemas() *catalog.Schemas {
+	return e.schemas
+}
+
+// prepare Find the streams of sources given as subjects, apply the catalog's properties, e.g. payload formats,
+// to a statement's sources and type check it against the declared schemas of the streams it reads.
+func (e *Engine) prepare(ctx context.Context, statement parser.Statement) error {
+	if err := e.resolveSubjects(ctx, statement); err != nil {
+		return err
+	}
+	if e.schemas == nil {
+		return nil
+	}
+	schemas, err := e.schemas.All(ctx)
+	if err != nil {
+		return err
+	}
+	parser.ApplyCatalogProperties(statement, schemas)
+	return parser.Check(statement, schemas)
+}
+
+func (e *Engine) resolveSubjects(ctx context.Context, statement parser.Statement) error {
+	return parser.ResolveSubjects(statement, func(subjects []string) (string, error) {
+		return catalog.StreamForSubjects(ctx, e.js, subjects)
+	})
+}
+
+// outputSink The sink of `CREATE STREAM ... AS`, writing to a stream named after the new stream. The subject
+// is the stream's name unless `WITH (SUBJECT='purchases.{tenant}')` gives a template.
+func outputSink(js jetstream.JetStream, createStream *parser.CreateStreamAs) (*processor.JetStreamSink, error) {
+	subject := createStream.Properties["SUBJECT"]
+	if subject == "" {
+		subject = createStream.Name
+	}
+	var duplicateWindow time.Duration
+	if window := createStream.Properties["DUPLICATE_WINDOW"]; window != "" {
+		var err error
+		if duplicateWindow, err = time.ParseDuration(window); err != nil {
+			return nil, fmt.Errorf("invalid DUPLICATE_WINDOW %q: %w", window, err)
+		}
+	}
+	return processor.NewJetStreamSink(js, processor.StreamOutput{
+		Stream:          createStream.Name,
+		Subject:         subject,
+		FallbackSubject: createStream.Properties["FALLBACK_SUBJECT"],
+		Encoding:        codec.ConfigFromProperties(createStream.Properties),
+		MsgID:           createStream.Properties["MSG_ID"],
+		DuplicateWindow: duplicateWindow,
+	})
+}
+
+// Restore Restart every query in the registry, and stop local queries when they're removed from it. In a cluster,
+// only this instance's share of each query is started, and the shares are rebalanced as instances come and go.
+func (e *Engine) Restore(ctx context.Context) error {
+	if e.registry == nil {
+		return nil
+	}
+	if e.cluster != nil {
+		return e.restoreShared(ctx)
+	}
+	records, err := e.registry.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if _, err := e.startPersistent(record.ID, record.SQL, record.Created, Placement{}); err != nil {
+			slog.Error("Failed to restore query", "id", record.ID, "error", err)
+			record.State = QueryStateFailed
+			record.Error = err.Error()
+			if err := e.registry.Put(ctx, record); err != nil {
+				slog.Error("Failed to update query", "id", record.ID, "error", err)
+			}
+			continue
+		}
+		slog.Info("Restored query", "id", record.ID)
+	}
+	// TERMINATE from another instance removes the query from the registry
+	return e.registry.WatchDeletes(ctx, func(id string) {
+		if query, ok := e.Get(id); ok {
+			e.forget(id)
+			query.terminate(DrainTimeout)
+		}
+	})
+}
+
+// StartPersistent Run a query until it's terminated, registering it so it's restarted with the engine.
+// `CREATE STREAM ... AS` writes to the new stream, a plain query to the console.
+func (e *Engine) StartPersistent(sql string) (*Query, error) {
+	statement, err := parser.ParseStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+	var id string
+	switch s := statement.(type) {
+	case *parser.CreateStreamAs:
+		id = "CSAS_" + strings.ToUpper(s.Name)
+	case *parser.SelectNode:
+		id = "QUERY_" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	default:
+		return nil, fmt.Errorf("expected a query or CREATE STREAM AS, got %T", statement)
+	}
+	if _, exists := e.Get(id); exists {
+		return nil, fmt.Errorf("query %s is already running", id)
+	}
+
+	var query *Query
+	if e.cluster != nil {
+		// Every instance, including this one, starts its share once the query is registered
+		if query, err = e.checkShared(id, sql, time.Now()); err != nil {
+			return nil, err
+		}
+	} else if query, err = e.startPersistent(id, sql, time.Now(), Placement{}); err != nil {
+		return nil, err
+	}
+	if e.registry != nil {
+		if err := e.registry.Create(context.Background(), query.record()); err != nil {
+			// Stop the query started here, leaving the registry to whoever registered the query first
+			if running, ok := e.Get(id); ok {
+				e.forget(id)
+				running.terminate(DrainTimeout)
+			}
+			return nil, err
+		}
+	}
+	return query, nil
+}
+
+func (e *Engine) startPersistent(id string, sql string, created time.Time, placement Placement) (*Query, error) {
+	builder, query, err := e.persistentBuilder(sql, placement)
+	if err != nil {
+		return nil, err
+	}
+	return e.start(context.Background(), id, sql, query, builder, nil, created, placement)
+}
+
+// persistentBuilder A builder for the share of a persistent query given by `placement`, and the query it runs
+func (e *Engine) persistentBuilder(sql string, placement Placement) (*processor.ProcessorBuilder, *parser.SelectNode, error) {
+	statement, err := parser.ParseStatement(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	builder := processor.NewProcessorBuilder(e.js)
+	builder.SetBufferSize(e.bufferSize)
+	builder.SetPartitions(placement.Partitions)
+	builder.SetSharedConsumer(placement.Consumer)
+	if placement.Singleton && e.checkpoints != nil {
+		builder.SetCheckpointInterval(CheckpointInterval)
+	}
+	var query *parser.SelectNode
+	switch s := statement.(type) {
+	case *parser.CreateStreamAs:
+		if size := s.Properties["BUFFER_SIZE"]; size != "" {
+			n, err := strconv.Atoi(size)
+			if err != nil || n <= 0 {
+				return nil, nil, fmt.Errorf("invalid BUFFER_SIZE %q, expected a positive number", size)
+			}
+			builder.SetBufferSize(n)
+		}
+		batch, err := batchConfig(s.Properties)
+		if err != nil {
+			return nil, nil, err
+		}
+		builder.SetBatch(batch)
+		sink, err := outputSink(e.js, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := sink.EnsureStream(ctx); err != nil {
+			return nil, nil, err
+		}
+		builder.SetSinkFactory(func() processor.MessageProcessor { return sink })
+		query = s.Query
+	case *parser.SelectNode:
+		query = s
+	default:
+		return nil, nil, fmt.Errorf("expected a query or CREATE STREAM AS, got %T", statement)
+	}
+	return builder, query, nil
+}
+
+// batchConfig The micro-batching of `CREATE STREAM ... WITH (BATCH_SIZE=100, BATCH_LATENCY='5ms')`, off unless
+// BATCH_SIZE is given
+func batchConfig(properties map[string]string) (processor.BatchConfig, error) {
+	var cfg processor.BatchConfig
+	if size := properties["BATCH_SIZE"]; size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid BATCH_SIZE %q, expected a positive number", size)
+		}
+		cfg.Size = n
+	}
+	if latency := properties["BATCH_LATENCY"]; latency != "" {
+		d, err := time.ParseDuration(latency)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid BATCH_LATENCY %q, expected a positive duration", latency)
+		}
+		cfg.MaxLatency = d
+	}
+	return cfg, nil
+}
+
+// StartTransient Run a push query whose rows are read from Query.Rows. It stops when `ctx` is cancelled.
+func (e *Engine) StartTransient(ctx context.Context, sql string) (*Query, error) {
+	node, err := parser.ParseSQL(sql)
+	if err != nil {
+		return nil, err
+	}
+	builder := processor.NewProcessorBuilder(e.js)
+	builder.SetBufferSize(e.bufferSize)
+	sink := processor.NewChannelSink(builder.BufferSize())
+	builder.SetSinkFactory(func() processor.MessageProcessor { return sink })
+	return e.start(ctx, uuid.New().String(), sql, node.(*parser.SelectNode), builder, sink, time.Now(), Placement{})
+}
+
+func (e *Engine) start(ctx context.Context, id string, sql string, selectNode *parser.SelectNode, builder *processor.ProcessorBuilder, sink *processor.ChannelSink, created time.Time, placement Placement) (*Query, error) {
+	if err := e.prepare(ctx, selectNode); err != nil {
+		return nil, err
+	}
+	if err := parser.Apply(selectNode, builder); err != nil {
+		return nil, err
+	}
+	checkpointers := builder.Checkpointers()
+	if placement.Singleton && e.checkpoints != nil {
+		if err := e.checkpoints.Restore(ctx, id, checkpointers); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.resume(ctx, id, builder, placement); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	query := &Query{
+		ID:         id,
+		SQL:        sql,
+		Persistent: sink == nil,
+		Created:    created,
+		state:      QueryStateRunning,
+		errorCh:    make(chan error, 10),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		placement:  placement,
+		// Only singleton queries are checkpointed
+		checkpointers: checkpointers,
+	}
+	if sink != nil {
+		query.rows = sink.Events()
+	}
+
+	pipeline, err := builder.Build(ctx, query.errorCh)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	query.pipeline = pipeline
+
+	e.mu.Lock()
+	e.queries[query.ID] = query
+	e.mu.Unlock()
+
+	go query.watch(ctx)
+	if e.progress != nil && len(placement.Partitions) > 0 {
+		go e.recordProgress(query, builder.Readers())
+	}
+	go func() {
+		if err := pipeline.Run(ctx); err != nil && ctx.Err() == nil {
+			query.stop(QueryStateFailed, err)
+		}
+	}()
+	go func() {
+		<-query.done
+		if !query.Persistent {
+			// Transient queries are forgotten once they stop
+			e.forget(query.ID)
+		} else if err := query.Err(); err != nil && e.registry != nil {
+			if err := e.registry.Put(context.Background(), query.record()); err != nil {
+				slog.Error("Failed to update query", "id", query.ID, "error", err)
+			}
+		}
+	}()
+	slog.Info("Started query", "id", query.ID, "persistent", query.Persistent)
+	return query, nil
+}
+
+func (e *Engine) forget(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.queries, id)
+}
+
+func (e *Engine) Get(id string) (*Query, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	query, ok := e.queries[id]
+	return query, ok
+}
+
+// List Every known query, oldest first
+func (e *Engine) List() []QueryInfo {
+	e.mu.Lock()
+	infos := make([]QueryInfo, 0, len(e.queries))
+	for _, query := range e.queries {
+		infos = append(infos, query.Info())
+	}
+	e.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.Before(infos[j].Created) })
+	return infos
+}
+
+// Terminate Stop a query and remove it from the registry.
+func (e *Engine) Terminate(id string) error {
+	query, running := e.Get(id)
+	if running {
+		e.forget(id)
+		query.terminate(DrainTimeout)
+		if !query.Persistent {
+			return nil
+		}
+	}
+	if e.registry == nil {
+		if !running {
+			return fmt.Errorf("query %s not found", id)
+		}
+		return nil
+	}
+	if !running {
+		if _, err := e.registry.Get(context.Background(), id); err != nil {
+			return fmt.Errorf("query %s not found", id)
+		}
+	}
+	return e.registry.Delete(context.Background(), id)
+}
+
+// Close Stop every query running in this engine, draining persistent queries, which stay registered. Singleton
+// queries this instance leads are checkpointed and their leases released, for standbys to take over.
+func (e *Engine) Close() {
+	e.mu.Lock()
+	for id, cancel := range e.leading {
+		cancel()
+		delete(e.leading, id)
+	}
+	e.mu.Unlock()
+	e.leaders.Wait()
+
+	e.mu.Lock()
+	queries := make([]*Query, 0, len(e.queries))
+	for _, query := range e.queries {
+		queries = append(queries, query)
+	}
+	e.queries = make(map[string]*Query)
+	e.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, query := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query.terminate(DrainTimeout)
+		}()
+	}
+	wg.Wait()
+}
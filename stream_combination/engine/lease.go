@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const DefaultLeaseBucket = "nsql_leases"
+
+const (
+	// LeaseTTL How long a lease is held after its last renewal, and so how long a singleton query is down once the
+	// instance running it dies
+	LeaseTTL = 10 * time.Second
+	// LeaseRenewInterval How often a leader renews its lease, and standbys try to take it
+	LeaseRenewInterval = LeaseTTL / 3
+)
+
+// ErrLeaseLost The cause of a leader's context ending when its lease couldn't be renewed, so another instance may
+// have taken it
+var ErrLeaseLost = errors.New("lease lost")
+
+// lease The entry of a lease in the lease bucket
+type lease struct {
+	Holder   string    `json:"holder"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// Leases Leader election among the instances of a cluster, through leases in a KV bucket whose entries expire
+// unless they're renewed. A lease is taken by creating its key, and renewed by updating the revision its holder
+// last wrote, so an instance that missed its renewals can't renew a lease another instance has taken since.
+type Leases struct {
+	kv       jetstream.KeyValue
+	holder   string
+	interval time.Duration // LeaseRenewInterval
+}
+
+func NewLeases(ctx context.Context, js jetstream.JetStream, bucket string, holder string) (*Leases, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "nsql singleton query leases",
+		TTL:         LeaseTTL, // leases of instances that stopped renewing them expire
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lease bucket %s: %w", bucket, err)
+	}
+	return &Leases{kv: kv, holder: holder, interval: LeaseRenewInterval}, nil
+}
+
+// Holder The ID of this instance, as written to the leases it holds
+func (l *Leases) Holder() string {
+	return l.holder
+}
+
+// Lead Compete for the lease of `key` until `ctx` is done, calling `lead` whenever this instance takes it. The
+// context of `lead` ends when the lease is lost, with ErrLeaseLost as its cause, or when `ctx` is done, when the
+// lease is released once `lead` returns, so a standby takes over without waiting for it to expire. If `lead`
+// returns by itself, e.g. because its query failed, the lease is released too, and this instance sits out one
+// round of the election, so that a standby gets the first chance to take over.
+func (l *Leases) Lead(ctx context.Context, key string, lead func(ctx context.Context)) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	var value []byte
+	var revision uint64
+	var stop context.CancelCauseFunc // set while this instance holds the lease
+	var ended chan struct{}          // closed once `lead` returns, while this instance holds the lease
+	var leading sync.WaitGroup
+	sitOut := false
+	for {
+		if stop == nil && sitOut {
+			sitOut = false
+		} else if stop == nil {
+			var err error
+			value, err = json.Marshal(lease{Holder: l.holder, Acquired: time.Now()})
+			if err != nil {
+				slog.Error("Error marshalling lease", "key", key, "error", err)
+				return
+			}
+			if revision, err = l.kv.Create(ctx, key, value); err == nil {
+				slog.Info("Acquired lease", "key", key, "holder", l.holder)
+				var leadCtx context.Context
+				leadCtx, stop = context.WithCancelCause(ctx)
+				ended = make(chan struct{})
+				leading.Add(1)
+				go func(ended chan struct{}) {
+					defer leading.Done()
+					defer close(ended)
+					lead(leadCtx)
+				}(ended)
+			} else if !errors.Is(err, jetstream.ErrKeyExists) && ctx.Err() == nil {
+				slog.Warn("Failed to acquire lease", "key", key, "error", err)
+			}
+		} else if rev, err := l.kv.Update(ctx, key, value, revision); err == nil {
+			revision = rev
+		} else if ctx.Err() == nil {
+			slog.Warn("Lost lease", "key", key, "holder", l.holder, "error", err)
+			stop(ErrLeaseLost)
+			leading.Wait()
+			stop, ended = nil, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ended:
+			slog.Info("Singleton stopped while leading, giving up its lease", "key", key, "holder", l.holder)
+			stop(nil)
+			leading.Wait()
+			l.release(key, revision)
+			stop, ended = nil, nil
+			sitOut = true
+		case <-ctx.Done():
+			if stop != nil {
+				stop(ctx.Err())
+				leading.Wait()
+				l.release(key, revision)
+			}
+			return
+		}
+	}
+}
+
+// release Give up a lease, unless it has been renewed by someone else since `revision`
+func (l *Leases) release(key string, revision uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.interval)
+	defer cancel()
+	if err := l.kv.Delete(ctx, key, jetstream.LastRevision(revision)); err != nil {
+		slog.Warn("Failed to release lease", "key", key, "holder", l.holder, "error", err)
+		return
+	}
+	slog.Info("Released lease", "key", key, "holder", l.holder)
+}
@@ -0,0 +1,284 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeKV The part of a KV bucket leases, progress and the registry use, in memory. Entries don't expire; expire removes one as if it had.
+type fakeKV struct {
+	jetstream.KeyValue
+	mu        sync.Mutex
+	revisions map[string]uint64
+	values    map[string][]byte
+	last      uint64
+	watchers  map[*fakeWatcher]bool
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{revisions: make(map[string]uint64), values: make(map[string][]byte), watchers: make(map[*fakeWatcher]bool)}
+}
+
+func (kv *fakeKV) Create(ctx context.Context, key string, value []byte, opts ...jetstream.KVCreateOpt) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if _, exists := kv.revisions[key]; exists {
+		return 0, jetstream.ErrKeyExists
+	}
+	return kv.put(key, value), nil
+}
+
+func (kv *fakeKV) Update(ctx context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if current, exists := kv.revisions[key]; !exists || current != revision {
+		return 0, errors.New("wrong last sequence")
+	}
+	return kv.put(key, value), nil
+}
+
+func (kv *fakeKV) Delete(ctx context.Context, key string, opts ...jetstream.KVDeleteOpt) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.revisions, key)
+	delete(kv.values, key)
+	kv.last++
+	kv.notify(fakeEntry{key: key, revision: kv.last, operation: jetstream.KeyValueDelete})
+	return nil
+}
+
+func (kv *fakeKV) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.put(key, value), nil
+}
+
+func (kv *fakeKV) Get(ctx context.Context, key string) (jetstream.KeyValueEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, exists := kv.values[key]
+	if !exists {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return fakeEntry{key: key, value: value, revision: kv.revisions[key]}, nil
+}
+
+// ListKeysFiltered Only takes filters of the form `prefix.>`
+func (kv *fakeKV) ListKeysFiltered(ctx context.Context, filters ...string) (jetstream.KeyLister, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	keys := make(chan string, len(kv.values))
+	for key := range kv.values {
+		for _, filter := range filters {
+			if strings.HasPrefix(key, strings.TrimSuffix(filter, ">")) {
+				keys <- key
+				break
+			}
+		}
+	}
+	close(keys)
+	return fakeLister(keys), nil
+}
+
+// WatchAll Ignores its options: the current entries are sent, then nil, then every change
+func (kv *fakeKV) WatchAll(ctx context.Context, opts ...jetstream.WatchOpt) (jetstream.KeyWatcher, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	watcher := &fakeWatcher{kv: kv, updates: make(chan jetstream.KeyValueEntry, len(kv.values)+100)}
+	for key, value := range kv.values {
+		watcher.updates <- fakeEntry{key: key, value: value, revision: kv.revisions[key]}
+	}
+	watcher.updates <- nil
+	kv.watchers[watcher] = true
+	return watcher, nil
+}
+
+type fakeEntry struct {
+	jetstream.KeyValueEntry
+	key       string
+	value     []byte
+	revision  uint64
+	operation jetstream.KeyValueOp
+}
+
+func (e fakeEntry) Key() string                     { return e.key }
+func (e fakeEntry) Value() []byte                   { return e.value }
+func (e fakeEntry) Revision() uint64                { return e.revision }
+func (e fakeEntry) Operation() jetstream.KeyValueOp { return e.operation }
+
+type fakeWatcher struct {
+	jetstream.KeyWatcher
+	kv      *fakeKV
+	updates chan jetstream.KeyValueEntry
+}
+
+func (w *fakeWatcher) Updates() <-chan jetstream.KeyValueEntry { return w.updates }
+
+func (w *fakeWatcher) Stop() error {
+	w.kv.mu.Lock()
+	defer w.kv.mu.Unlock()
+	delete(w.kv.watchers, w)
+	return nil
+}
+
+type fakeLister chan string
+
+func (l fakeLister) Keys() <-chan string { return l }
+func (l fakeLister) Stop() error         { return nil }
+
+func (kv *fakeKV) put(key string, value []byte) uint64 {
+	kv.last++
+	kv.revisions[key] = kv.last
+	kv.values[key] = value
+	kv.notify(fakeEntry{key: key, value: value, revision: kv.last})
+	return kv.last
+}
+
+// notify Send a change to every watcher, dropping it for watchers that aren't reading
+func (kv *fakeKV) notify(entry fakeEntry) {
+	for watcher := range kv.watchers {
+		select {
+		case watcher.updates <- entry:
+		default:
+		}
+	}
+}
+
+// expire Remove a lease as if its holder had stopped renewing it, and let `holder` take it
+func (kv *fakeKV) expire(key string, holder string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.put(key, []byte(holder))
+}
+
+// leader Records the leadership of one instance
+type leader struct {
+	mu     sync.Mutex
+	starts int
+	causes []error // why each leadership ended
+	// returns Whether `lead` returns by itself straight away, rather than leading until its context ends
+	returns bool
+}
+
+func (ld *leader) lead(ctx context.Context) {
+	ld.mu.Lock()
+	ld.starts++
+	returns := ld.returns
+	ld.mu.Unlock()
+	if returns {
+		return
+	}
+	<-ctx.Done()
+	ld.mu.Lock()
+	ld.causes = append(ld.causes, context.Cause(ctx))
+	ld.mu.Unlock()
+}
+
+func (ld *leader) state() (starts int, causes []error) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	return ld.starts, append([]error(nil), ld.causes...)
+}
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// instance Compete for `key` as `holder` until the returned function is called, which waits for Lead to return
+func instance(kv *fakeKV, holder string, key string, ld *leader) (stop func()) {
+	leases := &Leases{kv: kv, holder: holder, interval: 5 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		leases.Lead(ctx, key, ld.lead)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestLeasesElectOneLeader(t *testing.T) {
+	kv := newFakeKV()
+	a, b := &leader{}, &leader{}
+	stopA := instance(kv, "a", "q", a)
+	defer stopA()
+	stopB := instance(kv, "b", "q", b)
+	defer stopB()
+
+	eventually(t, "one instance leads", func() bool {
+		startsA, _ := a.state()
+		startsB, _ := b.state()
+		return startsA+startsB > 0
+	})
+	time.Sleep(50 * time.Millisecond) // several rounds of the election
+	startsA, _ := a.state()
+	startsB, _ := b.state()
+	if startsA+startsB != 1 {
+		t.Errorf("led %d times by a and %d by b, expected once in all", startsA, startsB)
+	}
+}
+
+func TestLeasesHandOver(t *testing.T) {
+	tests := []struct {
+		name string
+		// returns Whether a's singleton stops by itself as soon as it starts
+		returns bool
+		// end End the leadership of `a`, which leads
+		end   func(kv *fakeKV, stopA func())
+		cause error // why a's leadership ended, or nil if `lead` returned by itself
+	}{
+		{"instance stops", false, func(kv *fakeKV, stopA func()) { stopA() }, context.Canceled},
+		{"lease lost", false, func(kv *fakeKV, stopA func()) { kv.expire("q", "c") }, ErrLeaseLost},
+		{"singleton stops by itself", true, func(kv *fakeKV, stopA func()) {}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kv := newFakeKV()
+			a, b := &leader{returns: test.returns}, &leader{}
+			stopA := instance(kv, "a", "q", a)
+			var stopOnce sync.Once
+			defer stopOnce.Do(stopA)
+			eventually(t, "a leads", func() bool { starts, _ := a.state(); return starts >= 1 })
+
+			stopB := instance(kv, "b", "q", b)
+			defer stopB()
+			test.end(kv, func() { stopOnce.Do(stopA) })
+
+			if test.cause != nil {
+				eventually(t, "a stops leading", func() bool { _, causes := a.state(); return len(causes) == 1 })
+				if _, causes := a.state(); !errors.Is(causes[0], test.cause) {
+					t.Errorf("a stopped leading because of %v, expected %v", causes[0], test.cause)
+				}
+			}
+			if test.cause == ErrLeaseLost {
+				// The lease is another instance's until it expires
+				kv.Delete(context.Background(), "q")
+			}
+			eventually(t, "b takes over", func() bool { starts, _ := b.state(); return starts == 1 })
+		})
+	}
+}
+
+func TestLeasesReenterElectionAfterSingletonStops(t *testing.T) {
+	kv := newFakeKV()
+	a := &leader{returns: true}
+	stop := instance(kv, "a", "q", a)
+	defer stop()
+	// Without a standby, the instance takes the lease back once it has sat out a round
+	eventually(t, "a leads three times", func() bool { starts, _ := a.state(); return starts >= 3 })
+}
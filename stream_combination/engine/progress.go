@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"stream_combination/processor"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const DefaultProgressBucket = "nsql_progress"
+
+// ProgressInterval How often the owner of join partitions records how far it has read their sources
+const ProgressInterval = 5 * time.Second
+
+// Progress How far the owners of the join partitions of shared queries have read each source, kept in a KV bucket
+// as the watermark of the source, under `queryID.partition.source`. The next owner of a partition starts reading
+// far enough before the watermark to rebuild the partition's window, rather than from the start of the stream.
+type Progress struct {
+	kv jetstream.KeyValue
+}
+
+func NewProgress(ctx context.Context, js jetstream.JetStream, bucket string) (*Progress, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "nsql join partition progress",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open progress bucket %s: %w", bucket, err)
+	}
+	return &Progress{kv: kv}, nil
+}
+
+func progressKey(queryID string, partition int, source string) string {
+	return fmt.Sprintf("%s.%d.%s", queryID, partition, source)
+}
+
+// Record Record the watermark of a source for each of `partitions`
+func (p *Progress) Record(ctx context.Context, queryID string, partitions []int, source string, watermark time.Time) error {
+	value := []byte(watermark.UTC().Format(time.RFC3339Nano))
+	for _, partition := range partitions {
+		if _, err := p.kv.Put(ctx, progressKey(queryID, partition, source), value); err != nil {
+			return fmt.Errorf("failed to record progress of query %s: %w", queryID, err)
+		}
+	}
+	return nil
+}
+
+// Start Where to read a source from for `partitions`: `span` before the earliest of their watermarks, so the events
+// still in their windows are read again along with those that weren't done with. Zero if a partition has no
+// watermark, e.g. the first time the query runs, in which case the source is read as it's configured to be.
+func (p *Progress) Start(ctx context.Context, queryID string, partitions []int, source string, span time.Duration) (time.Time, error) {
+	var start time.Time
+	for _, partition := range partitions {
+		entry, err := p.kv.Get(ctx, progressKey(queryID, partition, source))
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return time.Time{}, nil
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read progress of query %s: %w", queryID, err)
+		}
+		watermark, err := time.Parse(time.RFC3339Nano, string(entry.Value()))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid progress of query %s: %w", queryID, err)
+		}
+		if start.IsZero() || watermark.Before(start) {
+			start = watermark
+		}
+	}
+	if start.IsZero() {
+		return start, nil
+	}
+	return start.Add(-span), nil
+}
+
+// Delete Remove the progress of every partition of a query
+func (p *Progress) Delete(ctx context.Context, queryID string) error {
+	keys, err := p.kv.ListKeysFiltered(ctx, queryID+".>")
+	if err != nil {
+		return fmt.Errorf("failed to list progress of query %s: %w", queryID, err)
+	}
+	defer keys.Stop()
+	for key := range keys.Keys() {
+		if err := p.kv.Delete(ctx, key); err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+			return fmt.Errorf("failed to delete progress of query %s: %w", queryID, err)
+		}
+	}
+	return nil
+}
+
+// resume Start each source of this instance's share of a partitioned query from the progress of its partitions
+func (e *Engine) resume(ctx context.Context, id string, builder *processor.ProcessorBuilder, placement Placement) error {
+	if e.progress == nil || len(placement.Partitions) == 0 {
+		return nil
+	}
+	for source, reader := range builder.Readers() {
+		start, err := e.progress.Start(ctx, id, placement.Partitions, source, builder.WindowSpan())
+		if err != nil {
+			return err
+		}
+		if !start.IsZero() {
+			reader.SetStartTime(start)
+		}
+	}
+	return nil
+}
+
+// recordProgress Record the watermarks of the sources of this instance's share of a partitioned query every
+// ProgressInterval, and once more when it stops, so a drained query records every message it read.
+func (e *Engine) recordProgress(query *Query, readers map[string]*processor.SubjectReader) {
+	record := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ProgressInterval)
+		defer cancel()
+		for source, reader := range readers {
+			watermark := reader.Watermark()
+			if watermark.IsZero() {
+				continue
+			}
+			if err := e.progress.Record(ctx, query.ID, query.placement.Partitions, source, watermark); err != nil {
+				slog.Warn("Failed to record progress", "id", query.ID, "error", err)
+			}
+		}
+	}
+	ticker := time.NewTicker(ProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			record()
+		case <-query.done:
+			record()
+			return
+		}
+	}
+}
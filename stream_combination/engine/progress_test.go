@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProgressStart(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		recorded   map[int]time.Time // watermark of source "0" by partition
+		partitions []int
+		expected   time.Time
+	}{
+		{"never recorded", nil, []int{0}, time.Time{}},
+		{"one partition", map[int]time.Time{0: at}, []int{0}, at.Add(-time.Hour)},
+		{"earliest of several partitions", map[int]time.Time{0: at, 1: at.Add(-time.Minute)}, []int{0, 1}, at.Add(-time.Hour - time.Minute)},
+		{"only the partitions owned", map[int]time.Time{0: at, 1: at.Add(-time.Minute)}, []int{0}, at.Add(-time.Hour)},
+		// A partition that was never read would miss the messages before the others' watermarks
+		{"a partition not recorded", map[int]time.Time{0: at}, []int{0, 1}, time.Time{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			progress := &Progress{kv: newFakeKV()}
+			for partition, watermark := range test.recorded {
+				if err := progress.Record(ctx, "q", []int{partition}, "0", watermark); err != nil {
+					t.Fatal(err)
+				}
+			}
+			start, err := progress.Start(ctx, "q", test.partitions, "0", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !start.Equal(test.expected) {
+				t.Errorf("start %v, expected %v", start, test.expected)
+			}
+		})
+	}
+}
+
+func TestProgressDelete(t *testing.T) {
+	ctx := context.Background()
+	kv := newFakeKV()
+	progress := &Progress{kv: kv}
+	now := time.Now()
+	for _, id := range []string{"q", "q2"} {
+		if err := progress.Record(ctx, id, []int{0, 1}, "0", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := progress.Delete(ctx, "q"); err != nil {
+		t.Fatal(err)
+	}
+	if start, _ := progress.Start(ctx, "q", []int{0}, "0", 0); !start.IsZero() {
+		t.Error("the deleted query still has progress")
+	}
+	if start, _ := progress.Start(ctx, "q2", []int{0, 1}, "0", 0); start.IsZero() {
+		t.Error("the progress of another query was deleted")
+	}
+}
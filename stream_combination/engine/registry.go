@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const DefaultRegistryBucket = "nsql_queries"
+
+// QueryRecord A persistent query as stored in the registry
+type QueryRecord struct {
+	ID      string            `json:"id"`
+	SQL     string            `json:"sql"`
+	State   QueryState        `json:"state"`
+	Error   string            `json:"error,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+	Created time.Time         `json:"created"`
+}
+
+// Registry Persistent queries, stored in a JetStream KV bucket keyed by query ID
+type Registry struct {
+	kv jetstream.KeyValue
+}
+
+func NewRegistry(ctx context.Context, js jetstream.JetStream, bucket string) (*Registry, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "nsql persistent queries",
+		History:     5,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open query registry %s: %w", bucket, err)
+	}
+	return &Registry{kv: kv}, nil
+}
+
+// Create Register a new query, failing with an error wrapping jetstream.ErrKeyExists if a query with its ID is
+// already registered, e.g. by another instance that ran the same CREATE STREAM AS
+func (r *Registry) Create(ctx context.Context, record QueryRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling query %s: %w", record.ID, err)
+	}
+	if _, err := r.kv.Create(ctx, record.ID, data); err != nil {
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return fmt.Errorf("query %s is already registered: %w", record.ID, err)
+		}
+		return fmt.Errorf("failed to register query %s: %w", record.ID, err)
+	}
+	return nil
+}
+
+// Put Update a registered query, e.g. with the error that failed it
+func (r *Registry) Put(ctx context.Context, record QueryRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling query %s: %w", record.ID, err)
+	}
+	if _, err := r.kv.Put(ctx, record.ID, data); err != nil {
+		return fmt.Errorf("failed to register query %s: %w", record.ID, err)
+	}
+	return nil
+}
+
+// SetState Record the state of a registered query, and the error that failed it, if any. A query deleted in the
+// meantime stays deleted.
+func (r *Registry) SetState(ctx context.Context, id string, state QueryState, reason string) error {
+	entry, err := r.kv.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to read query %s: %w", id, err)
+	}
+	var record QueryRecord
+	if err := json.Unmarshal(entry.Value(), &record); err != nil {
+		return fmt.Errorf("error unmarshalling query %s: %w", id, err)
+	}
+	if record.State == state && record.Error == reason {
+		return nil
+	}
+	record.State, record.Error = state, reason
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling query %s: %w", id, err)
+	}
+	if _, err := r.kv.Update(ctx, id, data, entry.Revision()); err != nil {
+		return fmt.Errorf("failed to update query %s: %w", id, err)
+	}
+	return nil
+}
+
+func (r *Registry) Get(ctx context.Context, id string) (*QueryRecord, error) {
+	entry, err := r.kv.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query %s: %w", id, err)
+	}
+	var record QueryRecord
+	if err := json.Unmarshal(entry.Value(), &record); err != nil {
+		return nil, fmt.Errorf("error unmarshalling query %s: %w", id, err)
+	}
+	return &record, nil
+}
+
+func (r *Registry) Delete(ctx context.Context, id string) error {
+	if err := r.kv.Delete(ctx, id); err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return fmt.Errorf("failed to deregister query %s: %w", id, err)
+	}
+	return nil
+}
+
+// List Every registered query
+func (r *Registry) List(ctx context.Context) ([]QueryRecord, error) {
+	watcher, err := r.kv.WatchAll(ctx, jetstream.IgnoreDeletes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queries: %w", err)
+	}
+	defer watcher.Stop()
+
+	var records []QueryRecord
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			// nil marks the end of the initial values
+			break
+		}
+		var record QueryRecord
+		if err := json.Unmarshal(entry.Value(), &record); err != nil {
+			return nil, fmt.Errorf("error unmarshalling query %s: %w", entry.Key(), err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// WatchDeletes Call `onDelete` with the ID of each query removed from the registry, until `ctx` is done.
+func (r *Registry) WatchDeletes(ctx context.Context, onDelete func(id string)) error {
+	return r.Watch(ctx, nil, onDelete)
+}
+
+// Watch Call `onPut` with each query registered or updated, and `onDelete` with the ID of each query removed,
+// until `ctx` is done. Either may be nil.
+func (r *Registry) Watch(ctx context.Context, onPut func(record QueryRecord), onDelete func(id string)) error {
+	watcher, err := r.kv.WatchAll(ctx, jetstream.UpdatesOnly())
+	if err != nil {
+		return fmt.Errorf("failed to watch queries: %w", err)
+	}
+	go func() {
+		defer watcher.Stop()
+		for {
+			select {
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				if entry == nil {
+					continue
+				}
+				switch entry.Operation() {
+				case jetstream.KeyValueDelete, jetstream.KeyValuePurge:
+					if onDelete != nil {
+						onDelete(entry.Key())
+					}
+				default:
+					if onPut == nil {
+						continue
+					}
+					var record QueryRecord
+					if err := json.Unmarshal(entry.Value(), &record); err != nil {
+						slog.Error("Error unmarshalling query", "id", entry.Key(), "error", err)
+						continue
+					}
+					onPut(record)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestRegistrySetState(t *testing.T) {
+	ctx := context.Background()
+	registry := &Registry{kv: newFakeKV()}
+	record := QueryRecord{ID: "CSAS_TOTALS", SQL: "CREATE STREAM totals AS SELECT * FROM orders", State: QueryStateRunning, Created: time.Now()}
+	if err := registry.Create(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		state  QueryState
+		reason string
+	}{
+		{"failed to start", QueryStateFailed, "stream orders not found"},
+		// A later leader starts it
+		{"started", QueryStateRunning, ""},
+		{"unchanged", QueryStateRunning, ""},
+	}
+	for _, step := range steps {
+		if err := registry.SetState(ctx, record.ID, step.state, step.reason); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		got, err := registry.Get(ctx, record.ID)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got.State != step.state || got.Error != step.reason || got.SQL != record.SQL {
+			t.Errorf("%s: registered as %+v, expected %s with error %q", step.name, got, step.state, step.reason)
+		}
+	}
+
+	if err := registry.Delete(ctx, record.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.SetState(ctx, record.ID, QueryStateFailed, "drained"); err == nil {
+		t.Error("expected setting the state of a deleted query to fail")
+	}
+	if _, err := registry.Get(ctx, record.ID); err == nil {
+		t.Error("setting the state of a deleted query registered it again")
+	}
+}
+
+func TestRegistryRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	kv := newFakeKV()
+	registry := &Registry{kv: kv}
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := []QueryRecord{
+		{ID: "CSAS_TOTALS", SQL: "CREATE STREAM totals AS SELECT * FROM orders", State: QueryStateRunning, Created: created},
+		{ID: "CSAS_EU", SQL: "CREATE STREAM eu AS SELECT * FROM orders WITH (BUFFER_SIZE=10)", State: QueryStateRunning,
+			Options: map[string]string{"BUFFER_SIZE": "10"}, Created: created},
+	}
+	for _, record := range records {
+		if err := registry.Create(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := registry.Create(ctx, records[0]); !errors.Is(err, jetstream.ErrKeyExists) {
+		t.Errorf("registering a query twice failed with %v, expected ErrKeyExists", err)
+	}
+	got, err := registry.Get(ctx, "CSAS_EU")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, records[1]) {
+		t.Errorf("read %+v, expected %+v", *got, records[1])
+	}
+
+	// A registry opened on the same bucket, as when the engine restarts, lists every query
+	restarted := &Registry{kv: kv}
+	if err := restarted.Delete(ctx, "CSAS_TOTALS"); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := restarted.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(listed, records[1:]) {
+		t.Errorf("listed %+v, expected %+v", listed, records[1:])
+	}
+	if err := restarted.Delete(ctx, "CSAS_TOTALS"); err != nil {
+		t.Errorf("deleting a deleted query failed: %v", err)
+	}
+}
+
+func TestRestoreStopsQueriesTerminatedElsewhere(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry := &Registry{kv: newFakeKV()}
+	e := New(nil, registry, nil)
+	if err := e.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	queryCtx, stop := context.WithCancel(ctx)
+	query := &Query{ID: "CSAS_TOTALS", Persistent: true, state: QueryStateRunning, cancel: stop, done: make(chan struct{})}
+	e.mu.Lock()
+	e.queries[query.ID] = query
+	e.mu.Unlock()
+	if err := registry.Create(ctx, query.record()); err != nil {
+		t.Fatal(err)
+	}
+	// TERMINATE on another instance
+	if err := registry.Delete(ctx, query.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-query.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the query kept running once it was removed from the registry")
+	}
+	if queryCtx.Err() == nil || query.Info().State != QueryStateTerminated {
+		t.Errorf("query %s, expected it to be terminated", query.Info().State)
+	}
+	if _, ok := e.Get(query.ID); ok {
+		t.Error("the engine still lists the terminated query")
+	}
+}
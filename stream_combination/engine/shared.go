@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"stream_combination/parser"
+	"time"
+)
+
+// Placement The share of a persistent query an instance of a cluster runs
+type Placement struct {
+	Partitions []int  // the join partitions the instance owns, or nil for all of them
+	Consumer   string // prefix of the durable consumers shared by every instance running the query, or ""
+	Singleton  bool   // run only by the instance holding the query's lease, from its last checkpoint
+}
+
+func (p Placement) equal(other Placement) bool {
+	return p.Consumer == other.Consumer && p.Singleton == other.Singleton && slices.Equal(p.Partitions, other.Partitions)
+}
+
+// SetCluster Share persistent queries with the other instances of `cluster`, rather than running all of them,
+// recording how far the owners of join partitions have read in `progress`.
+func (e *Engine) SetCluster(cluster *Cluster, progress *Progress) {
+	e.cluster = cluster
+	e.progress = progress
+}
+
+// placement This instance's share of a persistent query. Queries without joins run on every instance, which
+// read each source through one shared durable consumer, so each message is processed by one of them. Each
+// partition of a query's joins is owned by one instance, which reads every message and keeps the keys of the
+// partitions it owns, starting from where the partitions' previous owners got to, see Progress. `runs` is false if this instance owns none of them. Singleton queries are run by whichever
+// instance holds their lease, see compete.
+func (e *Engine) placement(id string, sql string) (placement Placement, runs bool, err error) {
+	statement, err := parser.ParseStatement(sql)
+	if err != nil {
+		return Placement{}, false, err
+	}
+	var query *parser.SelectNode
+	switch s := statement.(type) {
+	case *parser.CreateStreamAs:
+		query = s.Query
+	case *parser.SelectNode:
+		query = s
+	default:
+		return Placement{}, false, fmt.Errorf("expected a query or CREATE STREAM AS, got %T", statement)
+	}
+	if e.leases != nil && singleton(statement) {
+		return Placement{Consumer: id, Singleton: true}, true, nil
+	}
+	if len(parser.Sources(query)) < 2 {
+		return Placement{Consumer: id}, true, nil
+	}
+	partitions := []int{}
+	for partition := 0; partition < max(query.Parallelism, 1); partition++ {
+		if e.cluster.Owner(fmt.Sprintf("%s/%d", id, partition)) == e.cluster.Self() {
+			partitions = append(partitions, partition)
+		}
+	}
+	return Placement{Partitions: partitions}, len(partitions) > 0, nil
+}
+
+// restoreShared Run this instance's share of each registered query, rebalancing when queries are registered or
+// instances join or leave, and stop queries when they're removed from the registry.
+func (e *Engine) restoreShared(ctx context.Context) error {
+	err := e.registry.Watch(ctx, func(QueryRecord) {
+		e.rebalance(ctx)
+	}, func(id string) {
+		if query, ok := e.Get(id); ok {
+			e.forget(id)
+			query.terminate(DrainTimeout)
+		}
+		e.withdraw(id)
+		if e.checkpoints != nil {
+			if err := e.checkpoints.Delete(ctx, id); err != nil {
+				slog.Warn("Failed to delete checkpoints", "id", id, "error", err)
+			}
+		}
+		if e.progress != nil {
+			if err := e.progress.Delete(ctx, id); err != nil {
+				slog.Warn("Failed to delete progress", "id", id, "error", err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return e.cluster.Join(ctx, func(members []string) {
+		e.rebalance(ctx)
+	})
+}
+
+// rebalance Start this instance's share of each registered query it doesn't run yet, and restart those whose
+// share has changed. Join state of a restarted query is rebuilt from the messages it reads again, from a window
+// before the watermark of each of its partitions.
+func (e *Engine) rebalance(ctx context.Context) {
+	e.rebalancing.Lock()
+	defer e.rebalancing.Unlock()
+	records, err := e.registry.List(ctx)
+	if err != nil {
+		slog.Error("Failed to list queries", "error", err)
+		return
+	}
+	for _, record := range records {
+		placement, runs, err := e.placement(record.ID, record.SQL)
+		if err != nil {
+			slog.Error("Failed to place query", "id", record.ID, "error", err)
+			continue
+		}
+		if placement.Singleton {
+			e.compete(ctx, record)
+			continue
+		}
+		query, running := e.Get(record.ID)
+		if running && runs && query.placement.equal(placement) {
+			continue
+		}
+		if running {
+			e.forget(record.ID)
+			query.terminate(DrainTimeout)
+			slog.Info("Stopped query to rebalance", "id", record.ID)
+		}
+		if !runs {
+			continue
+		}
+		if _, err := e.startPersistent(record.ID, record.SQL, record.Created, placement); err != nil {
+			slog.Error("Failed to start query", "id", record.ID, "error", err)
+			continue
+		}
+		slog.Info("Started share of query", "id", record.ID, "partitions", placement.Partitions)
+	}
+}
+
+// checkShared Check that a query the cluster will run can be built, without running it here, where it's started
+// by rebalancing once it's registered.
+func (e *Engine) checkShared(id string, sql string, created time.Time) (*Query, error) {
+	builder, query, err := e.persistentBuilder(sql, Placement{})
+	if err != nil {
+		return nil, err
+	}
+	if err := e.prepare(context.Background(), query); err != nil {
+		return nil, err
+	}
+	if err := parser.Apply(query, builder); err != nil {
+		return nil, err
+	}
+	if errs := builder.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	// The query only describes what's registered, so there's nothing here to wait for or stop
+	done := make(chan struct{})
+	close(done)
+	return &Query{
+		ID:         id,
+		SQL:        sql,
+		Persistent: true,
+		Created:    created,
+		state:      QueryStateRunning,
+		cancel:     func() {},
+		done:       done,
+	}, nil
+}
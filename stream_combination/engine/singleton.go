@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"stream_combination/parser"
+	"strings"
+	"time"
+)
+
+// SetLeases Run singleton queries, created `WITH (SINGLETON='true')`, only on the instance of the cluster holding
+// their lease, which checkpoints them to `checkpoints`, or doesn't checkpoint them if it's nil.
+func (e *Engine) SetLeases(leases *Leases, checkpoints *Checkpoints) {
+	e.leases = leases
+	e.checkpoints = checkpoints
+}
+
+// singleton Whether a statement is a query that must run on exactly one instance, e.g. a global aggregate
+func singleton(statement parser.Statement) bool {
+	createStream, ok := statement.(*parser.CreateStreamAs)
+	return ok && strings.EqualFold(createStream.Properties["SINGLETON"], "true")
+}
+
+// compete Compete for the lease of a singleton query, unless this instance already is, running the query while it
+// holds the lease.
+func (e *Engine) compete(ctx context.Context, record QueryRecord) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, competing := e.leading[record.ID]; competing {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	e.leading[record.ID] = cancel
+	e.leaders.Add(1)
+	go func() {
+		defer e.leaders.Done()
+		e.leases.Lead(ctx, record.ID, func(ctx context.Context) { e.lead(ctx, record) })
+	}()
+}
+
+// withdraw Stop competing for the lease of a singleton query, handing it over if this instance holds it
+func (e *Engine) withdraw(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cancel, competing := e.leading[id]; competing {
+		cancel()
+		delete(e.leading, id)
+	}
+}
+
+// lead Run a singleton query from its last checkpoint while this instance holds its lease, checkpointing it every
+// CheckpointInterval. The query reads its sources through durable consumers named after it, and the messages of
+// events waiting in its join windows are only acked once a saved checkpoint covers them, so the next leader
+// restores the last checkpoint and is redelivered every message since. When the lease is handed over, the query
+// is drained and checkpointed first, so little is redelivered; when the lease is lost, another instance may
+// already lead, so the query is stopped without a checkpoint.
+func (e *Engine) lead(ctx context.Context, record QueryRecord) {
+	query, err := e.startPersistent(record.ID, record.SQL, record.Created, Placement{Consumer: record.ID, Singleton: true})
+	if err != nil {
+		slog.Error("Failed to start singleton query", "id", record.ID, "error", err)
+		e.setState(record.ID, QueryStateFailed, err.Error())
+		return
+	}
+	// An earlier leader may have failed to start it
+	e.setState(record.ID, QueryStateRunning, "")
+	slog.Info("Leading singleton query", "id", record.ID, "instance", e.leases.Holder())
+
+	ticker := time.NewTicker(CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.checkpoint(query)
+		case <-query.Done():
+			// Failed, or terminated, when its checkpoints are removed
+			return
+		case <-ctx.Done():
+			select {
+			case <-query.Done():
+				return
+			default:
+			}
+			e.forget(query.ID)
+			if errors.Is(context.Cause(ctx), ErrLeaseLost) {
+				query.stop(QueryStateTerminated, nil)
+				return
+			}
+			query.terminate(DrainTimeout)
+			e.checkpoint(query)
+			slog.Info("Handed over singleton query", "id", record.ID, "instance", e.leases.Holder())
+			return
+		}
+	}
+}
+
+// setState Record the state of a singleton query in the registry, for SHOW QUERIES on every instance
+func (e *Engine) setState(id string, state QueryState, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.registry.SetState(ctx, id, state, reason); err != nil {
+		slog.Error("Failed to update query", "id", id, "error", err)
+	}
+}
+
+// checkpoint Save the state of a singleton query, logging rather than failing the query if it can't be saved
+func (e *Engine) checkpoint(query *Query) {
+	if e.checkpoints == nil || len(query.checkpointers) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.checkpoints.Save(ctx, query.ID, query.checkpointers); err != nil {
+		slog.Warn("Failed to checkpoint query", "id", query.ID, "error", err)
+	}
+}
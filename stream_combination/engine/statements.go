@@ -0,0 +1,325 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"stream_combination/catalog"
+	"stream_combination/parser"
+	"stream_combination/processor"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Result The outcome of a statement that doesn't stream rows, as a table.
+// Catalog statements also set Data, for clients that want the structured form.
+type Result struct {
+	Message string      `json:"message,omitempty"`
+	Columns []string    `json:"columns,omitempty"`
+	Rows    [][]string  `json:"rows,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Execute Run a statement other than a push query, e.g. `CREATE STREAM ... AS`, `SHOW QUERIES` or `TERMINATE`.
+func (e *Engine) Execute(ctx context.Context, sql string) (*Result, error) {
+	statement, err := parser.ParseStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+	switch s := statement.(type) {
+	case *parser.CreateStreamAs:
+		query, err := e.StartPersistent(sql)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Message: fmt.Sprintf("Created query %s writing to stream %s", query.ID, s.Name)}, nil
+	case *parser.DeclareStream:
+		return e.declare(ctx, s)
+	case *parser.ShowQueries:
+		return e.showQueries(ctx)
+	case *parser.ShowStreams:
+		return e.showStreams(ctx)
+	case *parser.ShowTables:
+		return e.showTables(ctx)
+	case *parser.Describe:
+		return e.describe(ctx, s)
+	case *parser.InferSchema:
+		return e.inferSchema(ctx, s)
+	case *parser.TerminateQuery:
+		if err := e.Terminate(s.ID); err != nil {
+			return nil, err
+		}
+		return &Result{Message: fmt.Sprintf("Terminated query %s", s.ID)}, nil
+	case *parser.Explain:
+		if err := e.prepare(ctx, s); err != nil {
+			return nil, err
+		}
+		plan, err := Explain(e.js, s)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Message: strings.TrimSuffix(plan, "\n")}, nil
+	case *parser.SelectNode:
+		return nil, fmt.Errorf("queries stream rows, run them as push queries")
+	default:
+		return nil, fmt.Errorf("unsupported statement %T", statement)
+	}
+}
+
+// Explain The logical plan and processor DAG of a query, without starting it. Nothing is created in JetStream.
+// With GRAPHVIZ the DAG is written in DOT instead.
+func Explain(js jetstream.JetStream, explain *parser.Explain) (string, error) {
+	plan, err := parser.LogicalPlan(explain.Statement)
+	if err != nil {
+		return "", err
+	}
+	builder := processor.NewProcessorBuilder(js)
+	var node parser.Node
+	switch s := explain.Statement.(type) {
+	case *parser.CreateStreamAs:
+		sink, err := outputSink(js, s)
+		if err != nil {
+			return "", err
+		}
+		builder.SetSinkFactory(func() processor.MessageProcessor { return sink })
+		node = s.Query
+	case *parser.SelectNode:
+		node = s
+	}
+	if err := parser.Apply(node, builder); err != nil {
+		return "", err
+	}
+	if explain.Graphviz {
+		return builder.DOT(), nil
+	}
+	return fmt.Sprintf("Logical plan:\n%s\nProcessors:\n%s", plan, builder), nil
+}
+
+// declare Store the schema of a stream in the catalog, replacing any earlier declaration.
+func (e *Engine) declare(ctx context.Context, declare *parser.DeclareStream) (*Result, error) {
+	if e.schemas == nil {
+		return nil, fmt.Errorf("no schema catalog to declare stream %s in", declare.Name)
+	}
+	if err := e.schemas.Put(ctx, declare.Schema()); err != nil {
+		return nil, err
+	}
+	return &Result{Message: fmt.Sprintf("Declared stream %s with %d columns", declare.Name, len(declare.Columns))}, nil
+}
+
+// showQueries Queries running here and in the registry. Registered queries not running here show their stored state.
+func (e *Engine) showQueries(ctx context.Context) (*Result, error) {
+	queries := make(map[string]QueryInfo)
+	if e.registry != nil {
+		records, err := e.registry.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			queries[record.ID] = QueryInfo{
+				ID:         record.ID,
+				SQL:        record.SQL,
+				State:      record.State,
+				Persistent: true,
+				Created:    record.Created,
+				Error:      record.Error,
+			}
+		}
+	}
+	for _, info := range e.List() {
+		queries[info.ID] = info
+	}
+
+	infos := make([]QueryInfo, 0, len(queries))
+	for _, info := range queries {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.Before(infos[j].Created) })
+
+	result := &Result{Columns: []string{"ID", "STATE", "CREATED", "SQL", "ERROR", "FULLEST BUFFER"}, Data: infos}
+	for _, info := range infos {
+		result.Rows = append(result.Rows, []string{
+			info.ID, string(info.State), info.Created.Format(time.RFC3339), info.SQL, info.Error, fullestBuffer(info.Buffers),
+		})
+	}
+	return result, nil
+}
+
+// fullestBuffer The processor whose buffer is fullest, e.g. `WhereFilter[1a2b3c4d] 50/50`, as the first place
+// to look when a query falls behind
+func fullestBuffer(buffers []processor.BufferUsage) string {
+	var fullest *processor.BufferUsage
+	for i, buffer := range buffers {
+		if buffer.Capacity == 0 {
+			continue
+		}
+		if fullest == nil || buffer.Length*fullest.Capacity > fullest.Length*buffer.Capacity {
+			fullest = &buffers[i]
+		}
+	}
+	if fullest == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s %d/%d", fullest.Processor, fullest.Length, fullest.Capacity)
+}
+
+func (e *Engine) showStreams(ctx context.Context) (*Result, error) {
+	streams, err := catalog.ListStreams(ctx, e.js)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Columns: []string{"NAME", "SUBJECTS", "RETENTION", "MESSAGES", "BYTES", "CONSUMERS"}, Data: streams}
+	for _, stream := range streams {
+		result.Rows = append(result.Rows, []string{
+			stream.Name,
+			strings.Join(stream.Subjects, ", "),
+			stream.Retention,
+			strconv.FormatUint(stream.Messages, 10),
+			strconv.FormatUint(stream.Bytes, 10),
+			strconv.Itoa(stream.Consumers),
+		})
+	}
+	return result, nil
+}
+
+func (e *Engine) showTables(ctx context.Context) (*Result, error) {
+	tables, err := catalog.ListTables(ctx, e.js)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Columns: []string{"NAME", "KEYS", "HISTORY", "TTL", "BYTES"}, Data: tables}
+	for _, table := range tables {
+		result.Rows = append(result.Rows, []string{
+			table.Name,
+			strconv.FormatUint(table.Keys, 10),
+			strconv.FormatInt(table.History, 10),
+			table.TTL.String(),
+			strconv.FormatUint(table.Bytes, 10),
+		})
+	}
+	return result, nil
+}
+
+// describe The stream's configuration as the message, and its inferred schema as the table.
+func (e *Engine) describe(ctx context.Context, describe *parser.Describe) (*Result, error) {
+	description, err := catalog.Describe(ctx, e.js, describe.Stream, describe.Extended)
+	if err != nil {
+		return nil, err
+	}
+	if describe.Extended {
+		description.Queries, err = e.queriesReading(ctx, describe.Stream)
+		if err != nil {
+			return nil, err
+		}
+	}
+	schemaSource := "inferred from recent messages"
+	if e.schemas != nil {
+		schema, declared, err := e.schemas.Get(ctx, describe.Stream)
+		if err != nil {
+			return nil, err
+		}
+		if declared {
+			schemaSource = "declared"
+			description.Schema = make([]catalog.Field, len(schema.Columns))
+			for i, column := range schema.Columns {
+				description.Schema[i] = catalog.Field{Name: column.Name, Type: column.Type.String()}
+			}
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Name:      %s\n", description.Name)
+	fmt.Fprintf(&sb, "Subjects:  %s\n", strings.Join(description.Subjects, ", "))
+	fmt.Fprintf(&sb, "Retention: %s, max age %s\n", description.Retention, description.MaxAge)
+	fmt.Fprintf(&sb, "Storage:   %s, %d replicas\n", description.Storage, description.Replicas)
+	fmt.Fprintf(&sb, "Messages:  %d (seq %d-%d, %d bytes)\n", description.Messages, description.FirstSeq, description.LastSeq, description.Bytes)
+	fmt.Fprintf(&sb, "Schema:    %s", schemaSource)
+	if describe.Extended {
+		sb.WriteString("\nConsumers:")
+		for _, consumer := range description.Consumers {
+			fmt.Fprintf(&sb, "\n  %s (durable=%t, pending=%d, ack pending=%d)", consumer.Name, consumer.Durable, consumer.Pending, consumer.AckPending)
+		}
+		sb.WriteString("\nQueries:")
+		for _, id := range description.Queries {
+			fmt.Fprintf(&sb, "\n  %s", id)
+		}
+	}
+
+	result := &Result{Message: sb.String(), Columns: []string{"FIELD", "TYPE"}, Data: description}
+	for _, field := range description.Schema {
+		result.Rows = append(result.Rows, []string{field.Name, field.Type})
+	}
+	return result, nil
+}
+
+// inferSchema Infer a stream's schema from its newest messages, storing it in the catalog with SAVE.
+func (e *Engine) inferSchema(ctx context.Context, infer *parser.InferSchema) (*Result, error) {
+	limit := infer.Limit
+	if limit == 0 {
+		limit = catalog.DefaultSampleSize
+	}
+	inferred, err := catalog.InferSchema(ctx, e.js, infer.Stream, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Sampled %d messages of %s", inferred.Sampled, infer.Stream)
+	if inferred.Skipped > 0 {
+		message += fmt.Sprintf(", skipping %d that weren't JSON objects", inferred.Skipped)
+	}
+	if infer.Save {
+		if e.schemas == nil {
+			return nil, fmt.Errorf("no schema catalog to save the schema of %s in", infer.Stream)
+		}
+		if inferred.Sampled == 0 {
+			return nil, fmt.Errorf("no messages in %s to infer a schema from", infer.Stream)
+		}
+		if err := e.schemas.Put(ctx, inferred.Schema()); err != nil {
+			return nil, err
+		}
+		message += "\nSaved the schema of " + infer.Stream
+	}
+
+	result := &Result{Message: message, Columns: []string{"FIELD", "TYPE", "NULLABLE", "PRESENT", "DISTINCT"}, Data: inferred}
+	for _, field := range inferred.Fields {
+		fieldType := field.Type.String()
+		if field.Mixed {
+			fieldType += " (mixed)"
+		}
+		distinct := strconv.Itoa(field.Distinct)
+		if field.Distinct >= catalog.MaxDistinct {
+			distinct += "+"
+		}
+		result.Rows = append(result.Rows, []string{
+			field.Name,
+			fieldType,
+			strconv.FormatBool(field.Nullable),
+			fmt.Sprintf("%d/%d", field.Present, inferred.Sampled),
+			distinct,
+		})
+	}
+	return result, nil
+}
+
+// queriesReading IDs of running and registered queries that read from `stream`
+func (e *Engine) queriesReading(ctx context.Context, stream string) ([]string, error) {
+	queries, err := e.showQueries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, row := range queries.Rows {
+		id, sql := row[0], row[3]
+		statement, err := parser.ParseStatement(sql)
+		if err != nil || e.resolveSubjects(ctx, statement) != nil {
+			continue
+		}
+		if slices.Contains(parser.Sources(statement), stream) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -2,106 +2,155 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"stream_combination/parser"
-	"stream_combination/processor"
+	"os"
+	"os/signal"
+	"stream_combination/catalog"
+	"stream_combination/engine"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/alecthomas/repr"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
-func publishExamples(js jetstream.JetStream, stream string) {
-	ctx := context.Background()
-	for i := 0; i < 20; i++ {
-		CorrelationID := fmt.Sprintf("%v", i)
-		dataA, _ := json.Marshal(map[string]interface{}{
-			"CorrelationID": CorrelationID,
-			"StringPayload": fmt.Sprintf("This is message %v", i),
-			"BytePayload":   []byte(fmt.Sprintf("This is message %v", i)),
-		})
-		_, err := js.Publish(ctx, stream+".messages", dataA)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-	}
+const usage = `nsql - query NATS JetStream with SQL
+
+Usage:
+  nsql run      -f query.sql|pipeline.yaml [connection flags]
+  nsql validate [--schema schemas.sql] -f query.sql
+  nsql explain  [--dot] -f query.sql
+  nsql fmt      [-w] -f query.sql
+  nsql repl     [connection flags]
+  nsql server   [--listen :8080] [--instance id] [connection flags]
+
+Queries are read from stdin when -f is omitted or is "-".
+
+Connection flags (run, repl, server):
+  --server  NATS server URL (env NATS_URL, default nats://127.0.0.1:4222)
+  --creds   NATS credentials file (env NATS_CREDS)
+
+Persistent queries (repl, server) are stored in the KV bucket given by --registry
+(default nsql_queries) and restarted by "nsql server". Servers started with --instance (env
+NSQL_INSTANCE) share them as a cluster, tracked in the KV bucket given by --cluster (default
+nsql_instances). Queries created WITH (SINGLETON='true') run on one instance at a time, elected
+through leases in the KV bucket given by --leases (default nsql_leases), and are checkpointed to
+the object store given by --checkpoints (default nsql_checkpoints). The owners of join partitions
+record how far they've read in the KV bucket given by --progress (default nsql_progress).
+`
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"run":      runCommand,
+	"validate": validateCommand,
+	"explain":  explainCommand,
+	"fmt":      fmtCommand,
+	"repl":     replCommand,
+	"server":   serverCommand,
 }
 
 func main() {
-	query := "SELECT StringPayload FROM streamA WHERE CorrelationID = 1"
-	result, err := parser.ParseSQL(query)
-	repr.Println(result)
-
-	nc, err := nats.Connect(nats.DefaultURL)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Print(usage)
+		return
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "nsql %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
 
+// readQuery Read the query text from a file, or from stdin for "" and "-".
+func readQuery(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "" || path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("error reading query: %w", err)
 	}
+	return strings.TrimSpace(string(data)), nil
+}
 
-	// Handle draining and close at connection end
-	defer func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		done := make(chan error, 1)
-		go func() { done <- nc.Drain() }() // Drain in Go Routine
-
-		select {
-		case err := <-done:
-			if err != nil {
-				log.Printf("Drain failed, forcing close: %v", err)
-				nc.Close()
-			}
-		case <-ctx.Done():
-			log.Printf("Drain timed out, forcing close")
-			nc.Close()
-		}
-		nc.Close()
-	}()
-
-	// Connect to JetStream
-	js, _ := jetstream.New(nc)
-
-	cfgA := jetstream.StreamConfig{
-		Name:     "streamA",
-		Subjects: []string{"streamA", "streamA.>"},
+func envOrDefault(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
 	}
+	return fallback
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	js.CreateStream(ctx, cfgA)
-	publishExamples(js, "streamA")
+type connectionFlags struct {
+	server string
+	creds  string
+}
 
-	builder := processor.NewProcessorBuilder(js)
-	result.Visit(builder)
+func (cf *connectionFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&cf.server, "server", envOrDefault("NATS_URL", nats.DefaultURL), "NATS server URL")
+	fs.StringVar(&cf.creds, "creds", os.Getenv("NATS_CREDS"), "NATS credentials file")
+}
 
-	// Build and run
-	errorCh := make(chan error, 10)
-	pipeline, err := builder.Build(ctx, errorCh)
+func (cf *connectionFlags) connect() (*nats.Conn, error) {
+	opts := []nats.Option{nats.Name("nsql")}
+	if cf.creds != "" {
+		opts = append(opts, nats.UserCredentials(cf.creds))
+	}
+	nc, err := nats.Connect(cf.server, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", cf.server, err)
+	}
+	return nc, nil
+}
 
+// openEngine An engine whose persistent queries are stored in `bucket`, type checking against the schema catalog.
+func openEngine(ctx context.Context, js jetstream.JetStream, bucket string) (*engine.Engine, error) {
+	registry, err := engine.NewRegistry(ctx, js, bucket)
+	if err != nil {
+		return nil, err
+	}
+	schemas, err := catalog.NewSchemas(ctx, js, catalog.DefaultSchemaBucket)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	return engine.New(js, registry, schemas), nil
+}
 
-	// Run pipeline in background and listen for errors
-	go func() {
-		if err := pipeline.Run(ctx); err != nil {
-			log.Printf("Pipeline error: %v", err)
-		}
-	}()
+// drain Drain the connection, forcing a close if it takes longer than `timeout`.
+func drain(nc *nats.Conn, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- nc.Drain() }() // Drain in Go Routine
 
-	// Listen for errors or wait for completion
 	select {
-	case err := <-errorCh:
-		log.Printf("Error: %v", err)
+	case err := <-done:
+		if err != nil {
+			log.Printf("Drain failed, forcing close: %v", err)
+		}
 	case <-ctx.Done():
-		log.Println("Deadline exceeded - shutting down")
-	case <-time.After(30 * time.Second):
-		log.Println("Test complete")
+		log.Printf("Drain timed out, forcing close")
 	}
+	nc.Close()
+}
+
+// signalContext A context cancelled on SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 }
@@ -0,0 +1,64 @@
+package models
+
+import "sync/atomic"
+
+// Delivery A message read from NATS that's acked once everything done with it is complete, e.g. once the rows
+// computed from it are published. Each copy of an event in the pipeline holds a reference to the deliveries it
+// came from, which it gives up with Done once it's published, filtered out or otherwise finished with.
+type Delivery struct {
+	ack   func()
+	refs  atomic.Int64
+	acked atomic.Bool
+}
+
+// NewDelivery A delivery with one reference, for the event read from it, which calls `ack` once every reference
+// is done.
+func NewDelivery(ack func()) *Delivery {
+	d := &Delivery{ack: ack}
+	d.refs.Store(1)
+	return d
+}
+
+// Retain Add `n` references, e.g. for copies of an event
+func (d *Delivery) Retain(n int) {
+	d.refs.Add(int64(n))
+}
+
+// Done Give up a reference, acking the message once none are left. A message is acked once at most, even if it's
+// retained again afterwards, e.g. by a join matching an event it had already checkpointed.
+func (d *Delivery) Done() {
+	if d.refs.Add(-1) == 0 && d.acked.CompareAndSwap(false, true) {
+		d.ack()
+	}
+}
+
+// Acked Whether the message has been acked
+func (d *Delivery) Acked() bool {
+	return d.acked.Load()
+}
+
+// DeliveriesOf The deliveries an event came from, e.g. both sides' for a join event, or none for events that
+// weren't read from NATS
+func DeliveriesOf(event EventLike) []*Delivery {
+	if e, ok := event.(interface{ Deliveries() []*Delivery }); ok {
+		return e.Deliveries()
+	}
+	return nil
+}
+
+// Retain Add `n` references to each delivery an event came from, for `n` more copies of it
+func Retain(event EventLike, n int) {
+	if n <= 0 {
+		return
+	}
+	for _, d := range DeliveriesOf(event) {
+		d.Retain(n)
+	}
+}
+
+// Done Give up the references of one copy of an event, once it's finished with
+func Done(event EventLike) {
+	for _, d := range DeliveriesOf(event) {
+		d.Done()
+	}
+}
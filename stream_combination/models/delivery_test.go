@@ -0,0 +1,58 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelivery(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps func(d *Delivery)
+		acks  int
+	}{
+		{"done", func(d *Delivery) { d.Done() }, 1},
+		{"not done", func(d *Delivery) {}, 0},
+		{"copies not all done", func(d *Delivery) { d.Retain(2); d.Done(); d.Done() }, 0},
+		{"copies all done", func(d *Delivery) { d.Retain(2); d.Done(); d.Done(); d.Done() }, 1},
+		{"retained after the ack", func(d *Delivery) { d.Done(); d.Retain(1); d.Done() }, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			acks := 0
+			d := NewDelivery(func() { acks++ })
+			test.steps(d)
+			if acks != test.acks {
+				t.Errorf("acked %d times, expected %d", acks, test.acks)
+			}
+			if d.Acked() != (test.acks > 0) {
+				t.Errorf("Acked() = %t after %d acks", d.Acked(), acks)
+			}
+		})
+	}
+}
+
+func TestDeliveriesOf(t *testing.T) {
+	now := time.Now()
+	read := func() (*Event, *Delivery) {
+		event := NewEvent(now, map[string]interface{}{"id": 1})
+		d := NewDelivery(func() {})
+		event.SetDelivery(d)
+		return event, d
+	}
+	left, l := read()
+	right, r := read()
+	joined := NewJoinEvent(now, NewDerivedEvent(left, map[string]interface{}{"id": 1}), right)
+
+	deliveries := DeliveriesOf(joined)
+	if len(deliveries) != 2 || deliveries[0] != l || deliveries[1] != r {
+		t.Fatalf("deliveries of the join %v, expected those of the left then the right side", deliveries)
+	}
+	Done(joined)
+	if !l.Acked() || !r.Acked() {
+		t.Error("finishing with the join should ack both sides")
+	}
+	if got := DeliveriesOf(NewEvent(now, nil)); got != nil {
+		t.Errorf("an event that wasn't read has deliveries %v", got)
+	}
+}
@@ -16,6 +16,10 @@ type EventLike interface {
 type Event struct {
 	Timestamp time.Time
 	data      map[string]interface{}
+	metadata  *Metadata // nil for events that weren't read from NATS
+	origin    string    // set for events derived from others, see Origin
+	// deliveries The messages the event came from, acked once it's finished with
+	deliveries []*Delivery
 }
 
 func (e Event) GetTimestamp() time.Time {
@@ -23,14 +27,18 @@ func (e Event) GetTimestamp() time.Time {
 }
 
 func (e Event) GetString(fieldName string) string {
-	value, exists := e.data[fieldName]
-	if !exists {
+	value := e.GetField(fieldName)
+	if value == nil {
 		return ""
 	}
 	return fmt.Sprintf("%v", value)
 }
 
+// GetField The value of a payload field, or of a pseudo-column such as `_subject`, which shadow payload fields
 func (e Event) GetField(fieldName string) interface{} {
+	if e.metadata != nil && IsPseudoColumn(fieldName) {
+		return e.metadata.Field(fieldName)
+	}
 	value, exists := e.data[fieldName]
 	if !exists {
 		return nil
@@ -50,6 +58,65 @@ func NewEvent(timestamp time.Time, data map[string]interface{}) *Event {
 	return &Event{Timestamp: timestamp, data: data}
 }
 
+// NewEventWithMetadata An event decoded from a NATS message, with its metadata available as pseudo-columns
+func NewEventWithMetadata(timestamp time.Time, data map[string]interface{}, metadata *Metadata) *Event {
+	return &Event{Timestamp: timestamp, data: data, metadata: metadata}
+}
+
+// Metadata The NATS message the event was decoded from, or nil
+func (e Event) Metadata() *Metadata {
+	return e.metadata
+}
+
+// NewDerivedEvent An event computed from `from`, e.g. a projection of it, keeping its metadata and origin
+func NewDerivedEvent(from EventLike, data map[string]interface{}) *Event {
+	return &Event{
+		Timestamp:  from.GetTimestamp(),
+		data:       data,
+		metadata:   MetadataOf(from),
+		origin:     OriginOf(from),
+		deliveries: DeliveriesOf(from),
+	}
+}
+
+// SetDelivery Ack `delivery` once the event is finished with
+func (e *Event) SetDelivery(delivery *Delivery) {
+	e.deliveries = []*Delivery{delivery}
+}
+
+// Deliveries The messages the event came from, if it was read from NATS
+func (e Event) Deliveries() []*Delivery {
+	return e.deliveries
+}
+
+// Origin The source messages the event came from, as `stream:sequence`, or "" if it wasn't read from NATS
+func (e Event) Origin() string {
+	if e.origin != "" || e.metadata == nil {
+		return e.origin
+	}
+	return fmt.Sprintf("%s:%d", e.metadata.Stream, e.metadata.Sequence)
+}
+
+// OriginOf The source messages an event came from, which is the same each time they're processed, or ""
+func OriginOf(event EventLike) string {
+	if e, ok := event.(interface{ Origin() string }); ok {
+		return e.Origin()
+	}
+	return ""
+}
+
+// MetadataOf The metadata of an event that was read from NATS, or nil for any other event
+func MetadataOf(event EventLike) *Metadata {
+	if e, ok := event.(interface{ Metadata() *Metadata }); ok {
+		return e.Metadata()
+	}
+	return nil
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.data)
+}
+
 func (e Event) String() string {
 	return fmt.Sprintf("Event{%v}", e.data)
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -10,6 +11,10 @@ type JoinEvent struct {
 	Timestamp  time.Time
 	LeftEvent  EventLike
 	RightEvent EventLike
+	// LeftAlias, RightAlias The names the sides' fields are qualified by in queries, e.g. `u` for `u.user_id`,
+	// besides `left` and `right`. Empty for sides that aren't a single source, e.g. joins.
+	LeftAlias  string
+	RightAlias string
 }
 
 func NewJoinEvent(timestamp time.Time, leftEvent EventLike, rightEvent EventLike) JoinEvent {
@@ -23,26 +28,57 @@ func NewJoinEvent(timestamp time.Time, leftEvent EventLike, rightEvent EventLike
 func (je JoinEvent) GetTimestamp() time.Time { return je.Timestamp }
 
 func (je JoinEvent) GetString(fieldName string) string {
-	// Handle dotted notation: "left.user_id", "right.amount"
-	if strings.HasPrefix(fieldName, "left.") {
-		return je.LeftEvent.GetString(strings.TrimPrefix(fieldName, "left."))
+	value := je.GetField(fieldName)
+	if value == nil {
+		return ""
 	}
-	if strings.HasPrefix(fieldName, "right.") {
-		return je.RightEvent.GetString(strings.TrimPrefix(fieldName, "right."))
-	}
-	return ""
+	return fmt.Sprintf("%v", value)
 }
 
+// GetField The value of a field qualified by its side, e.g. `left.user_id`, or by the alias of its side, e.g.
+// `u.user_id`. Fields of sides that are joins themselves are looked up in them, for joins of joins.
 func (je JoinEvent) GetField(fieldName string) interface{} {
-	if strings.HasPrefix(fieldName, "left.") {
-		return je.LeftEvent.GetField(strings.TrimPrefix(fieldName, "left."))
+	if qualifier, field, ok := strings.Cut(fieldName, "."); ok {
+		if qualifier == "left" || (je.LeftAlias != "" && qualifier == je.LeftAlias) {
+			return je.LeftEvent.GetField(field)
+		}
+		if qualifier == "right" || (je.RightAlias != "" && qualifier == je.RightAlias) {
+			return je.RightEvent.GetField(field)
+		}
 	}
-	if strings.HasPrefix(fieldName, "right.") {
-		return je.RightEvent.GetField(strings.TrimPrefix(fieldName, "right."))
+	for _, side := range []EventLike{je.LeftEvent, je.RightEvent} {
+		if joined, ok := side.(JoinEvent); ok {
+			if value := joined.GetField(fieldName); value != nil {
+				return value
+			}
+		}
 	}
 	return nil
 }
 
+// Origin The origins of both sides, or "" unless both are known
+func (je JoinEvent) Origin() string {
+	left, right := OriginOf(je.LeftEvent), OriginOf(je.RightEvent)
+	if left == "" || right == "" {
+		return ""
+	}
+	return left + "+" + right
+}
+
+// Deliveries The messages both sides came from
+func (je JoinEvent) Deliveries() []*Delivery {
+	left := DeliveriesOf(je.LeftEvent)
+	// Full slice, so appending copies rather than writing into the left side's deliveries
+	return append(left[:len(left):len(left)], DeliveriesOf(je.RightEvent)...)
+}
+
+func (je JoinEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"left":  je.LeftEvent,
+		"right": je.RightEvent,
+	})
+}
+
 func (je JoinEvent) String() string {
 	return fmt.Sprintf("JoinEvent{%v, left=%s, right=%s}",
 		je.Timestamp.Format(time.RFC3339),
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJoinEventGetField(t *testing.T) {
+	now := time.Now()
+	users := NewJoinEvent(now,
+		NewEvent(now, map[string]interface{}{"user_id": "u1", "name": "Ada"}),
+		NewEvent(now, map[string]interface{}{"user_id": "u1", "amount": 12.5}),
+	)
+	users.LeftAlias, users.RightAlias = "u", "p"
+	// A join of the join above with a third source, whose left side is a join and so has no alias
+	nested := NewJoinEvent(now, users, NewEvent(now, map[string]interface{}{"sku": "s1"}))
+	nested.RightAlias = "i"
+
+	tests := []struct {
+		name     string
+		event    JoinEvent
+		field    string
+		expected interface{}
+	}{
+		{"left side", users, "left.name", "Ada"},
+		{"right side", users, "right.amount", 12.5},
+		{"left alias", users, "u.user_id", "u1"},
+		{"right alias", users, "p.amount", 12.5},
+		{"missing field", users, "u.amount", nil},
+		{"unknown alias", users, "x.user_id", nil},
+		{"unqualified", users, "user_id", nil},
+		{"alias within the left side", nested, "u.name", "Ada"},
+		{"right alias of a nested join", nested, "i.sku", "s1"},
+		{"side of a nested join", nested, "left.p.amount", 12.5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.event.GetField(test.field); got != test.expected {
+				t.Errorf("GetField(%q) = %v, expected %v", test.field, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestJoinEventSnapshotKeepsAliases(t *testing.T) {
+	now := time.Now()
+	joined := NewJoinEvent(now, NewEvent(now, map[string]interface{}{"name": "Ada"}), NewEvent(now, map[string]interface{}{"amount": 1}))
+	joined.LeftAlias, joined.RightAlias = "u", "p"
+	snapshot, err := SnapshotOf(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := snapshot.Event()
+	if got := restored.GetField("u.name"); got != "Ada" {
+		t.Errorf("u.name = %v after restoring, expected Ada", got)
+	}
+}
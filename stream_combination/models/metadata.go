@@ -0,0 +1,118 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pseudo-columns Where a message came from in NATS, readable by queries alongside the fields of its payload
+const (
+	SubjectColumn      = "_subject"
+	StreamColumn       = "_stream"
+	SequenceColumn     = "_seq"
+	ConsumerSeqColumn  = "_consumer_seq"
+	NumDeliveredColumn = "_num_delivered"
+	TimestampColumn    = "_timestamp"
+	HeadersColumn      = "_headers"
+	SubjectTokenColumn = "_subject_token"
+)
+
+// PseudoColumns The pseudo-columns that are read by name, rather than by subscript or call
+var PseudoColumns = []string{
+	SubjectColumn, StreamColumn, SequenceColumn, ConsumerSeqColumn, NumDeliveredColumn, TimestampColumn, HeadersColumn,
+}
+
+// Metadata The NATS message an event was decoded from
+type Metadata struct {
+	Subject          string
+	Stream           string
+	Sequence         uint64
+	ConsumerSequence uint64
+	NumDelivered     uint64
+	Timestamp        time.Time
+	Headers          map[string][]string
+}
+
+// HeaderField The pseudo-column of one header, e.g. `_headers['X-Tenant']`
+func HeaderField(key string) string {
+	return fmt.Sprintf("%s['%s']", HeadersColumn, key)
+}
+
+// SubjectTokenField The pseudo-column of the n-th token of the subject, counting from 1, e.g. `_subject_token(2)`
+func SubjectTokenField(n int) string {
+	return fmt.Sprintf("%s(%d)", SubjectTokenColumn, n)
+}
+
+// IsPseudoColumn Whether a field name is read from the message's metadata rather than its payload
+func IsPseudoColumn(name string) bool {
+	for _, column := range PseudoColumns {
+		if name == column {
+			return true
+		}
+	}
+	_, isHeader := headerKey(name)
+	_, isToken := subjectToken(name)
+	return isHeader || isToken
+}
+
+func headerKey(name string) (string, bool) {
+	prefix := HeadersColumn + "['"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "']") || len(name) < len(prefix)+2 {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-2], true
+}
+
+func subjectToken(name string) (int, bool) {
+	prefix := SubjectTokenColumn + "("
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ")") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[len(prefix) : len(name)-1])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Field The value of a pseudo-column, or nil if there's no such column or, for headers and subject tokens, no value
+func (m *Metadata) Field(name string) interface{} {
+	switch name {
+	case SubjectColumn:
+		return m.Subject
+	case StreamColumn:
+		return m.Stream
+	case SequenceColumn:
+		return int64(m.Sequence)
+	case ConsumerSeqColumn:
+		return int64(m.ConsumerSequence)
+	case NumDeliveredColumn:
+		return int64(m.NumDelivered)
+	case TimestampColumn:
+		return m.Timestamp.Format(time.RFC3339Nano)
+	case HeadersColumn:
+		headers := make(map[string]interface{}, len(m.Headers))
+		for key, values := range m.Headers {
+			if len(values) > 0 {
+				headers[key] = values[0]
+			}
+		}
+		return headers
+	}
+	if key, ok := headerKey(name); ok {
+		if values := m.Headers[key]; len(values) > 0 {
+			return values[0]
+		}
+		return nil
+	}
+	if n, ok := subjectToken(name); ok {
+		tokens := strings.Split(m.Subject, ".")
+		if n < 1 || n > len(tokens) {
+			return nil
+		}
+		return tokens[n-1]
+	}
+	return nil
+}
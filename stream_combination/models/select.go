@@ -3,9 +3,9 @@ package models
 type Transform struct{}
 
 type SelectCondition struct {
-	Field          []string
-	StreamName     *string // nil when no alias
-	Transforms     []Transform
-	GeneratedAlias string
-	Alias          string
+	Field          []string    `yaml:"field"`
+	StreamName     *string     `yaml:"stream,omitempty"` // nil when no alias
+	Transforms     []Transform `yaml:"-"`
+	GeneratedAlias string      `yaml:"-"`
+	Alias          string      `yaml:"alias,omitempty"`
 }
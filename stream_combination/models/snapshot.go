@@ -0,0 +1,51 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Snapshot An event as saved in a checkpoint, from which it's recreated with Event
+type Snapshot struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Metadata  *Metadata              `json:"metadata,omitempty"`
+	Origin    string                 `json:"origin,omitempty"`
+	Left      *Snapshot              `json:"left,omitempty"` // set for join events
+	Right     *Snapshot              `json:"right,omitempty"`
+	// LeftAlias, RightAlias The aliases of a join event's sides
+	LeftAlias  string `json:"left_alias,omitempty"`
+	RightAlias string `json:"right_alias,omitempty"`
+}
+
+// SnapshotOf A snapshot of an Event or JoinEvent, or an error for any other kind of event
+func SnapshotOf(event EventLike) (*Snapshot, error) {
+	switch e := event.(type) {
+	case *Event:
+		return &Snapshot{Timestamp: e.Timestamp, Data: e.data, Metadata: e.metadata, Origin: e.origin}, nil
+	case Event:
+		return SnapshotOf(&e)
+	case JoinEvent:
+		left, err := SnapshotOf(e.LeftEvent)
+		if err != nil {
+			return nil, err
+		}
+		right, err := SnapshotOf(e.RightEvent)
+		if err != nil {
+			return nil, err
+		}
+		return &Snapshot{Timestamp: e.Timestamp, Left: left, Right: right, LeftAlias: e.LeftAlias, RightAlias: e.RightAlias}, nil
+	default:
+		return nil, fmt.Errorf("can't snapshot %T", event)
+	}
+}
+
+// Event The event the snapshot was taken of
+func (s *Snapshot) Event() EventLike {
+	if s.Left != nil && s.Right != nil {
+		joined := NewJoinEvent(s.Timestamp, s.Left.Event(), s.Right.Event())
+		joined.LeftAlias, joined.RightAlias = s.LeftAlias, s.RightAlias
+		return joined
+	}
+	return &Event{Timestamp: s.Timestamp, data: s.Data, metadata: s.Metadata, origin: s.Origin}
+}
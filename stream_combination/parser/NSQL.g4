@@ -1,10 +1,68 @@
 grammar NSQL;
 
 // Parser rules
-query: selectStatement EOF;
+query: statement EOF;
+
+statement
+    : selectStatement
+    | createStreamStatement
+    | declareStreamStatement
+    | showStatement
+    | describeStatement
+    | inferStatement
+    | terminateStatement
+    | explainStatement
+    ;
+
+createStreamStatement
+    : CREATE STREAM IDENTIFIER withClause? AS selectStatement (EMIT CHANGES)?
+    ;
+
+declareStreamStatement
+    : CREATE STREAM IDENTIFIER '(' columnDefinition (',' columnDefinition)* ')' withClause?
+    ;
+
+columnDefinition
+    : IDENTIFIER dataType
+    ;
+
+dataType
+    : ARRAY '<' dataType '>'                                   # arrayType
+    | MAP '<' dataType ',' dataType '>'                        # mapType
+    | STRUCT '<' columnDefinition (',' columnDefinition)* '>'  # structType
+    | IDENTIFIER                                               # primitiveType
+    ;
+
+withClause
+    : WITH '(' property (',' property)* ')'
+    ;
+
+property
+    : IDENTIFIER '=' (STRING | NUMBER | IDENTIFIER)
+    ;
+
+showStatement
+    : SHOW (QUERIES | STREAMS | TABLES)
+    ;
+
+describeStatement
+    : DESCRIBE EXTENDED? IDENTIFIER
+    ;
+
+inferStatement
+    : INFER SCHEMA IDENTIFIER (LIMIT NUMBER)? SAVE?
+    ;
+
+explainStatement
+    : EXPLAIN GRAPHVIZ? (selectStatement | createStreamStatement)
+    ;
+
+terminateStatement
+    : TERMINATE (IDENTIFIER | STRING)
+    ;
 
 selectStatement
-    : SELECT selectList FROM tableExpression whereClause? groupByClause? limitClause?
+    : SELECT selectList FROM tableExpression whereClause? groupByClause? limitClause? parallelismClause?
     ;
 
 selectList
@@ -17,7 +75,14 @@ selectItem
     ;
 
 tableExpression
-    : IDENTIFIER (AS? IDENTIFIER)? joinClause*
+    : tableSource (AS? IDENTIFIER)? withClause? joinClause*
+    ;
+
+// A stream by name, or the stream storing one or more subjects, which may have wildcards
+tableSource
+    : IDENTIFIER
+    | STRING
+    | '(' STRING (',' STRING)* ')'
     ;
 
 joinClause
@@ -44,15 +109,23 @@ limitClause
     : LIMIT NUMBER
     ;
 
+// How many partitions joins are split into by join key
+parallelismClause
+    : PARALLELISM NUMBER
+    ;
+
 qualifiedIdentifier: IDENTIFIER ('.' IDENTIFIER)*;
 
+// Alternatives are listed from highest to lowest precedence
 expression
-    : expression AND expression                          # andExpression
-    | expression OR expression                           # orExpression
-    | NOT expression                                     # notExpression
-    | expression comparisonOp expression                 # comparisonExpression
+    : expression comparisonOp expression                 # comparisonExpression
     | expression LIKE STRING                             # likeExpression
     | expression IN '(' expressionList ')'               # inExpression
+    | NOT expression                                     # notExpression
+    | expression AND expression                          # andExpression
+    | expression OR expression                           # orExpression
+    | qualifiedIdentifier '[' STRING ']'                 # subscriptExpression
+    | qualifiedIdentifier '(' expressionList? ')'        # functionCallExpression
     | qualifiedIdentifier                                # qualifiedIdentifierExpression
     | IDENTIFIER                                         # identifierExpression
     | STRING                                             # stringExpression
@@ -69,12 +142,33 @@ comparisonOp
     ;
 
 // Lexer rules
+CREATE: 'CREATE';
+STREAM: 'STREAM';
+EMIT: 'EMIT';
+CHANGES: 'CHANGES';
+SHOW: 'SHOW';
+QUERIES: 'QUERIES';
+STREAMS: 'STREAMS';
+TABLES: 'TABLES';
+DESCRIBE: 'DESCRIBE';
+EXTENDED: 'EXTENDED';
+TERMINATE: 'TERMINATE';
+EXPLAIN: 'EXPLAIN';
+GRAPHVIZ: 'GRAPHVIZ';
+WITH: 'WITH';
+ARRAY: 'ARRAY';
+MAP: 'MAP';
+STRUCT: 'STRUCT';
+INFER: 'INFER';
+SCHEMA: 'SCHEMA';
+SAVE: 'SAVE';
 SELECT: 'SELECT';
 FROM: 'FROM';
 WHERE: 'WHERE';
 GROUP: 'GROUP';
 BY: 'BY';
 LIMIT: 'LIMIT';
+PARALLELISM: 'PARALLELISM';
 AS: 'AS';
 INNER: 'INNER';
 JOIN: 'JOIN';
@@ -104,4 +198,7 @@ IDENTIFIER: [a-zA-Z_][a-zA-Z0-9_]*;
 STRING: '\'' (~'\'' | '\\\'')* '\'';
 NUMBER: [0-9]+ ('.' [0-9]+)?;
 
+// Ends each statement of a script, see SplitStatements. No statement takes it.
+SEMICOLON: ';';
+
 WS: [ \t\r\n]+ -> skip;
\ No newline at end of file
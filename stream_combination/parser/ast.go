@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"stream_combination/codec"
 	"stream_combination/models"
 	"stream_combination/processor"
 	"time"
@@ -10,17 +11,52 @@ import (
 // This controls items being added to a ProcessorBuilder in the second stage of building.
 
 type Node interface {
+	// Visit Add the node's processors to `ctx`, returning the last of them, or an error if they can't be built
 	Visit(ctx *processor.ProcessorBuilder) interface{}
 }
 
+// visitProcessor Visit a node that adds processors, returning the last of them
+func visitProcessor(node Node, ctx *processor.ProcessorBuilder) (processor.Processor, error) {
+	switch result := node.Visit(ctx).(type) {
+	case error:
+		return nil, result
+	case processor.Processor:
+		return result, nil
+	default:
+		return nil, fmt.Errorf("expected a source of events, got %T", result)
+	}
+}
+
 type Source struct {
 	StreamName string
 	Alias      *string
+	Properties map[string]string // From `WITH (...)`, e.g. the payload FORMAT
+	// Subjects Filter subjects from `FROM 'orders.*.created'`. StreamName is resolved from them by ResolveSubjects.
+	Subjects []string
+	Pos      Position
 }
 
 func (S Source) Visit(ctx *processor.ProcessorBuilder) interface{} {
-	sourceProcessor, _ := processor.NewSubjectReader(ctx.JetStream, S.StreamName)
+	sourceProcessor, err := processor.NewSubjectReader(ctx.JetStream, S.StreamName)
+	if err != nil {
+		return fmt.Errorf("%s: source %s: %w", S.Pos, S.StreamName, err)
+	}
+	if err := sourceProcessor.SetEncoding(codec.ConfigFromProperties(S.Properties)); err != nil {
+		return fmt.Errorf("%s: source %s: %w", S.Pos, S.StreamName, err)
+	}
+	sourceProcessor.SetDeadLetterSubject(S.Properties["DEAD_LETTER_SUBJECT"])
+	sourceProcessor.SetMaxPending(ctx.BufferSize())
+	if len(S.Subjects) > 0 {
+		sourceProcessor.SetFilterSubjects(S.Subjects...)
+	}
+	if consumer := ctx.SharedConsumer(sourceName(S)); consumer != "" {
+		sourceProcessor.SetDurable(consumer)
+	}
+	if interval := ctx.CheckpointInterval(); interval > 0 {
+		sourceProcessor.SetCheckpointed(interval)
+	}
 	ctx.AddProcessor(sourceProcessor.ID(), sourceProcessor)
+	ctx.SetLocation(sourceProcessor.ID(), S.Pos.String())
 	if S.Alias != nil {
 		ctx.AddAlias(*S.Alias, sourceProcessor.ID())
 	} else {
@@ -30,9 +66,24 @@ func (S Source) Visit(ctx *processor.ProcessorBuilder) interface{} {
 	return sourceProcessor
 }
 
+// Position Where a node starts in the query text, for error messages
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d:%d", p.Line, p.Column)
+}
+
+func (p Position) errorf(format string, args ...interface{}) SemanticError {
+	return SemanticError{Line: p.Line, Column: p.Column, Message: fmt.Sprintf(format, args...)}
+}
+
 type FieldReference struct {
 	Source *string
 	Field  string
+	Pos    Position
 }
 
 func (F FieldReference) Visit(ctx *processor.ProcessorBuilder) interface{} {
@@ -48,11 +99,8 @@ func (F FieldReference) Compile(ctx *processor.ProcessorBuilder) func(models.Eve
 	}
 
 	return func(event models.EventLike) Value {
-		value := event.GetField(FieldValue)
-		if value == nil {
-			panic("invalid field value " + FieldValue)
-		}
-		return NewValue(value)
+		// A missing field, or a header the message doesn't have, is NULL
+		return NewValue(event.GetField(FieldValue))
 	}
 }
 
@@ -60,56 +108,80 @@ type Column struct {
 	Source *string
 	Field  string
 	Alias  *string
+	Pos    Position
+}
+
+// Name The field as it is read from the source event, e.g. `u.user_id`
+func (c Column) Name() string {
+	if c.Source != nil {
+		return fmt.Sprintf("%s.%s", *c.Source, c.Field)
+	}
+	return c.Field
+}
+
+// OutputName The field as it appears in query results
+func (c Column) OutputName() string {
+	if c.Alias != nil {
+		return *c.Alias
+	}
+	return c.Name()
 }
 
 type SelectNode struct {
-	Source Node
-	Fields []Column
+	Source      Node
+	Fields      []Column
+	Parallelism int // From `PARALLELISM n`, or 0 for the builder's default
+	Pos         Position
 }
 
 func (sel SelectNode) Visit(ctx *processor.ProcessorBuilder) interface{} {
+	if sel.Parallelism > 0 {
+		ctx.SetParallelism(sel.Parallelism)
+	}
 	// TODO: Ensure Source adds itself to ctx.
-	sourceProcessor := sel.Source.Visit(ctx).(processor.Processor)
+	sourceProcessor, err := visitProcessor(sel.Source, ctx)
+	if err != nil {
+		return err
+	}
 	// TODO: Validate that the fields are valid from these sources, or that these sources indicate their provenance.
-	fieldNames := make([]string, len(sel.Fields))
+	fieldNames := make([]string, 0, len(sel.Fields))
+	aliases := make([]string, 0, len(sel.Fields))
 	for _, field := range sel.Fields {
-		if field.Source != nil {
-			fieldNames = append(fieldNames, fmt.Sprintf("%s.%s", field.Source, field.Field))
-		} else {
-			fieldNames = append(fieldNames, field.Field)
-		}
+		fieldNames = append(fieldNames, field.Name())
+		aliases = append(aliases, field.OutputName())
 	}
-	filterProcessor, _ := processor.NewColumnFilter(fieldNames, 50)
+	filterProcessor, _ := processor.NewAliasedColumnFilter(fieldNames, aliases, ctx.BufferSize())
 	ctx.AddProcessor(filterProcessor.ID(), filterProcessor, sourceProcessor.ID())
+	ctx.SetLocation(filterProcessor.ID(), sel.Pos.String())
 	// Add Sink, even if it's the wrong place
-	sinkProcessor := processor.NewConsoleSink()
-	ctx.AddProcessor(sinkProcessor.ID(), &sinkProcessor, filterProcessor.ID())
+	sinkProcessor := ctx.NewSink()
+	ctx.AddProcessor(sinkProcessor.ID(), sinkProcessor, filterProcessor.ID())
+	ctx.SetLocation(sinkProcessor.ID(), sel.Pos.String())
 	return sinkProcessor
 }
 
 type WhereNode struct {
 	Source Node
 	Filter Evaluatable
+	Pos    Position
 }
 
 func toBoolFunc(valueFn func(models.EventLike) Value) func(models.EventLike) bool {
 	return func(event models.EventLike) bool {
-		val := valueFn(event)
-		boolVal, ok := val.(BooleanValue)
-		if !ok {
-			panic(fmt.Sprintf("expected BooleanValue, got %T", val))
-		}
-		return boolVal.Unwrap()
+		return truth(valueFn(event)).Unwrap()
 	}
 }
 
 func (w WhereNode) Visit(ctx *processor.ProcessorBuilder) interface{} {
 	// Need to provide a WhereProcessor
-	sourceProcessor := w.Source.Visit(ctx).(processor.Processor)
+	sourceProcessor, err := visitProcessor(w.Source, ctx)
+	if err != nil {
+		return err
+	}
 	evaluationFn := toBoolFunc(w.Filter.Compile(ctx))
-	// TODO: Make buffer size less arbitrary
-	whereFilterProcessor, _ := processor.NewWhereFilter(evaluationFn, 50)
+	whereFilterProcessor, _ := processor.NewWhereFilter(evaluationFn, ctx.BufferSize())
 	ctx.AddProcessor(whereFilterProcessor.ID(), whereFilterProcessor, sourceProcessor.ID())
+	ctx.SetLocation(whereFilterProcessor.ID(), w.Pos.String())
 	return whereFilterProcessor
 }
 
@@ -132,14 +204,101 @@ type JoinWindow struct {
 	RHS    Node
 	Within time.Duration
 	On     Evaluatable
+	Pos    Position
 }
 
 func (J JoinWindow) Visit(ctx *processor.ProcessorBuilder) interface{} {
-	lhsSource := J.LHS.Visit(ctx).(processor.MessageProcessor)
-	rhsSource := J.RHS.Visit(ctx).(processor.MessageProcessor)
+	lhsSource, err := visitProcessor(J.LHS, ctx)
+	if err != nil {
+		return err
+	}
+	rhsSource, err := visitProcessor(J.RHS, ctx)
+	if err != nil {
+		return err
+	}
 
-	// TODO: Write Predicate function here.
-	swj := processor.NewSlidingWindowJoin(J.Within, make([]processor.EquiJoinPredicate, 0))
-	ctx.AddDualProcessor(swj.ID(), swj, lhsSource.ID(), rhsSource.ID())
-	return swj
+	predicates, residual := J.equiJoinPredicates()
+	if len(predicates) == 0 && ctx.Parallelism() > 1 {
+		return J.Pos.errorf("PARALLELISM needs a join key, an equality of a field of each side in ON, e.g. `l.id = r.id`")
+	}
+	join := ctx.NewWindowJoin(J.Within, predicates)
+	ctx.AddDualProcessor(join.ID(), join, lhsSource.ID(), rhsSource.ID())
+	ctx.SetLocation(join.ID(), J.Pos.String())
+	if residual == nil {
+		return join
+	}
+	// The rest of ON is checked on each joined pair
+	filter, _ := processor.NewWhereFilter(toBoolFunc(residual.Compile(ctx)), ctx.BufferSize())
+	ctx.AddProcessor(filter.ID(), filter, join.ID())
+	ctx.SetLocation(filter.ID(), J.Pos.String())
+	return filter
+}
+
+// equiJoinPredicates The join keys: each `l.x = r.y` ANDed in ON, where `l` is the left source and `r` the right,
+// in either order, and the rest of ON, or nil if the keys are all of it. Joins of joins have no keys, so all of
+// their ON is the rest.
+func (J JoinWindow) equiJoinPredicates() ([]processor.EquiJoinPredicate, Evaluatable) {
+	left, right := sourceName(J.LHS), sourceName(J.RHS)
+	var predicates []processor.EquiJoinPredicate
+	var residual Evaluatable
+	for _, condition := range conjuncts(J.On) {
+		leftField, rightField, ok := equiJoinFields(condition, left, right)
+		if !ok {
+			if residual == nil {
+				residual = condition
+			} else {
+				residual = And{residual, condition}
+			}
+			continue
+		}
+		predicates = append(predicates, *processor.NewEquiJoin(
+			func(event models.EventLike) string { return event.GetString(leftField) },
+			func(event models.EventLike) string { return event.GetString(rightField) },
+		))
+	}
+	return predicates, residual
+}
+
+// equiJoinFields The fields of the left and right sources a condition equates, if it's `l.x = r.y` or `r.y = l.x`
+func equiJoinFields(condition Evaluatable, left string, right string) (string, string, bool) {
+	eq, ok := condition.(EQ)
+	if !ok || left == "" || right == "" {
+		return "", "", false
+	}
+	l, lok := eq.LHS.(FieldReference)
+	r, rok := eq.RHS.(FieldReference)
+	if !lok || !rok || l.Source == nil || r.Source == nil {
+		return "", "", false
+	}
+	if *l.Source == right && *r.Source == left {
+		l, r = r, l
+	}
+	if *l.Source != left || *r.Source != right {
+		return "", "", false
+	}
+	return l.Field, r.Field, true
+}
+
+// sourceName The name fields of a source are qualified by, or "" if the node isn't a single source
+func sourceName(node Node) string {
+	var source Source
+	switch n := node.(type) {
+	case *Source:
+		source = *n
+	case Source:
+		source = n
+	default:
+		return ""
+	}
+	if source.Alias != nil {
+		return *source.Alias
+	}
+	return source.StreamName
+}
+
+func conjuncts(expr Evaluatable) []Evaluatable {
+	if and, ok := expr.(And); ok {
+		return append(conjuncts(and.LHS), conjuncts(and.RHS)...)
+	}
+	return []Evaluatable{expr}
 }
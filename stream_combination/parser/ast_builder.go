@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/antlr4-go/antlr/v4"
 )
 
 func splitColumnName(s string) (*string, string) {
@@ -27,6 +29,10 @@ type SemanticError struct {
 	Message string
 }
 
+func (e SemanticError) Error() string {
+	return fmt.Sprintf("line %d:%d - %s", e.Line, e.Column, e.Message)
+}
+
 func NewASTBuilderVisitor() *ASTBuilderVisitor {
 	return &ASTBuilderVisitor{
 		BaseNSQLVisitor: &BaseNSQLVisitor{},
@@ -46,37 +52,108 @@ func (v *ASTBuilderVisitor) ClearErrors() {
 	v.errors = v.errors[:0]
 }
 
-func (v *ASTBuilderVisitor) addError(ctx interface{}, message string) {
-	// Try to get position info from context
-	var line, column int
+func (v *ASTBuilderVisitor) addError(ctx antlr.ParserRuleContext, message string) {
+	v.errors = append(v.errors, positionOf(ctx).errorf("%s", message))
+}
+
+func positionOf(ctx antlr.ParserRuleContext) Position {
+	return Position{Line: ctx.GetStart().GetLine(), Column: ctx.GetStart().GetColumn()}
+}
+
+func (v *ASTBuilderVisitor) VisitQuery(ctx *QueryContext) interface{} {
+	if statement := ctx.Statement(); statement != nil {
+		return statement.Accept(v)
+	}
+	return nil
+}
+
+func (v *ASTBuilderVisitor) VisitStatement(ctx *StatementContext) interface{} {
+	switch {
+	case ctx.SelectStatement() != nil:
+		return ctx.SelectStatement().Accept(v)
+	case ctx.CreateStreamStatement() != nil:
+		return ctx.CreateStreamStatement().Accept(v)
+	case ctx.DeclareStreamStatement() != nil:
+		return ctx.DeclareStreamStatement().Accept(v)
+	case ctx.ShowStatement() != nil:
+		return ctx.ShowStatement().Accept(v)
+	case ctx.DescribeStatement() != nil:
+		return ctx.DescribeStatement().Accept(v)
+	case ctx.InferStatement() != nil:
+		return ctx.InferStatement().Accept(v)
+	case ctx.TerminateStatement() != nil:
+		return ctx.TerminateStatement().Accept(v)
+	case ctx.ExplainStatement() != nil:
+		return ctx.ExplainStatement().Accept(v)
+	default:
+		v.addError(ctx, "Unsupported statement")
+		return nil
+	}
+}
+
+func (v *ASTBuilderVisitor) VisitCreateStreamStatement(ctx *CreateStreamStatementContext) interface{} {
+	createStream := &CreateStreamAs{
+		Name:  ctx.IDENTIFIER().GetText(),
+		Query: ctx.SelectStatement().Accept(v).(*SelectNode),
+	}
+	if ctx.WithClause() != nil {
+		createStream.Properties = ctx.WithClause().Accept(v).(map[string]string)
+	}
+	return createStream
+}
+
+func (v *ASTBuilderVisitor) VisitShowStatement(ctx *ShowStatementContext) interface{} {
+	switch {
+	case ctx.STREAMS() != nil:
+		return &ShowStreams{}
+	case ctx.TABLES() != nil:
+		return &ShowTables{}
+	default:
+		return &ShowQueries{}
+	}
+}
+
+func (v *ASTBuilderVisitor) VisitDescribeStatement(ctx *DescribeStatementContext) interface{} {
+	return &Describe{
+		Stream:   ctx.IDENTIFIER().GetText(),
+		Extended: ctx.EXTENDED() != nil,
+	}
+}
 
-	// This depends On your specific context type, but usually:
-	if ctxWithToken, ok := ctx.(interface {
-		GetStart() interface {
-			GetLine() int
-			GetColumn() int
+func (v *ASTBuilderVisitor) VisitInferStatement(ctx *InferStatementContext) interface{} {
+	infer := &InferSchema{
+		Stream: ctx.IDENTIFIER().GetText(),
+		Save:   ctx.SAVE() != nil,
+	}
+	if ctx.NUMBER() != nil {
+		limit, err := strconv.Atoi(ctx.NUMBER().GetText())
+		if err != nil || limit <= 0 {
+			v.addError(ctx, fmt.Sprintf("LIMIT must be a positive integer, got %s", ctx.NUMBER().GetText()))
 		}
-	}); ok {
-		line = ctxWithToken.GetStart().GetLine()
-		column = ctxWithToken.GetStart().GetColumn()
+		infer.Limit = limit
 	}
+	return infer
+}
 
-	v.errors = append(v.errors, SemanticError{
-		Line:    line,
-		Column:  column,
-		Message: message,
-	})
+func (v *ASTBuilderVisitor) VisitExplainStatement(ctx *ExplainStatementContext) interface{} {
+	explain := &Explain{Graphviz: ctx.GRAPHVIZ() != nil}
+	if ctx.SelectStatement() != nil {
+		explain.Statement = ctx.SelectStatement().Accept(v).(*SelectNode)
+	} else {
+		explain.Statement = ctx.CreateStreamStatement().Accept(v).(*CreateStreamAs)
+	}
+	return explain
 }
 
-func (v *ASTBuilderVisitor) VisitQuery(ctx *QueryContext) interface{} {
-	if selectStmt := ctx.SelectStatement(); selectStmt != nil {
-		return selectStmt.Accept(v)
+func (v *ASTBuilderVisitor) VisitTerminateStatement(ctx *TerminateStatementContext) interface{} {
+	if ctx.STRING() != nil {
+		return &TerminateQuery{ID: strings.Trim(ctx.STRING().GetText(), "'")}
 	}
-	return nil
+	return &TerminateQuery{ID: ctx.IDENTIFIER().GetText()}
 }
 
 func (v *ASTBuilderVisitor) VisitSelectStatement(ctx *SelectStatementContext) interface{} {
-	selectNode := &SelectNode{}
+	selectNode := &SelectNode{Pos: positionOf(ctx)}
 	if tableExpr := ctx.TableExpression(); tableExpr != nil {
 		source := tableExpr.Accept(v).(Node)
 		selectNode.Source = source
@@ -88,6 +165,10 @@ func (v *ASTBuilderVisitor) VisitSelectStatement(ctx *SelectStatementContext) in
 		selectNode.Fields = fields
 	}
 
+	if parallelism := ctx.ParallelismClause(); parallelism != nil {
+		selectNode.Parallelism = parallelism.Accept(v).(int)
+	}
+
 	// If there's a WhereClause, put it as the source for the Select.
 	if whereClause := ctx.WhereClause(); whereClause != nil {
 		whereNode := whereClause.Accept(v).(WhereNode)
@@ -99,18 +180,16 @@ func (v *ASTBuilderVisitor) VisitSelectStatement(ctx *SelectStatementContext) in
 }
 
 func (v *ASTBuilderVisitor) VisitTableExpression(ctx *TableExpressionContext) interface{} {
-	streamName := ctx.IDENTIFIER(0).GetText()
-
-	source := &Source{
-		StreamName: streamName,
-		Alias:      nil,
-	}
+	source := ctx.TableSource().Accept(v).(*Source)
 
 	// It almost doesn't matter if the AS is there or not.
-	if ctx.IDENTIFIER(1) != nil {
-		alias := ctx.IDENTIFIER(1).GetText()
+	if ctx.IDENTIFIER() != nil {
+		alias := ctx.IDENTIFIER().GetText()
 		source.Alias = &alias
 	}
+	if ctx.WithClause() != nil {
+		source.Properties = ctx.WithClause().Accept(v).(map[string]string)
+	}
 
 	if ctx.JoinClause(0) != nil {
 		jw := ctx.JoinClause(0).Accept(v).(JoinWindow)
@@ -121,12 +200,25 @@ func (v *ASTBuilderVisitor) VisitTableExpression(ctx *TableExpressionContext) in
 	}
 }
 
+// VisitTableSource A stream name, or subjects whose stream is resolved before the query starts
+func (v *ASTBuilderVisitor) VisitTableSource(ctx *TableSourceContext) interface{} {
+	if ctx.IDENTIFIER() != nil {
+		return &Source{StreamName: ctx.IDENTIFIER().GetText(), Pos: positionOf(ctx)}
+	}
+	source := &Source{Pos: positionOf(ctx)}
+	for _, subject := range ctx.AllSTRING() {
+		source.Subjects = append(source.Subjects, strings.Trim(subject.GetText(), "'"))
+	}
+	return source
+}
+
 func (v *ASTBuilderVisitor) VisitJoinClause(ctx *JoinClauseContext) interface{} {
 	jw := JoinWindow{
 		LHS:    nil,
 		RHS:    ctx.TableExpression().Accept(v).(Node),
 		Within: ctx.JoinWindow().Accept(v).(time.Duration),
 		On:     ctx.Expression().Accept(v).(Evaluatable),
+		Pos:    positionOf(ctx),
 	}
 	return jw
 }
@@ -170,11 +262,20 @@ func (v *ASTBuilderVisitor) VisitSelectList(ctx *SelectListContext) interface{}
 }
 
 func (v *ASTBuilderVisitor) VisitWhereClause(ctx *WhereClauseContext) interface{} {
-	whereClause := WhereNode{}
+	whereClause := WhereNode{Pos: positionOf(ctx)}
 	whereClause.Filter = ctx.Expression().Accept(v).(Evaluatable)
 	return whereClause
 }
 
+func (v *ASTBuilderVisitor) VisitParallelismClause(ctx *ParallelismClauseContext) interface{} {
+	n, err := strconv.Atoi(ctx.NUMBER().GetText())
+	if err != nil || n <= 0 {
+		v.addError(ctx, fmt.Sprintf("PARALLELISM must be a positive integer, got %s", ctx.NUMBER().GetText()))
+		return 0
+	}
+	return n
+}
+
 func (v *ASTBuilderVisitor) VisitSelectItem(ctx *SelectItemContext) interface{} {
 	column := Column{}
 
@@ -186,7 +287,13 @@ func (v *ASTBuilderVisitor) VisitSelectItem(ctx *SelectItemContext) interface{}
 
 	// Handle expressions (field names, etc.)
 	if expr := ctx.Expression(); expr != nil {
-		column.Source, column.Field = splitColumnName(expr.GetText())
+		if ref, ok := expr.Accept(v).(FieldReference); ok {
+			// Pseudo-columns like `_headers['X-Tenant']` are named by the visitor, not by their text
+			column.Source, column.Field = ref.Source, ref.Field
+		} else {
+			column.Source, column.Field = splitColumnName(expr.GetText())
+		}
+		column.Pos = positionOf(expr)
 	}
 
 	// Handle alias (AS clause)
@@ -0,0 +1,135 @@
+package parser
+
+import (
+	"stream_combination/models"
+	"stream_combination/processor"
+	"strings"
+	"testing"
+	"time"
+)
+
+func field(source string, name string) FieldReference {
+	return FieldReference{Source: &source, Field: name}
+}
+
+func aliased(stream string, alias string) Source {
+	return Source{StreamName: stream, Alias: &alias}
+}
+
+func TestEquiJoinPredicates(t *testing.T) {
+	users, purchases := aliased("users", "u"), aliased("purchases", "p")
+	tests := []struct {
+		name     string
+		join     JoinWindow
+		keys     int
+		residual string // FormatExpression of the rest of ON, or "" if there's none
+	}{
+		{"equality", JoinWindow{LHS: users, RHS: purchases, On: EQ{field("u", "id"), field("p", "user_id")}}, 1, ""},
+		{"reversed equality", JoinWindow{LHS: users, RHS: purchases, On: EQ{field("p", "user_id"), field("u", "id")}}, 1, ""},
+		{
+			"equality and a condition",
+			JoinWindow{LHS: users, RHS: purchases, On: And{EQ{field("u", "id"), field("p", "user_id")}, EQ{field("p", "quantity"), Constant{NewValue(2)}}}},
+			1, "p.quantity = 2",
+		},
+		{
+			"two equalities",
+			JoinWindow{LHS: users, RHS: purchases, On: And{EQ{field("u", "id"), field("p", "user_id")}, EQ{field("u", "region"), field("p", "region")}}},
+			2, "",
+		},
+		{"no equality of both sides", JoinWindow{LHS: users, RHS: purchases, On: EQ{field("u", "id"), field("u", "owner")}}, 0, "u.id = u.owner"},
+		{"disjunction", JoinWindow{LHS: users, RHS: purchases, On: Or{EQ{field("u", "id"), field("p", "user_id")}, EQ{field("u", "id"), field("p", "referrer")}}}, 0, "u.id = p.user_id OR u.id = p.referrer"},
+		{
+			"join of a join",
+			JoinWindow{LHS: JoinWindow{LHS: users, RHS: purchases}, RHS: aliased("items", "i"), On: EQ{field("p", "sku"), field("i", "sku")}},
+			0, "p.sku = i.sku",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, residual := test.join.equiJoinPredicates()
+			if len(keys) != test.keys {
+				t.Errorf("%d join keys, expected %d", len(keys), test.keys)
+			}
+			got := ""
+			if residual != nil {
+				got = FormatExpression(residual)
+			}
+			if got != test.residual {
+				t.Errorf("rest of ON %q, expected %q", got, test.residual)
+			}
+		})
+	}
+}
+
+func TestEquiJoinPredicatesKeySides(t *testing.T) {
+	join := JoinWindow{LHS: aliased("users", "u"), RHS: aliased("purchases", "p"), On: EQ{field("p", "user_id"), field("u", "id")}}
+	keys, _ := join.equiJoinPredicates()
+	if len(keys) != 1 {
+		t.Fatalf("%d join keys, expected 1", len(keys))
+	}
+	user := models.NewEvent(time.Now(), map[string]interface{}{"id": "u1"})
+	purchase := models.NewEvent(time.Now(), map[string]interface{}{"user_id": "u1"})
+	if left, right := keys[0].Left(user), keys[0].Right(purchase); left != "u1" || right != "u1" {
+		t.Errorf("keys %q and %q, expected u1 for both sides", left, right)
+	}
+}
+
+func TestJoinAppliesRestOfOn(t *testing.T) {
+	join := JoinWindow{
+		LHS:    aliased("users", "u"),
+		RHS:    aliased("purchases", "p"),
+		Within: time.Minute,
+		On:     And{EQ{field("u", "id"), field("p", "user_id")}, EQ{field("p", "quantity"), Constant{NewValue(2)}}},
+	}
+	builder := processor.NewProcessorBuilder(nil)
+	if err := Apply(&SelectNode{Source: join, Fields: []Column{{Field: "*"}}}, builder); err != nil {
+		t.Fatal(err)
+	}
+	if dag := builder.String(); !strings.Contains(dag, "SlidingWindowJoin") || !strings.Contains(dag, "WhereFilter") {
+		t.Errorf("expected the join to feed a filter, got\n%s", dag)
+	}
+
+	_, residual := join.equiJoinPredicates()
+	matches := toBoolFunc(residual.Compile(builder))
+	now := time.Now()
+	pair := func(quantity int) models.JoinEvent {
+		joined := models.NewJoinEvent(now,
+			models.NewEvent(now, map[string]interface{}{"id": "u1"}),
+			models.NewEvent(now, map[string]interface{}{"user_id": "u1", "quantity": quantity}),
+		)
+		joined.LeftAlias, joined.RightAlias = "u", "p"
+		return joined
+	}
+	if !matches(pair(2)) {
+		t.Error("a purchase of 2 should pass the rest of ON")
+	}
+	if matches(pair(3)) {
+		t.Error("a purchase of 3 should fail the rest of ON")
+	}
+}
+
+func TestParallelismNeedsJoinKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		on          Evaluatable
+		parallelism int
+		fails       bool
+	}{
+		{"key", EQ{field("u", "id"), field("p", "user_id")}, 4, false},
+		{"no key", EQ{field("u", "id"), Constant{NewValue(1)}}, 4, true},
+		{"no key without parallelism", EQ{field("u", "id"), Constant{NewValue(1)}}, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			join := JoinWindow{LHS: aliased("users", "u"), RHS: aliased("purchases", "p"), Within: time.Minute, On: test.on}
+			query := &SelectNode{Source: join, Fields: []Column{{Field: "*"}}, Parallelism: test.parallelism}
+			err := Apply(query, processor.NewProcessorBuilder(nil))
+			if test.fails && (err == nil || !strings.Contains(err.Error(), "PARALLELISM")) {
+				t.Errorf("expected a PARALLELISM error, got %v", err)
+			}
+			if !test.fails && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
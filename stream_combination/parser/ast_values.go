@@ -2,6 +2,7 @@ package parser
 
 import "C"
 import (
+	"cmp"
 	"fmt"
 	"strconv"
 	"strings"
@@ -32,196 +33,160 @@ func NewValue(value interface{}) Value {
 	case float64:
 		return FloatValue{val: value.(float64)}
 	case int:
+		return IntValue{val: int64(value.(int))}
+	case int64:
 		return IntValue{val: value.(int64)}
 	case bool:
 		return BooleanValue{val: value.(bool)}
+	case map[string]interface{}:
+		return MapValue{val: value.(map[string]interface{})}
 	default:
 		panic(fmt.Sprintf("unknown type %T with value %#v", value, value))
 	}
 }
 
-type BooleanValue struct{ val bool }
-
-func (B BooleanValue) Unwrap() bool {
-	return B.val
-}
-
-func (B BooleanValue) Or(other BooleanValue) BooleanValue {
-	return BooleanValue{val: B.val || other.val}
-}
-
-func (B BooleanValue) And(other BooleanValue) BooleanValue {
-	return BooleanValue{val: B.val && other.val}
-}
-
-func (B BooleanValue) Not() BooleanValue {
-	return BooleanValue{val: !B.val}
-}
-
-func (B BooleanValue) Eq(other Value) BooleanValue {
-	switch other := other.(type) {
-	case BooleanValue:
-		return B.And(other)
-	default:
-		panic("Boolean type mismatch")
+// compare Order two values: numbers by value, strings and booleans among themselves, and a string against a number
+// or boolean as what it parses as, as strings are when they're read. `ok` is false when they can't be compared,
+// e.g. when either is NULL, and then every comparison of them is false.
+func compare(a, b Value) (order int, ok bool) {
+	if s, isString := a.(StringValue); isString {
+		if _, bothStrings := b.(StringValue); !bothStrings {
+			a = NewValueInferenceFromString(s.val)
+		}
 	}
-}
-
-func (B BooleanValue) NEq(other Value) BooleanValue {
-	switch other := other.(type) {
+	if s, isString := b.(StringValue); isString {
+		if _, bothStrings := a.(StringValue); !bothStrings {
+			b = NewValueInferenceFromString(s.val)
+		}
+	}
+	switch a := a.(type) {
+	case IntValue:
+		switch b := b.(type) {
+		case IntValue:
+			return cmp.Compare(a.val, b.val), true
+		case FloatValue:
+			return cmp.Compare(float64(a.val), b.val), true
+		}
+	case FloatValue:
+		switch b := b.(type) {
+		case IntValue:
+			return cmp.Compare(a.val, float64(b.val)), true
+		case FloatValue:
+			return cmp.Compare(a.val, b.val), true
+		}
+	case StringValue:
+		if b, ok := b.(StringValue); ok {
+			return strings.Compare(a.val, b.val), true
+		}
 	case BooleanValue:
-		return B.Eq(other.Not())
-	default:
-		panic("Boolean type mismatch")
+		if b, ok := b.(BooleanValue); ok {
+			switch {
+			case a.val == b.val:
+				return 0, true
+			case b.val:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
 	}
+	return 0, false
 }
 
-func (B BooleanValue) Lt(other Value) BooleanValue {
-	panic("Boolean type mismatch")
+// comparison The result of comparing two values, true if `holds` for their order
+func comparison(a, b Value, holds func(order int) bool) BooleanValue {
+	order, ok := compare(a, b)
+	return BooleanValue{val: ok && holds(order)}
 }
 
-func (B BooleanValue) Lte(other Value) BooleanValue {
-	panic("Boolean type mismatch")
-}
+func eq(a, b Value) BooleanValue  { return comparison(a, b, func(o int) bool { return o == 0 }) }
+func neq(a, b Value) BooleanValue { return comparison(a, b, func(o int) bool { return o != 0 }) }
+func lt(a, b Value) BooleanValue  { return comparison(a, b, func(o int) bool { return o < 0 }) }
+func lte(a, b Value) BooleanValue { return comparison(a, b, func(o int) bool { return o <= 0 }) }
+func gt(a, b Value) BooleanValue  { return comparison(a, b, func(o int) bool { return o > 0 }) }
+func gte(a, b Value) BooleanValue { return comparison(a, b, func(o int) bool { return o >= 0 }) }
 
-func (B BooleanValue) Gt(other Value) BooleanValue {
-	panic("Boolean type mismatch")
-}
-
-func (B BooleanValue) Gte(other Value) BooleanValue {
-	panic("Boolean type mismatch")
-}
-
-type IntValue struct{ val int64 }
-
-func (i IntValue) Eq(other Value) BooleanValue {
-	switch other := other.(type) {
-	case IntValue:
-		return BooleanValue{val: i.val == other.val}
-	case FloatValue:
-		return BooleanValue{val: float64(i.val) == other.val}
+// truth Whether a condition holds. A NULL condition, e.g. a missing boolean field, doesn't.
+func truth(value Value) BooleanValue {
+	switch value := value.(type) {
+	case BooleanValue:
+		return value
+	case NullValue:
+		return BooleanValue{}
 	default:
-		panic("Boolean type mismatch")
+		panic(fmt.Sprintf("expected BooleanValue, got %T", value))
 	}
 }
 
-func (i IntValue) NEq(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (i IntValue) Lt(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
+type BooleanValue struct{ val bool }
 
-func (i IntValue) Lte(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
+func (B BooleanValue) Unwrap() bool {
+	return B.val
 }
 
-func (i IntValue) Gt(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
+func (B BooleanValue) Or(other BooleanValue) BooleanValue {
+	return BooleanValue{val: B.val || other.val}
 }
 
-func (i IntValue) Gte(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
+func (B BooleanValue) And(other BooleanValue) BooleanValue {
+	return BooleanValue{val: B.val && other.val}
 }
 
-type StringValue struct{ val string }
-
-func (s StringValue) Eq(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
+func (B BooleanValue) Not() BooleanValue {
+	return BooleanValue{val: !B.val}
 }
 
-func (s StringValue) NEq(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
+func (B BooleanValue) Eq(other Value) BooleanValue  { return eq(B, other) }
+func (B BooleanValue) NEq(other Value) BooleanValue { return neq(B, other) }
+func (B BooleanValue) Lt(other Value) BooleanValue  { return lt(B, other) }
+func (B BooleanValue) Lte(other Value) BooleanValue { return lte(B, other) }
+func (B BooleanValue) Gt(other Value) BooleanValue  { return gt(B, other) }
+func (B BooleanValue) Gte(other Value) BooleanValue { return gte(B, other) }
 
-func (s StringValue) Lt(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
+type IntValue struct{ val int64 }
 
-func (s StringValue) Lte(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
+func (i IntValue) Eq(other Value) BooleanValue  { return eq(i, other) }
+func (i IntValue) NEq(other Value) BooleanValue { return neq(i, other) }
+func (i IntValue) Lt(other Value) BooleanValue  { return lt(i, other) }
+func (i IntValue) Lte(other Value) BooleanValue { return lte(i, other) }
+func (i IntValue) Gt(other Value) BooleanValue  { return gt(i, other) }
+func (i IntValue) Gte(other Value) BooleanValue { return gte(i, other) }
 
-func (s StringValue) Gt(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
+// StringValue A string, without the quotes of a string constant
+type StringValue struct{ val string }
 
-func (s StringValue) Gte(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
+func (s StringValue) Eq(other Value) BooleanValue  { return eq(s, other) }
+func (s StringValue) NEq(other Value) BooleanValue { return neq(s, other) }
+func (s StringValue) Lt(other Value) BooleanValue  { return lt(s, other) }
+func (s StringValue) Lte(other Value) BooleanValue { return lte(s, other) }
+func (s StringValue) Gt(other Value) BooleanValue  { return gt(s, other) }
+func (s StringValue) Gte(other Value) BooleanValue { return gte(s, other) }
 
 type FloatValue struct{ val float64 }
 
-func (f FloatValue) Eq(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (f FloatValue) NEq(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (f FloatValue) Lt(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (f FloatValue) Lte(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (f FloatValue) Gt(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (f FloatValue) Gte(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
+func (f FloatValue) Eq(other Value) BooleanValue  { return eq(f, other) }
+func (f FloatValue) NEq(other Value) BooleanValue { return neq(f, other) }
+func (f FloatValue) Lt(other Value) BooleanValue  { return lt(f, other) }
+func (f FloatValue) Lte(other Value) BooleanValue { return lte(f, other) }
+func (f FloatValue) Gt(other Value) BooleanValue  { return gt(f, other) }
+func (f FloatValue) Gte(other Value) BooleanValue { return gte(f, other) }
 
+// NullValue A missing field, e.g. a header the message doesn't have. Comparisons with it are false.
 type NullValue struct{}
 
-func (n NullValue) Eq(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (n NullValue) NEq(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (n NullValue) Lt(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (n NullValue) Lte(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (n NullValue) Gt(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (n NullValue) Gte(other Value) BooleanValue {
-	//TODO implement me
-	panic("implement me")
-}
+func (n NullValue) Eq(other Value) BooleanValue  { return eq(n, other) }
+func (n NullValue) NEq(other Value) BooleanValue { return neq(n, other) }
+func (n NullValue) Lt(other Value) BooleanValue  { return lt(n, other) }
+func (n NullValue) Lte(other Value) BooleanValue { return lte(n, other) }
+func (n NullValue) Gt(other Value) BooleanValue  { return gt(n, other) }
+func (n NullValue) Gte(other Value) BooleanValue { return gte(n, other) }
+
+// MapValue A nested object, e.g. `_headers`. It can be selected, but not compared: comparisons with it are false.
+type MapValue struct{ val map[string]interface{} }
+
+func (m MapValue) Eq(other Value) BooleanValue  { return eq(m, other) }
+func (m MapValue) NEq(other Value) BooleanValue { return neq(m, other) }
+func (m MapValue) Lt(other Value) BooleanValue  { return lt(m, other) }
+func (m MapValue) Lte(other Value) BooleanValue { return lte(m, other) }
+func (m MapValue) Gt(other Value) BooleanValue  { return gt(m, other) }
+func (m MapValue) Gte(other Value) BooleanValue { return gte(m, other) }
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"context"
+	"stream_combination/models"
+	"stream_combination/processor"
+	"testing"
+	"time"
+)
+
+func TestComparisons(t *testing.T) {
+	tests := []struct {
+		name     string
+		lhs, rhs Value
+		// expected Eq, NEq, Lt, Lte, Gt and Gte of lhs and rhs
+		expected [6]bool
+	}{
+		{"equal ints", IntValue{1}, IntValue{1}, [6]bool{true, false, false, true, false, true}},
+		{"int and float", IntValue{1}, FloatValue{1.5}, [6]bool{false, true, true, true, false, false}},
+		{"strings", StringValue{"b"}, StringValue{"a"}, [6]bool{false, true, false, false, true, true}},
+		// Strings read from messages are compared as the number they parse as
+		{"string and number", StringValue{"10"}, IntValue{9}, [6]bool{false, true, false, false, true, true}},
+		{"booleans", BooleanValue{true}, BooleanValue{true}, [6]bool{true, false, false, true, false, true}},
+		{"string and boolean", StringValue{"true"}, BooleanValue{true}, [6]bool{true, false, false, true, false, true}},
+		{"null", NullValue{}, StringValue{"x"}, [6]bool{}},
+		{"against null", IntValue{1}, NullValue{}, [6]bool{}},
+		{"nulls", NullValue{}, NullValue{}, [6]bool{}},
+		{"map", MapValue{map[string]interface{}{"a": "b"}}, StringValue{"b"}, [6]bool{}},
+		{"string and int that isn't one", StringValue{"abc"}, IntValue{1}, [6]bool{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := [6]bool{
+				test.lhs.Eq(test.rhs).Unwrap(), test.lhs.NEq(test.rhs).Unwrap(),
+				test.lhs.Lt(test.rhs).Unwrap(), test.lhs.Lte(test.rhs).Unwrap(),
+				test.lhs.Gt(test.rhs).Unwrap(), test.lhs.Gte(test.rhs).Unwrap(),
+			}
+			if got != test.expected {
+				t.Errorf("=, !=, <, <=, > and >= are %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+// readMessage An event decoded from a message on `subject` with `headers`
+func readMessage(subject string, headers map[string][]string) models.EventLike {
+	return models.NewEventWithMetadata(time.Now(), map[string]interface{}{"id": float64(1)}, &models.Metadata{
+		Subject:  subject,
+		Stream:   "orders",
+		Sequence: 7,
+		Headers:  headers,
+	})
+}
+
+func TestMetadataColumnsInWhere(t *testing.T) {
+	tenant := func(value string) Evaluatable {
+		return EQ{FieldReference{Field: models.HeaderField("X-Tenant")}, Constant{StringValue{value}}}
+	}
+	tests := []struct {
+		name     string
+		filter   Evaluatable
+		event    models.EventLike
+		expected bool
+	}{
+		{"header", tenant("acme"), readMessage("orders.eu", map[string][]string{"X-Tenant": {"acme"}}), true},
+		{"other header value", tenant("acme"), readMessage("orders.eu", map[string][]string{"X-Tenant": {"other"}}), false},
+		// A missing header is NULL, so the comparison is false rather than failing the pipeline
+		{"missing header", tenant("acme"), readMessage("orders.eu", nil), false},
+		{"missing header negated", Negate{tenant("acme")}, readMessage("orders.eu", nil), true},
+		{"missing header in a conjunction", And{tenant("acme"), EQ{FieldReference{Field: "id"}, Constant{IntValue{1}}}},
+			readMessage("orders.eu", nil), false},
+		{"subject token", EQ{FieldReference{Field: models.SubjectTokenField(2)}, Constant{StringValue{"eu"}}}, readMessage("orders.eu", nil), true},
+		{"subject token out of range", EQ{FieldReference{Field: models.SubjectTokenField(3)}, Constant{StringValue{"eu"}}}, readMessage("orders.eu", nil), false},
+		{"sequence", EQ{FieldReference{Field: models.SequenceColumn}, Constant{IntValue{7}}}, readMessage("orders.eu", nil), true},
+		{"all headers", EQ{FieldReference{Field: models.HeadersColumn}, Constant{StringValue{"acme"}}},
+			readMessage("orders.eu", map[string][]string{"X-Tenant": {"acme"}}), false},
+		{"missing payload field", EQ{FieldReference{Field: "region"}, Constant{StringValue{"eu"}}}, readMessage("orders.eu", nil), false},
+		{"event without metadata", tenant("acme"), models.NewEvent(time.Now(), map[string]interface{}{"id": 1}), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := toBoolFunc(test.filter.Compile(nil))(test.event); got != test.expected {
+				t.Errorf("WHERE %s is %t, expected %t", FormatExpression(test.filter), got, test.expected)
+			}
+		})
+	}
+}
+
+func TestMetadataColumnsInSelect(t *testing.T) {
+	region := "region"
+	columns := []Column{
+		{Field: "id"},
+		{Field: models.HeadersColumn},
+		{Field: models.HeaderField("X-Tenant")},
+		{Field: models.SubjectTokenField(2), Alias: &region},
+		{Field: models.SequenceColumn},
+	}
+	var fields, aliases []string
+	for _, column := range columns {
+		fields = append(fields, column.Name())
+		aliases = append(aliases, column.OutputName())
+	}
+	filter, _ := processor.NewAliasedColumnFilter(fields, aliases, 1)
+	ctx := context.Background()
+	if err := filter.Add(ctx, readMessage("orders.eu", map[string][]string{"Content-Type": {"application/json"}})); err != nil {
+		t.Fatal(err)
+	}
+	row := <-filter.Results(ctx, "test", nil)
+
+	expected := map[string]interface{}{
+		"id":                    float64(1),
+		models.HeadersColumn:    map[string]interface{}{"Content-Type": "application/json"},
+		"region":                "eu",
+		models.SequenceColumn:   int64(7),
+		models.HeaderField("X"): nil,
+	}
+	for field, value := range expected {
+		got := row.GetField(field)
+		if headers, ok := value.(map[string]interface{}); ok {
+			gotHeaders, isMap := got.(map[string]interface{})
+			if !isMap || len(gotHeaders) != len(headers) || gotHeaders["Content-Type"] != headers["Content-Type"] {
+				t.Errorf("%s selected as %v, expected %v", field, got, value)
+			}
+			continue
+		}
+		if got != value {
+			t.Errorf("%s selected as %v, expected %v", field, got, value)
+		}
+	}
+	// A missing header is left out of the row
+	if got := row.GetField(models.HeaderField("X-Tenant")); got != nil {
+		t.Errorf("missing header selected as %v", got)
+	}
+	// Selecting every header must be comparable in a WHERE too, rather than failing the pipeline
+	if value := NewValue(row.GetField(models.HeadersColumn)); value.Eq(StringValue{"x"}).Unwrap() {
+		t.Error("a map compared equal to a string")
+	}
+}
@@ -0,0 +1,225 @@
+package parser
+
+import (
+	"stream_combination/models"
+	"strings"
+)
+
+// scope The sources a query's expressions can refer to, by alias. Sources without a declared schema map to nil.
+type scope struct {
+	aliases []string
+	schemas map[string]*Schema
+}
+
+type checker struct {
+	schemas map[string]Schema
+	errors  SemanticErrors
+}
+
+// Check Resolve every field reference in a query against the declared schemas of its sources, and infer the
+// type of each expression, before anything is started. Fields of streams without a schema aren't checked.
+func Check(statement Statement, schemas map[string]Schema) error {
+	c := &checker{schemas: schemas}
+	switch s := statement.(type) {
+	case *SelectNode:
+		c.checkSelect(s)
+	case *CreateStreamAs:
+		c.checkSelect(s.Query)
+	case *Explain:
+		return Check(s.Statement, schemas)
+	}
+	if len(c.errors) > 0 {
+		return c.errors
+	}
+	return nil
+}
+
+func (c *checker) checkSelect(sel *SelectNode) {
+	sc := &scope{schemas: make(map[string]*Schema)}
+	c.addSources(sc, sel.Source)
+	c.checkNode(sc, sel.Source)
+	for _, column := range sel.Fields {
+		if column.Field == "*" {
+			continue
+		}
+		c.resolve(sc, column.Source, column.Field, column.Pos)
+	}
+}
+
+func (c *checker) addSources(sc *scope, node Node) {
+	switch n := node.(type) {
+	case *Source:
+		c.addSources(sc, *n)
+	case Source:
+		alias := n.StreamName
+		if n.Alias != nil {
+			alias = *n.Alias
+		}
+		sc.aliases = append(sc.aliases, alias)
+		if schema, ok := c.schemas[n.StreamName]; ok {
+			sc.schemas[alias] = &schema
+		} else {
+			sc.schemas[alias] = nil
+		}
+	case WhereNode:
+		c.addSources(sc, n.Source)
+	case JoinWindow:
+		c.addSources(sc, n.LHS)
+		c.addSources(sc, n.RHS)
+	}
+}
+
+func (c *checker) checkNode(sc *scope, node Node) {
+	switch n := node.(type) {
+	case WhereNode:
+		c.checkNode(sc, n.Source)
+		if t := c.typeOf(sc, n.Filter); !isBoolean(t) {
+			c.errors = append(c.errors, expressionPosition(n.Filter).errorf("WHERE expects a BOOLEAN condition, got %s", t))
+		}
+	case JoinWindow:
+		c.checkNode(sc, n.LHS)
+		c.checkNode(sc, n.RHS)
+		if t := c.typeOf(sc, n.On); !isBoolean(t) {
+			c.errors = append(c.errors, expressionPosition(n.On).errorf("JOIN ON expects a BOOLEAN condition, got %s", t))
+		}
+	}
+}
+
+func isBoolean(t Type) bool {
+	return t.Kind == TypeBoolean || t.Kind == TypeUnknown
+}
+
+// typeOf The type of an expression, reporting the type errors within it
+func (c *checker) typeOf(sc *scope, expr Evaluatable) Type {
+	switch e := expr.(type) {
+	case FieldReference:
+		return c.resolve(sc, e.Source, e.Field, e.Pos)
+	case Constant:
+		return typeOfValue(e.value)
+	case EQ:
+		left, right := c.typeOf(sc, e.LHS), c.typeOf(sc, e.RHS)
+		if !left.ComparableWith(right) {
+			c.errors = append(c.errors, expressionPosition(e).errorf("Cannot compare %s with %s", left, right))
+		}
+		return Type{Kind: TypeBoolean}
+	case And:
+		c.checkBooleanOperands(sc, "AND", e.LHS, e.RHS)
+		return Type{Kind: TypeBoolean}
+	case Or:
+		c.checkBooleanOperands(sc, "OR", e.LHS, e.RHS)
+		return Type{Kind: TypeBoolean}
+	case Negate:
+		c.checkBooleanOperands(sc, "NOT", e.Inner)
+		return Type{Kind: TypeBoolean}
+	default:
+		return Type{Kind: TypeUnknown}
+	}
+}
+
+func (c *checker) checkBooleanOperands(sc *scope, operator string, operands ...Evaluatable) {
+	for _, operand := range operands {
+		if t := c.typeOf(sc, operand); !isBoolean(t) {
+			c.errors = append(c.errors, expressionPosition(operand).errorf("%s expects BOOLEAN operands, got %s", operator, t))
+		}
+	}
+}
+
+// resolve The type of a field reference. `a.b` is field `b` of source `a` when `a` is a source alias,
+// and otherwise field `b` of the STRUCT column `a`.
+func (c *checker) resolve(sc *scope, source *string, field string, pos Position) Type {
+	if t, ok := pseudoColumnType(field); ok {
+		if source == nil {
+			return t
+		}
+		if _, isAlias := sc.schemas[*source]; isAlias {
+			return t
+		}
+	}
+	if source != nil {
+		if schema, ok := sc.schemas[*source]; ok {
+			if schema == nil {
+				return Type{Kind: TypeUnknown}
+			}
+			t, err := schema.Lookup(field)
+			if err != nil {
+				c.errors = append(c.errors, pos.errorf("%s", err))
+				return Type{Kind: TypeUnknown}
+			}
+			return t
+		}
+		field = *source + "." + field
+	}
+
+	column := strings.SplitN(field, ".", 2)[0]
+	var matches []string
+	untyped := false
+	for _, alias := range sc.aliases {
+		schema := sc.schemas[alias]
+		if schema == nil {
+			untyped = true
+			continue
+		}
+		if _, err := schema.Lookup(column); err == nil {
+			matches = append(matches, alias)
+		}
+	}
+	switch {
+	case len(matches) > 1:
+		c.errors = append(c.errors, pos.errorf("Column %s is ambiguous, it's in %s", column, strings.Join(matches, " and ")))
+	case len(matches) == 1:
+		t, err := sc.schemas[matches[0]].Lookup(field)
+		if err == nil {
+			return t
+		}
+		c.errors = append(c.errors, pos.errorf("%s", err))
+	case !untyped && source != nil:
+		c.errors = append(c.errors, pos.errorf("Unknown source or column %s", *source))
+	case !untyped:
+		c.errors = append(c.errors, pos.errorf("Unknown column %s", column))
+	}
+	return Type{Kind: TypeUnknown}
+}
+
+// pseudoColumnType The type of a pseudo-column read from a message's metadata, which every source has
+func pseudoColumnType(field string) (Type, bool) {
+	if !models.IsPseudoColumn(field) {
+		return Type{}, false
+	}
+	switch field {
+	case models.SequenceColumn, models.ConsumerSeqColumn, models.NumDeliveredColumn:
+		return Type{Kind: TypeBigInt}, true
+	case models.TimestampColumn:
+		return Type{Kind: TypeTimestamp}, true
+	case models.HeadersColumn:
+		return Type{Kind: TypeMap, Key: &Type{Kind: TypeString}, Element: &Type{Kind: TypeString}}, true
+	default:
+		return Type{Kind: TypeString}, true
+	}
+}
+
+// expressionPosition Where an expression starts, as far as its field references tell
+func expressionPosition(expr Evaluatable) Position {
+	switch e := expr.(type) {
+	case FieldReference:
+		return e.Pos
+	case EQ:
+		return firstPosition(e.LHS, e.RHS)
+	case And:
+		return firstPosition(e.LHS, e.RHS)
+	case Or:
+		return firstPosition(e.LHS, e.RHS)
+	case Negate:
+		return expressionPosition(e.Inner)
+	default:
+		return Position{}
+	}
+}
+
+func firstPosition(exprs ...Evaluatable) Position {
+	for _, expr := range exprs {
+		if pos := expressionPosition(expr); pos != (Position{}) {
+			return pos
+		}
+	}
+	return Position{}
+}
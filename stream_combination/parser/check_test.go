@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"errors"
+	"reflect"
+	"stream_combination/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	bigint, text := Type{Kind: TypeBigInt}, Type{Kind: TypeString}
+	schemas := map[string]Schema{
+		"users": {Stream: "users", Columns: []ColumnDefinition{
+			{Name: "id", Type: bigint},
+			{Name: "name", Type: text},
+			{Name: "active", Type: Type{Kind: TypeBoolean}},
+			{Name: "address", Type: Type{Kind: TypeStruct, Fields: []ColumnDefinition{{Name: "city", Type: text}}}},
+		}},
+		"orders": {Stream: "orders", Columns: []ColumnDefinition{
+			{Name: "id", Type: bigint},
+			{Name: "user_id", Type: bigint},
+			{Name: "created", Type: Type{Kind: TypeTimestamp}},
+		}},
+	}
+	u, o := "u", "o"
+	field := func(source *string, name string, column int) FieldReference {
+		return FieldReference{Source: source, Field: name, Pos: Position{Line: 1, Column: column}}
+	}
+	selectFrom := func(source Node, fields ...string) *SelectNode {
+		columns := make([]Column, len(fields))
+		for i, name := range fields {
+			columns[i] = Column{Field: name, Pos: Position{Line: 1, Column: 7}}
+			// As parsed, `a.b` is qualified by `a`, which is a source alias or a STRUCT column
+			if qualifier, name, ok := strings.Cut(name, "."); ok {
+				columns[i].Source, columns[i].Field = &qualifier, name
+			}
+		}
+		return &SelectNode{Source: source, Fields: columns}
+	}
+	where := func(filter Evaluatable) Node {
+		return WhereNode{Source: Source{StreamName: "users"}, Filter: filter}
+	}
+	join := func(on Evaluatable) Node {
+		return JoinWindow{LHS: Source{StreamName: "users", Alias: &u}, RHS: Source{StreamName: "orders", Alias: &o}, Within: time.Hour, On: on}
+	}
+	tests := []struct {
+		name      string
+		statement Statement
+		// errors The messages of the SemanticErrors, in order
+		errors []string
+	}{
+		{"declared columns", selectFrom(Source{StreamName: "users"}, "*", "id", "name", "address.city"), nil},
+		{"unknown column", selectFrom(Source{StreamName: "users"}, "email"), []string{"line 1:7 - Unknown column email"}},
+		{"unknown struct field", selectFrom(Source{StreamName: "users"}, "address.zip"), []string{"line 1:7 - address has no field zip"}},
+		{"field of a primitive", selectFrom(Source{StreamName: "users"}, "name.first"), []string{"line 1:7 - name is STRING, not a STRUCT"}},
+		{"pseudo-columns", selectFrom(Source{StreamName: "users"}, models.SubjectColumn, models.SequenceColumn, models.HeaderField("X-Tenant")), nil},
+		// Streams without a schema aren't checked
+		{"undeclared stream", selectFrom(Source{StreamName: "clicks"}, "anything"), nil},
+		{"comparable", selectFrom(where(EQ{field(nil, "id", 20), Constant{IntValue{1}}}), "id"), nil},
+		{"incomparable", selectFrom(where(EQ{field(nil, "name", 20), Constant{IntValue{1}}}), "id"),
+			[]string{"line 1:20 - Cannot compare STRING with BIGINT"}},
+		{"not a boolean", selectFrom(where(field(nil, "name", 20)), "id"),
+			[]string{"line 1:20 - WHERE expects a BOOLEAN condition, got STRING"}},
+		{"boolean column", selectFrom(where(Negate{field(nil, "active", 24)}), "id"), nil},
+		{"operand of AND", selectFrom(where(And{field(nil, "active", 20), field(nil, "id", 31)}), "id"),
+			[]string{"line 1:31 - AND expects BOOLEAN operands, got BIGINT"}},
+		{"join keys", selectFrom(join(EQ{field(&u, "id", 40), field(&o, "user_id", 47)}), "u.name", "o.created"), nil},
+		{"ambiguous column", selectFrom(join(EQ{field(nil, "id", 40), field(&o, "user_id", 45)}), "name"),
+			[]string{"line 1:40 - Column id is ambiguous, it's in u and o"}},
+		{"column of the wrong source", selectFrom(join(EQ{field(&u, "user_id", 40), field(&o, "user_id", 50)}), "name"),
+			[]string{"line 1:40 - stream users has no column user_id"}},
+		{"timestamp and string", &CreateStreamAs{Name: "recent", Query: selectFrom(join(EQ{field(&o, "created", 40), Constant{StringValue{"2024-01-01"}}}), "u.name")}, nil},
+		{"explained", &Explain{Statement: selectFrom(Source{StreamName: "orders"}, "email")}, []string{"line 1:7 - Unknown column email"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Check(test.statement, schemas)
+			var messages []string
+			var errs SemanticErrors
+			if errors.As(err, &errs) {
+				for _, err := range errs {
+					messages = append(messages, err.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("error %v, expected SemanticErrors", err)
+			}
+			if !reflect.DeepEqual(messages, test.errors) {
+				t.Errorf("errors %q, expected %q", messages, test.errors)
+			}
+		})
+	}
+}
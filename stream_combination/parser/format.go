@@ -0,0 +1,253 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format Render a parsed query back into canonical NSQL: upper-case keywords, one clause per line.
+func Format(node Node) string {
+	var sb strings.Builder
+	formatNode(&sb, node)
+	return sb.String()
+}
+
+// FormatStatement Render any statement in canonical NSQL.
+func FormatStatement(statement Statement) string {
+	switch s := statement.(type) {
+	case *SelectNode:
+		return Format(s)
+	case *CreateStreamAs:
+		return fmt.Sprintf("CREATE STREAM %s%s AS\n%s\nEMIT CHANGES", s.Name, formatWith(s.Properties), Format(s.Query))
+	case *DeclareStream:
+		return formatDeclareStream(s)
+	case *ShowQueries:
+		return "SHOW QUERIES"
+	case *ShowStreams:
+		return "SHOW STREAMS"
+	case *ShowTables:
+		return "SHOW TABLES"
+	case *Describe:
+		if s.Extended {
+			return "DESCRIBE EXTENDED " + s.Stream
+		}
+		return "DESCRIBE " + s.Stream
+	case *InferSchema:
+		formatted := "INFER SCHEMA " + s.Stream
+		if s.Limit > 0 {
+			formatted += fmt.Sprintf(" LIMIT %d", s.Limit)
+		}
+		if s.Save {
+			formatted += " SAVE"
+		}
+		return formatted
+	case *TerminateQuery:
+		return "TERMINATE " + s.ID
+	case *Explain:
+		if s.Graphviz {
+			return "EXPLAIN GRAPHVIZ " + FormatStatement(s.Statement)
+		}
+		return "EXPLAIN " + FormatStatement(s.Statement)
+	default:
+		return fmt.Sprintf("/* unknown statement %T */", statement)
+	}
+}
+
+func formatDeclareStream(declare *DeclareStream) string {
+	columns := make([]string, len(declare.Columns))
+	for i, column := range declare.Columns {
+		columns[i] = fmt.Sprintf("  %s %s", column.Name, column.Type)
+	}
+	return fmt.Sprintf("CREATE STREAM %s (\n%s\n)%s", declare.Name, strings.Join(columns, ",\n"), formatWith(declare.Properties))
+}
+
+// formatWith A `WITH (...)` clause, preceded by a space, or nothing when there are no properties
+func formatWith(properties map[string]string) string {
+	if len(properties) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	formatted := make([]string, len(names))
+	for i, name := range names {
+		formatted[i] = fmt.Sprintf("%s='%s'", name, properties[name])
+	}
+	return fmt.Sprintf(" WITH (%s)", strings.Join(formatted, ", "))
+}
+
+func formatNode(sb *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *SelectNode:
+		formatSelect(sb, *n)
+	case SelectNode:
+		formatSelect(sb, n)
+	default:
+		sb.WriteString(formatSource(node))
+	}
+}
+
+func formatSelect(sb *strings.Builder, sel SelectNode) {
+	columns := make([]string, len(sel.Fields))
+	for i, column := range sel.Fields {
+		columns[i] = formatColumn(column)
+	}
+	sb.WriteString("SELECT ")
+	sb.WriteString(strings.Join(columns, ", "))
+
+	source := sel.Source
+	var where *WhereNode
+	if w, ok := source.(WhereNode); ok {
+		where = &w
+		source = w.Source
+	}
+	sb.WriteString("\nFROM ")
+	sb.WriteString(formatSource(source))
+	if where != nil {
+		sb.WriteString("\nWHERE ")
+		sb.WriteString(FormatExpression(where.Filter))
+	}
+	if sel.Parallelism > 0 {
+		fmt.Fprintf(sb, "\nPARALLELISM %d", sel.Parallelism)
+	}
+}
+
+func formatColumn(column Column) string {
+	text := column.Name()
+	if column.Alias != nil {
+		text += " AS " + *column.Alias
+	}
+	return text
+}
+
+func formatSubjects(subjects []string) string {
+	quoted := make([]string, len(subjects))
+	for i, subject := range subjects {
+		quoted[i] = "'" + subject + "'"
+	}
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+	return "(" + strings.Join(quoted, ", ") + ")"
+}
+
+func formatSource(node Node) string {
+	switch n := node.(type) {
+	case *Source:
+		return formatSource(*n)
+	case Source:
+		name := n.StreamName
+		if len(n.Subjects) > 0 {
+			name = formatSubjects(n.Subjects)
+		}
+		if n.Alias != nil {
+			return name + " AS " + *n.Alias + formatWith(n.Properties)
+		}
+		return name + formatWith(n.Properties)
+	case JoinWindow:
+		return fmt.Sprintf("%s\n  INNER JOIN %s WITHIN %s ON %s",
+			formatSource(n.LHS), formatSource(n.RHS), formatDuration(n.Within), FormatExpression(n.On))
+	default:
+		return fmt.Sprintf("/* unknown source %T */", node)
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	units := []struct {
+		size time.Duration
+		name string
+	}{
+		{24 * time.Hour, "DAY"},
+		{time.Hour, "HOUR"},
+		{time.Minute, "MINUTE"},
+		{time.Second, "SECOND"},
+	}
+	for _, unit := range units {
+		if d >= unit.size && d%unit.size == 0 {
+			count := int64(d / unit.size)
+			if count == 1 {
+				return "1 " + unit.name
+			}
+			return fmt.Sprintf("%d %sS", count, unit.name)
+		}
+	}
+	return fmt.Sprintf("%d SECONDS", int64(d/time.Second))
+}
+
+// FormatExpression Render an expression, adding parentheses only where precedence requires them.
+func FormatExpression(expr Evaluatable) string {
+	switch e := expr.(type) {
+	case FieldReference:
+		if e.Source != nil {
+			return *e.Source + "." + e.Field
+		}
+		return e.Field
+	case Constant:
+		return formatValue(e.value)
+	case EQ:
+		return FormatExpression(e.LHS) + " = " + FormatExpression(e.RHS)
+	case Negate:
+		if eq, ok := e.Inner.(EQ); ok {
+			return FormatExpression(eq.LHS) + " != " + FormatExpression(eq.RHS)
+		}
+		return "NOT " + formatOperand(e.Inner, precedenceNot)
+	case And:
+		return formatOperand(e.LHS, precedenceAnd) + " AND " + formatOperand(e.RHS, precedenceAnd)
+	case Or:
+		return formatOperand(e.LHS, precedenceOr) + " OR " + formatOperand(e.RHS, precedenceOr)
+	default:
+		return fmt.Sprintf("/* unknown expression %T */", expr)
+	}
+}
+
+const (
+	precedenceOr = iota
+	precedenceAnd
+	precedenceNot
+	precedenceComparison
+)
+
+func precedence(expr Evaluatable) int {
+	switch e := expr.(type) {
+	case Or:
+		return precedenceOr
+	case And:
+		return precedenceAnd
+	case Negate:
+		if _, ok := e.Inner.(EQ); ok {
+			return precedenceComparison
+		}
+		return precedenceNot
+	default:
+		return precedenceComparison
+	}
+}
+
+func formatOperand(expr Evaluatable, parent int) string {
+	if precedence(expr) < parent {
+		return "(" + FormatExpression(expr) + ")"
+	}
+	return FormatExpression(expr)
+}
+
+func formatValue(value Value) string {
+	switch v := value.(type) {
+	case IntValue:
+		return strconv.FormatInt(v.val, 10)
+	case FloatValue:
+		return strconv.FormatFloat(v.val, 'f', -1, 64)
+	case StringValue:
+		return "'" + v.val + "'"
+	case BooleanValue:
+		return strings.ToUpper(strconv.FormatBool(v.val))
+	case NullValue:
+		return "NULL"
+	default:
+		return fmt.Sprintf("/* unknown value %T */", value)
+	}
+}
@@ -74,7 +74,19 @@ func (O Or) Compile(ctx *processor.ProcessorBuilder) func(models.EventLike) Valu
 	leftFn := O.LHS.Compile(ctx)
 	rightFn := O.RHS.Compile(ctx)
 	return func(event models.EventLike) Value {
-		return leftFn(event).(BooleanValue).Or(rightFn(event).(BooleanValue))
+		return truth(leftFn(event)).Or(truth(rightFn(event)))
+	}
+}
+
+func (A And) Visit(ctx *processor.ProcessorBuilder) interface{} {
+	return A.Compile(ctx)
+}
+
+func (A And) Compile(ctx *processor.ProcessorBuilder) func(models.EventLike) Value {
+	leftFn := A.LHS.Compile(ctx)
+	rightFn := A.RHS.Compile(ctx)
+	return func(event models.EventLike) Value {
+		return truth(leftFn(event)).And(truth(rightFn(event)))
 	}
 }
 
@@ -83,6 +95,12 @@ type Negate struct {
 }
 
 func (N Negate) Visit(ctx *processor.ProcessorBuilder) interface{} {
-	// TODO
-	panic("Implement me!")
+	return N.Compile(ctx)
+}
+
+func (N Negate) Compile(ctx *processor.ProcessorBuilder) func(models.EventLike) Value {
+	innerFn := N.Inner.Compile(ctx)
+	return func(event models.EventLike) Value {
+		return truth(innerFn(event)).Not()
+	}
 }
@@ -0,0 +1,34 @@
+package parser
+
+import (
+	"stream_combination/models"
+	"testing"
+	"time"
+)
+
+func TestLogicalOperators(t *testing.T) {
+	a := func(n int) Evaluatable { return EQ{FieldReference{Field: "a"}, Constant{NewValue(n)}} }
+	b := func(n int) Evaluatable { return EQ{FieldReference{Field: "b"}, Constant{NewValue(n)}} }
+	event := models.NewEvent(time.Now(), map[string]interface{}{"a": 1, "b": 2})
+	tests := []struct {
+		name     string
+		expr     Evaluatable
+		expected bool
+	}{
+		{"and, both true", And{a(1), b(2)}, true},
+		{"and, one false", And{a(1), b(3)}, false},
+		{"or, one true", Or{a(0), b(2)}, true},
+		{"or, both false", Or{a(0), b(0)}, false},
+		{"not true", Negate{a(1)}, false},
+		{"not false", Negate{a(0)}, true},
+		{"not of and", Negate{And{a(1), b(3)}}, true},
+		{"or of and", Or{And{a(1), b(3)}, Negate{b(3)}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := toBoolFunc(test.expr.Compile(nil))(event); got != test.expected {
+				t.Errorf("%s = %v, expected %v", FormatExpression(test.expr), got, test.expected)
+			}
+		})
+	}
+}
@@ -1,7 +1,13 @@
+//go:generate java -jar ../../data/antlr-4.13.2-complete.jar -Dlanguage=Go -visitor -package parser NSQL.g4
+
 package parser
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"stream_combination/processor"
+	"strings"
 
 	"github.com/antlr4-go/antlr/v4"
 )
@@ -18,7 +24,22 @@ func (el *ErrorListener) SyntaxError(recognizer antlr.Recognizer, offendingSymbo
 	el.Errors = append(el.Errors, errorMsg)
 }
 
-func ParseSQL(input string) (Node, error) {
+// Apply Add a query's processors to a builder, reporting failures as errors.
+func Apply(node Node, builder *processor.ProcessorBuilder) (err error) {
+	defer func() {
+		// Compiling expressions still panics on unsupported operations
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid query: %v", r)
+		}
+	}()
+	if err, ok := node.Visit(builder).(error); ok {
+		return err
+	}
+	return nil
+}
+
+// ParseStatement Parse any NSQL statement, e.g. a query, `CREATE STREAM ... AS` or `SHOW QUERIES`.
+func ParseStatement(input string) (Statement, error) {
 	inputStream := antlr.NewInputStream(input)
 	lexer := NewNSQLLexer(inputStream)
 	tokenStream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
@@ -34,7 +55,10 @@ func ParseSQL(input string) (Node, error) {
 
 	tree := parser.Query()
 
-	fmt.Printf("Tree string representation:\n%s\n", tree.ToStringTree(nil, parser))
+	// The tree is only rendered when it's logged
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		slog.Debug("Parsed query", "tree", tree.ToStringTree(nil, parser))
+	}
 	if len(errorListener.Errors) > 0 {
 		return nil, fmt.Errorf("parse errors: %v", errorListener.Errors)
 	}
@@ -43,16 +67,60 @@ func ParseSQL(input string) (Node, error) {
 	result := tree.Accept(builder)
 
 	if builder.HasErrors() {
-		fmt.Println("Semantic errors found:")
-		for _, err := range builder.GetErrors() {
-			fmt.Printf("  Line %d, Column %d: %s\n", err.Line, err.Column, err.Message)
+		return nil, SemanticErrors(builder.GetErrors())
+	}
+
+	if statement, ok := result.(Statement); ok {
+		return statement, nil
+	} else {
+		return nil, fmt.Errorf("expected Statement, got %T", result)
+	}
+}
+
+// SplitStatements Split a script into its statements at each `;` outside of string literals, dropping empty ones.
+// Text the lexer doesn't recognise is kept, to be reported when its statement is parsed.
+func SplitStatements(input string) []string {
+	lexer := NewNSQLLexer(antlr.NewInputStream(input))
+	lexer.RemoveErrorListeners()
+	// The lexer's positions count runes, not bytes
+	runes := []rune(input)
+	var statements []string
+	add := func(text string) {
+		if text = strings.TrimSpace(text); text != "" {
+			statements = append(statements, text)
+		}
+	}
+	start := 0
+	for token := lexer.NextToken(); token.GetTokenType() != antlr.TokenEOF; token = lexer.NextToken() {
+		if token.GetTokenType() == NSQLLexerSEMICOLON {
+			add(string(runes[start:token.GetStart()]))
+			start = token.GetStop() + 1
 		}
-		return nil, fmt.Errorf("found %d semantic errors", len(builder.GetErrors()))
 	}
+	add(string(runes[start:]))
+	return statements
+}
 
-	if selectNode, ok := result.(*SelectNode); ok {
+// ParseSQL Parse a query, rejecting other kinds of statement.
+func ParseSQL(input string) (Node, error) {
+	statement, err := ParseStatement(input)
+	if err != nil {
+		return nil, err
+	}
+	if selectNode, ok := statement.(*SelectNode); ok {
 		return selectNode, nil
 	} else {
-		return nil, fmt.Errorf("expected SelectNode, got %T", result)
+		return nil, fmt.Errorf("expected SelectNode, got %T", statement)
+	}
+}
+
+// SemanticErrors All semantic errors found while building the AST
+type SemanticErrors []SemanticError
+
+func (errs SemanticErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
 	}
+	return fmt.Sprintf("found %d semantic errors:\n%s", len(errs), strings.Join(messages, "\n"))
 }
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"one statement", "CREATE STREAM a (id STRING);", []string{"CREATE STREAM a (id STRING)"}},
+		{"without a final semicolon", "SELECT id FROM a", []string{"SELECT id FROM a"}},
+		{
+			"several statements",
+			"CREATE STREAM a (id STRING);\nCREATE STREAM b (id STRING);\n",
+			[]string{"CREATE STREAM a (id STRING)", "CREATE STREAM b (id STRING)"},
+		},
+		{
+			"semicolon in a string",
+			"CREATE STREAM a (id STRING) WITH (DEAD_LETTER_SUBJECT='a;b'); SELECT id FROM a;",
+			[]string{"CREATE STREAM a (id STRING) WITH (DEAD_LETTER_SUBJECT='a;b')", "SELECT id FROM a"},
+		},
+		{"empty statements", " ; ;\n", nil},
+		{"multibyte text before a semicolon", "SELECT id FROM a WHERE name = 'café'; SELECT id FROM b", []string{"SELECT id FROM a WHERE name = 'café'", "SELECT id FROM b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if statements := SplitStatements(test.input); !reflect.DeepEqual(statements, test.expected) {
+				t.Errorf("SplitStatements(%q) = %q, expected %q", test.input, statements, test.expected)
+			}
+		})
+	}
+}
+
+// parenthesized An expression with every operation in parentheses, to show how it was grouped
+func parenthesized(expr Evaluatable) string {
+	switch e := expr.(type) {
+	case EQ:
+		return "(" + parenthesized(e.LHS) + " = " + parenthesized(e.RHS) + ")"
+	case And:
+		return "(" + parenthesized(e.LHS) + " AND " + parenthesized(e.RHS) + ")"
+	case Or:
+		return "(" + parenthesized(e.LHS) + " OR " + parenthesized(e.RHS) + ")"
+	case Negate:
+		return "(NOT " + parenthesized(e.Inner) + ")"
+	default:
+		return FormatExpression(expr)
+	}
+}
+
+// TestExpressionPrecedence Comparisons bind tightest, then NOT, AND and OR, and operators of the same precedence
+// group from the left
+func TestExpressionPrecedence(t *testing.T) {
+	tests := []struct {
+		where    string
+		expected string
+	}{
+		{"a = 1 OR b = 2 AND c = 3", "((a = 1) OR ((b = 2) AND (c = 3)))"},
+		{"a = 1 AND b = 2 OR c = 3", "(((a = 1) AND (b = 2)) OR (c = 3))"},
+		{"(a = 1 OR b = 2) AND c = 3", "(((a = 1) OR (b = 2)) AND (c = 3))"},
+		{"a = 1 AND b = 2 AND c = 3", "(((a = 1) AND (b = 2)) AND (c = 3))"},
+		{"a = 1 OR b = 2 OR c = 3", "(((a = 1) OR (b = 2)) OR (c = 3))"},
+		{"NOT a = 1 AND b = 2", "((NOT (a = 1)) AND (b = 2))"},
+		{"NOT a = 1 OR b = 2", "((NOT (a = 1)) OR (b = 2))"},
+		{"NOT (a = 1 OR b = 2)", "(NOT ((a = 1) OR (b = 2)))"},
+		{"a != 1 AND b = 2", "((NOT (a = 1)) AND (b = 2))"},
+		{"a = 1 AND NOT b = 2 OR c = 3", "(((a = 1) AND (NOT (b = 2))) OR (c = 3))"},
+	}
+	for _, test := range tests {
+		t.Run(test.where, func(t *testing.T) {
+			statement, err := ParseStatement("SELECT * FROM s WHERE " + test.where)
+			if err != nil {
+				t.Fatal(err)
+			}
+			where, ok := statement.(*SelectNode).Source.(WhereNode)
+			if !ok {
+				t.Fatalf("expected a WHERE, got %T", statement.(*SelectNode).Source)
+			}
+			if got := parenthesized(where.Filter); got != test.expected {
+				t.Errorf("parsed as %s, expected %s", got, test.expected)
+			}
+		})
+	}
+}
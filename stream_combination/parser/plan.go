@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PlanNode A step of the logical plan: what a query does, before it's mapped onto processors
+type PlanNode struct {
+	Operator string // Source, Filter, Join, Project or Sink
+	Detail   string
+	Inputs   []*PlanNode
+}
+
+// LogicalPlan The plan of a query or `CREATE STREAM ... AS`, from its sink back to its sources
+func LogicalPlan(statement Statement) (*PlanNode, error) {
+	switch s := statement.(type) {
+	case *SelectNode:
+		return &PlanNode{Operator: "Sink", Detail: "query results", Inputs: []*PlanNode{planSelect(s)}}, nil
+	case *CreateStreamAs:
+		return &PlanNode{Operator: "Sink", Detail: "stream " + s.Name, Inputs: []*PlanNode{planSelect(s.Query)}}, nil
+	default:
+		return nil, fmt.Errorf("%T has no query plan", statement)
+	}
+}
+
+func planSelect(sel *SelectNode) *PlanNode {
+	columns := make([]string, len(sel.Fields))
+	for i, column := range sel.Fields {
+		columns[i] = formatColumn(column)
+	}
+	return &PlanNode{Operator: "Project", Detail: strings.Join(columns, ", "), Inputs: []*PlanNode{planSource(sel.Source)}}
+}
+
+func planSource(node Node) *PlanNode {
+	switch n := node.(type) {
+	case *Source:
+		return planSource(*n)
+	case Source:
+		detail := formatSource(n)
+		if len(n.Subjects) > 0 && n.StreamName != "" {
+			detail += " (stream " + n.StreamName + ")"
+		}
+		return &PlanNode{Operator: "Source", Detail: detail}
+	case WhereNode:
+		return &PlanNode{Operator: "Filter", Detail: FormatExpression(n.Filter), Inputs: []*PlanNode{planSource(n.Source)}}
+	case JoinWindow:
+		return &PlanNode{
+			Operator: "Join",
+			Detail:   fmt.Sprintf("INNER WITHIN %s ON %s", formatDuration(n.Within), FormatExpression(n.On)),
+			Inputs:   []*PlanNode{planSource(n.LHS), planSource(n.RHS)},
+		}
+	default:
+		return &PlanNode{Operator: "Unknown", Detail: fmt.Sprintf("%T", node)}
+	}
+}
+
+// String The plan as an indented tree, with each step above its inputs
+func (p *PlanNode) String() string {
+	var sb strings.Builder
+	p.write(&sb, 0)
+	return sb.String()
+}
+
+func (p *PlanNode) write(sb *strings.Builder, depth int) {
+	fmt.Fprintf(sb, "%s%s: %s\n", strings.Repeat("  ", depth), p.Operator, p.Detail)
+	for _, input := range p.Inputs {
+		input.write(sb, depth+1)
+	}
+}
@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogicalPlan(t *testing.T) {
+	u, p, total := "u", "p", "total"
+	join := JoinWindow{
+		LHS:    Source{StreamName: "users", Alias: &u},
+		RHS:    Source{StreamName: "purchases", Alias: &p, Subjects: []string{"purchases.eu"}, Properties: map[string]string{"FORMAT": "msgpack"}},
+		Within: time.Hour,
+		On:     EQ{FieldReference{Source: &u, Field: "id"}, FieldReference{Source: &p, Field: "user_id"}},
+	}
+	query := &SelectNode{
+		Source: WhereNode{Source: join, Filter: Negate{EQ{FieldReference{Source: &p, Field: "amount"}, Constant{IntValue{0}}}}},
+		Fields: []Column{{Source: &u, Field: "name"}, {Source: &p, Field: "amount", Alias: &total}},
+	}
+	project := "Project: u.name, p.amount AS total\n" +
+		"    Filter: p.amount != 0\n" +
+		"      Join: INNER WITHIN 1 HOUR ON u.id = p.user_id\n" +
+		"        Source: users AS u\n" +
+		"        Source: 'purchases.eu' AS p WITH (FORMAT='msgpack') (stream purchases)\n"
+	tests := []struct {
+		name      string
+		statement Statement
+		expected  string
+	}{
+		{"query", query, "Sink: query results\n  " + project},
+		{"create stream", &CreateStreamAs{Name: "big_purchases", Query: query}, "Sink: stream big_purchases\n  " + project},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := LogicalPlan(test.statement)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := plan.String(); got != test.expected {
+				t.Errorf("plan\n%s\nexpected\n%s", got, test.expected)
+			}
+		})
+	}
+
+	if _, err := LogicalPlan(&ShowQueries{}); err == nil {
+		t.Error("expected SHOW QUERIES to have no plan")
+	}
+}
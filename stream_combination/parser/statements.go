@@ -0,0 +1,169 @@
+package parser
+
+// Statement Anything ParseStatement can return. Queries are also Nodes; the others are run by the engine.
+type Statement interface {
+	statement()
+}
+
+func (sel *SelectNode) statement() {}
+
+// CreateStreamAs `CREATE STREAM name AS SELECT ...`, a persistent query writing to a new stream
+type CreateStreamAs struct {
+	Name       string
+	Query      *SelectNode
+	Properties map[string]string // From `WITH (...)`, e.g. the payload FORMAT of the new stream
+}
+
+func (C *CreateStreamAs) statement() {}
+
+// DeclareStream `CREATE STREAM name (column TYPE, ...) WITH (...)`, declaring the schema of an existing stream
+type DeclareStream struct {
+	Name       string
+	Columns    []ColumnDefinition
+	Properties map[string]string
+}
+
+func (D *DeclareStream) statement() {}
+
+func (D *DeclareStream) Schema() Schema {
+	return Schema{Stream: D.Name, Columns: D.Columns, Properties: D.Properties}
+}
+
+// ShowQueries `SHOW QUERIES`
+type ShowQueries struct{}
+
+func (S *ShowQueries) statement() {}
+
+// ShowStreams `SHOW STREAMS`
+type ShowStreams struct{}
+
+func (S *ShowStreams) statement() {}
+
+// ShowTables `SHOW TABLES`, listing KV buckets
+type ShowTables struct{}
+
+func (S *ShowTables) statement() {}
+
+// Describe `DESCRIBE [EXTENDED] stream`
+type Describe struct {
+	Stream   string
+	Extended bool
+}
+
+func (D *Describe) statement() {}
+
+// InferSchema `INFER SCHEMA stream [LIMIT n] [SAVE]`, sampling the newest messages of a stream.
+// A zero Limit samples the default number of messages.
+type InferSchema struct {
+	Stream string
+	Limit  int
+	Save   bool
+}
+
+func (I *InferSchema) statement() {}
+
+// Explain `EXPLAIN [GRAPHVIZ] query`, showing the logical plan and processor DAG of a query
+type Explain struct {
+	Statement Statement
+	Graphviz  bool
+}
+
+func (E *Explain) statement() {}
+
+// TerminateQuery `TERMINATE id`
+type TerminateQuery struct {
+	ID string
+}
+
+func (T *TerminateQuery) statement() {}
+
+// Sources The names of the streams a query reads from
+func Sources(statement Statement) []string {
+	switch s := statement.(type) {
+	case *CreateStreamAs:
+		return Sources(s.Query)
+	case *SelectNode:
+		return nodeSources(s.Source)
+	default:
+		return nil
+	}
+}
+
+func nodeSources(node Node) []string {
+	switch n := node.(type) {
+	case *Source:
+		return []string{n.StreamName}
+	case Source:
+		return []string{n.StreamName}
+	case WhereNode:
+		return nodeSources(n.Source)
+	case JoinWindow:
+		return append(nodeSources(n.LHS), nodeSources(n.RHS)...)
+	default:
+		return nil
+	}
+}
+
+// ApplyCatalogProperties Give sources without a `WITH (...)` clause the properties of their declared schema,
+// so e.g. the FORMAT of a stream only needs declaring once.
+func ApplyCatalogProperties(statement Statement, schemas map[string]Schema) {
+	var query *SelectNode
+	switch s := statement.(type) {
+	case *SelectNode:
+		query = s
+	case *CreateStreamAs:
+		query = s.Query
+	case *Explain:
+		ApplyCatalogProperties(s.Statement, schemas)
+		return
+	default:
+		return
+	}
+	for _, source := range sourceNodes(query.Source) {
+		if schema, ok := schemas[source.StreamName]; ok && source.Properties == nil {
+			source.Properties = schema.Properties
+		}
+	}
+}
+
+// ResolveSubjects Set the stream of each source that's given as subjects, using `resolve` to find the one
+// stream that stores them.
+func ResolveSubjects(statement Statement, resolve func(subjects []string) (string, error)) error {
+	switch s := statement.(type) {
+	case *SelectNode:
+		return resolveSubjects(s.Source, resolve)
+	case *CreateStreamAs:
+		return resolveSubjects(s.Query.Source, resolve)
+	case *Explain:
+		return ResolveSubjects(s.Statement, resolve)
+	default:
+		return nil
+	}
+}
+
+func resolveSubjects(node Node, resolve func(subjects []string) (string, error)) error {
+	for _, source := range sourceNodes(node) {
+		if len(source.Subjects) == 0 || source.StreamName != "" {
+			continue
+		}
+		stream, err := resolve(source.Subjects)
+		if err != nil {
+			return err
+		}
+		source.StreamName = stream
+	}
+	return nil
+}
+
+func sourceNodes(node Node) []*Source {
+	switch n := node.(type) {
+	case *Source:
+		return []*Source{n}
+	case WhereNode:
+		return sourceNodes(n.Source)
+	case JoinWindow:
+		return append(sourceNodes(n.LHS), sourceNodes(n.RHS)...)
+	default:
+		return nil
+	}
+}
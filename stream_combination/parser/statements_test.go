@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveSubjects(t *testing.T) {
+	streams := map[string]string{"orders.*.created": "ORDERS", "payments.>": "PAYMENTS"}
+	var asked [][]string
+	resolve := func(subjects []string) (string, error) {
+		asked = append(asked, subjects)
+		stream, ok := streams[subjects[0]]
+		if !ok {
+			return "", errors.New("no stream stores subject " + subjects[0])
+		}
+		return stream, nil
+	}
+	orders := &Source{Subjects: []string{"orders.*.created"}}
+	payments := &Source{Subjects: []string{"payments.>"}}
+	// Named streams aren't looked up, even when they filter subjects
+	users := &Source{StreamName: "users", Subjects: []string{"users.eu"}}
+	join := JoinWindow{LHS: orders, RHS: WhereNode{Source: payments, Filter: Constant{BooleanValue{true}}}, Within: time.Minute}
+	statement := &Explain{Statement: &CreateStreamAs{Name: "paid", Query: &SelectNode{Source: JoinWindow{LHS: join, RHS: users}}}}
+
+	if err := ResolveSubjects(statement, resolve); err != nil {
+		t.Fatal(err)
+	}
+	if orders.StreamName != "ORDERS" || payments.StreamName != "PAYMENTS" || users.StreamName != "users" {
+		t.Errorf("resolved to %s, %s and %s", orders.StreamName, payments.StreamName, users.StreamName)
+	}
+	if expected := [][]string{{"orders.*.created"}, {"payments.>"}}; !reflect.DeepEqual(asked, expected) {
+		t.Errorf("looked up %v, expected %v", asked, expected)
+	}
+
+	unknown := &SelectNode{Source: &Source{Subjects: []string{"refunds.>"}}}
+	if err := ResolveSubjects(unknown, resolve); err == nil || !strings.Contains(err.Error(), "refunds.>") {
+		t.Errorf("error %v, expected the subject without a stream", err)
+	}
+	if err := ResolveSubjects(&ShowQueries{}, resolve); err != nil {
+		t.Errorf("statement without sources failed with %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TypeKind The SQL type of a column or expression
+type TypeKind string
+
+const (
+	// TypeUnknown Untyped, e.g. a field of a stream without a declared schema. It's compatible with every type.
+	TypeUnknown   TypeKind = ""
+	TypeBoolean   TypeKind = "BOOLEAN"
+	TypeInt       TypeKind = "INT"
+	TypeBigInt    TypeKind = "BIGINT"
+	TypeDouble    TypeKind = "DOUBLE"
+	TypeString    TypeKind = "STRING"
+	TypeTimestamp TypeKind = "TIMESTAMP"
+	TypeArray     TypeKind = "ARRAY"
+	TypeMap       TypeKind = "MAP"
+	TypeStruct    TypeKind = "STRUCT"
+)
+
+// primitiveTypes Names accepted for primitive types in a column definition, including common synonyms
+var primitiveTypes = map[string]TypeKind{
+	"BOOLEAN":   TypeBoolean,
+	"BOOL":      TypeBoolean,
+	"INT":       TypeInt,
+	"INTEGER":   TypeInt,
+	"BIGINT":    TypeBigInt,
+	"DOUBLE":    TypeDouble,
+	"FLOAT":     TypeDouble,
+	"STRING":    TypeString,
+	"VARCHAR":   TypeString,
+	"TIMESTAMP": TypeTimestamp,
+}
+
+// Type A column or expression type. ARRAY has an Element, MAP a Key and Element, and STRUCT its Fields.
+type Type struct {
+	Kind    TypeKind           `json:"kind"`
+	Key     *Type              `json:"key,omitempty"`
+	Element *Type              `json:"element,omitempty"`
+	Fields  []ColumnDefinition `json:"fields,omitempty"`
+}
+
+// ColumnDefinition A named, typed column of a stream, or field of a STRUCT
+type ColumnDefinition struct {
+	Name string `json:"name"`
+	Type Type   `json:"type"`
+}
+
+// Schema The declared columns of a stream, and the properties from its `WITH (...)` clause
+type Schema struct {
+	Stream     string             `json:"stream"`
+	Columns    []ColumnDefinition `json:"columns"`
+	Properties map[string]string  `json:"properties,omitempty"`
+}
+
+func (t Type) String() string {
+	switch t.Kind {
+	case TypeUnknown:
+		return "UNKNOWN"
+	case TypeArray:
+		return fmt.Sprintf("ARRAY<%s>", t.Element)
+	case TypeMap:
+		return fmt.Sprintf("MAP<%s, %s>", t.Key, t.Element)
+	case TypeStruct:
+		fields := make([]string, len(t.Fields))
+		for i, field := range t.Fields {
+			fields[i] = fmt.Sprintf("%s %s", field.Name, field.Type)
+		}
+		return fmt.Sprintf("STRUCT<%s>", strings.Join(fields, ", "))
+	default:
+		return string(t.Kind)
+	}
+}
+
+func (t Type) IsNumeric() bool {
+	return t.Kind == TypeInt || t.Kind == TypeBigInt || t.Kind == TypeDouble
+}
+
+// IsComparable Whether values of the type can be compared with `=`. Nested types can't, yet.
+func (t Type) IsComparable() bool {
+	return t.Kind != TypeArray && t.Kind != TypeMap && t.Kind != TypeStruct
+}
+
+// ComparableWith Whether `=` between the two types is well typed. Numbers compare with each other,
+// and timestamps with string literals.
+func (t Type) ComparableWith(other Type) bool {
+	if t.Kind == TypeUnknown || other.Kind == TypeUnknown {
+		return true
+	}
+	if !t.IsComparable() || !other.IsComparable() {
+		return false
+	}
+	switch {
+	case t.IsNumeric() && other.IsNumeric():
+		return true
+	case t.Kind == TypeTimestamp && other.Kind == TypeString, t.Kind == TypeString && other.Kind == TypeTimestamp:
+		return true
+	default:
+		return t.Kind == other.Kind
+	}
+}
+
+// Field The type of a STRUCT field
+func (t Type) Field(name string) (Type, bool) {
+	for _, field := range t.Fields {
+		if field.Name == name {
+			return field.Type, true
+		}
+	}
+	return Type{}, false
+}
+
+// Lookup The type of a column, or of a nested STRUCT field addressed with dots, e.g. `customer.address.city`
+func (s Schema) Lookup(path string) (Type, error) {
+	parts := strings.Split(path, ".")
+	current := Type{Kind: TypeStruct, Fields: s.Columns}
+	for i, part := range parts {
+		if current.Kind != TypeStruct {
+			return Type{}, fmt.Errorf("%s is %s, not a STRUCT", strings.Join(parts[:i], "."), current)
+		}
+		next, ok := current.Field(part)
+		if !ok {
+			if i == 0 {
+				return Type{}, fmt.Errorf("stream %s has no column %s", s.Stream, part)
+			}
+			return Type{}, fmt.Errorf("%s has no field %s", strings.Join(parts[:i], "."), part)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// typeOfValue The type of a constant in a query
+func typeOfValue(value Value) Type {
+	switch value.(type) {
+	case IntValue:
+		return Type{Kind: TypeBigInt}
+	case FloatValue:
+		return Type{Kind: TypeDouble}
+	case StringValue:
+		return Type{Kind: TypeString}
+	case BooleanValue:
+		return Type{Kind: TypeBoolean}
+	default:
+		return Type{Kind: TypeUnknown}
+	}
+}
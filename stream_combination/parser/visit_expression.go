@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"stream_combination/models"
 	"strings"
 )
 
@@ -63,6 +64,7 @@ func (v *ASTBuilderVisitor) VisitQualifiedIdentifierExpression(ctx *QualifiedIde
 		return FieldReference{
 			Source: &source,
 			Field:  field,
+			Pos:    positionOf(ctx),
 		}
 	}
 
@@ -70,11 +72,13 @@ func (v *ASTBuilderVisitor) VisitQualifiedIdentifierExpression(ctx *QualifiedIde
 	return FieldReference{
 		Source: nil,
 		Field:  text,
+		Pos:    positionOf(ctx),
 	}
 }
 
 func (v *ASTBuilderVisitor) VisitStringExpression(ctx *StringExpressionContext) interface{} {
-	return Constant{value: StringValue{val: ctx.GetText()}}
+	text := ctx.GetText()
+	return Constant{value: StringValue{val: text[1 : len(text)-1]}}
 }
 
 func (v *ASTBuilderVisitor) VisitNumberExpression(ctx *NumberExpressionContext) interface{} {
@@ -94,5 +98,46 @@ func (v *ASTBuilderVisitor) VisitNumberExpression(ctx *NumberExpressionContext)
 }
 
 func (v *ASTBuilderVisitor) VisitParenthesizedExpression(ctx *ParenthesizedExpressionContext) interface{} {
-	return v.VisitChildren(ctx)
+	return ctx.Expression().Accept(v)
+}
+
+// VisitSubscriptExpression `_headers['X-Tenant']`, the only column that can be subscripted
+func (v *ASTBuilderVisitor) VisitSubscriptExpression(ctx *SubscriptExpressionContext) interface{} {
+	source, field := splitColumnName(ctx.QualifiedIdentifier().GetText())
+	if field != models.HeadersColumn {
+		v.addError(ctx, fmt.Sprintf("Only %s can be subscripted, not %s", models.HeadersColumn, field))
+	}
+	key := strings.Trim(ctx.STRING().GetText(), "'")
+	return FieldReference{
+		Source: source,
+		Field:  models.HeaderField(key),
+		Pos:    positionOf(ctx),
+	}
+}
+
+// VisitFunctionCallExpression `_subject_token(n)`, the n-th token of the subject counting from 1
+func (v *ASTBuilderVisitor) VisitFunctionCallExpression(ctx *FunctionCallExpressionContext) interface{} {
+	source, name := splitColumnName(ctx.QualifiedIdentifier().GetText())
+	if strings.ToLower(name) != models.SubjectTokenColumn {
+		v.addError(ctx, fmt.Sprintf("Unknown function %s", name))
+		return Constant{NullValue{}}
+	}
+	var args []IExpressionContext
+	if ctx.ExpressionList() != nil {
+		args = ctx.ExpressionList().AllExpression()
+	}
+	if len(args) != 1 {
+		v.addError(ctx, fmt.Sprintf("%s expects 1 argument, got %d", models.SubjectTokenColumn, len(args)))
+		return Constant{NullValue{}}
+	}
+	n, err := strconv.Atoi(args[0].GetText())
+	if _, isNumber := args[0].(*NumberExpressionContext); !isNumber || err != nil || n < 1 {
+		v.addError(ctx, fmt.Sprintf("%s expects a token number from 1, got %s", models.SubjectTokenColumn, args[0].GetText()))
+		return Constant{NullValue{}}
+	}
+	return FieldReference{
+		Source: source,
+		Field:  models.SubjectTokenField(n),
+		Pos:    positionOf(ctx),
+	}
 }
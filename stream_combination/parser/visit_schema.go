@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (v *ASTBuilderVisitor) VisitDeclareStreamStatement(ctx *DeclareStreamStatementContext) interface{} {
+	declare := &DeclareStream{Name: ctx.IDENTIFIER().GetText()}
+	declare.Columns = v.columnDefinitions(ctx.AllColumnDefinition())
+	if ctx.WithClause() != nil {
+		declare.Properties = ctx.WithClause().Accept(v).(map[string]string)
+	}
+	return declare
+}
+
+// columnDefinitions The columns of a stream or fields of a STRUCT, which must have distinct names
+func (v *ASTBuilderVisitor) columnDefinitions(contexts []IColumnDefinitionContext) []ColumnDefinition {
+	columns := make([]ColumnDefinition, 0, len(contexts))
+	seen := make(map[string]bool)
+	for _, columnCtx := range contexts {
+		column := columnCtx.Accept(v).(ColumnDefinition)
+		if seen[column.Name] {
+			v.addError(columnCtx, fmt.Sprintf("Duplicate column %s", column.Name))
+			continue
+		}
+		seen[column.Name] = true
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+func (v *ASTBuilderVisitor) VisitColumnDefinition(ctx *ColumnDefinitionContext) interface{} {
+	return ColumnDefinition{
+		Name: ctx.IDENTIFIER().GetText(),
+		Type: ctx.DataType().Accept(v).(Type),
+	}
+}
+
+func (v *ASTBuilderVisitor) VisitArrayType(ctx *ArrayTypeContext) interface{} {
+	element := ctx.DataType().Accept(v).(Type)
+	return Type{Kind: TypeArray, Element: &element}
+}
+
+func (v *ASTBuilderVisitor) VisitMapType(ctx *MapTypeContext) interface{} {
+	key := ctx.DataType(0).Accept(v).(Type)
+	element := ctx.DataType(1).Accept(v).(Type)
+	if key.Kind != TypeString {
+		v.addError(ctx, fmt.Sprintf("MAP keys must be STRING, got %s", key))
+	}
+	return Type{Kind: TypeMap, Key: &key, Element: &element}
+}
+
+func (v *ASTBuilderVisitor) VisitStructType(ctx *StructTypeContext) interface{} {
+	return Type{Kind: TypeStruct, Fields: v.columnDefinitions(ctx.AllColumnDefinition())}
+}
+
+func (v *ASTBuilderVisitor) VisitPrimitiveType(ctx *PrimitiveTypeContext) interface{} {
+	name := strings.ToUpper(ctx.IDENTIFIER().GetText())
+	kind, ok := primitiveTypes[name]
+	if !ok {
+		v.addError(ctx, fmt.Sprintf("Unknown type %s", ctx.IDENTIFIER().GetText()))
+		return Type{Kind: TypeUnknown}
+	}
+	return Type{Kind: kind}
+}
+
+func (v *ASTBuilderVisitor) VisitWithClause(ctx *WithClauseContext) interface{} {
+	properties := make(map[string]string)
+	for _, propertyCtx := range ctx.AllProperty() {
+		property := propertyCtx.(*PropertyContext)
+		// Property names are case-insensitive, e.g. `format` and `FORMAT`
+		name := strings.ToUpper(property.IDENTIFIER(0).GetText())
+		if _, exists := properties[name]; exists {
+			v.addError(property, fmt.Sprintf("Duplicate property %s", name))
+		}
+		properties[name] = propertyValue(property)
+	}
+	return properties
+}
+
+// propertyValue The value of a `WITH` property, without the quotes of a string
+func propertyValue(ctx *PropertyContext) string {
+	switch {
+	case ctx.STRING() != nil:
+		return strings.Trim(ctx.STRING().GetText(), "'")
+	case ctx.NUMBER() != nil:
+		return ctx.NUMBER().GetText()
+	default:
+		return ctx.IDENTIFIER(1).GetText()
+	}
+}
@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"context"
+	"stream_combination/models"
+	"time"
+)
+
+// BatchConfig Micro-batching of the events passed between processors, which is on when Size is above 1. A batch
+// is passed on once it's full, or once its first event has waited MaxLatency, so batches keep flowing under low
+// load.
+type BatchConfig struct {
+	Size       int           `yaml:"size,omitempty"`
+	MaxLatency time.Duration `yaml:"max_latency,omitempty"` // DefaultBatchLatency when zero
+}
+
+// DefaultBatchLatency The longest an event waits for its batch to fill, unless configured
+const DefaultBatchLatency = 10 * time.Millisecond
+
+func (cfg BatchConfig) enabled() bool {
+	return cfg.Size > 1
+}
+
+func (cfg BatchConfig) maxLatency() time.Duration {
+	if cfg.MaxLatency <= 0 {
+		return DefaultBatchLatency
+	}
+	return cfg.MaxLatency
+}
+
+// BatchSource Processors that emit their results in batches, in batch mode
+type BatchSource interface {
+	Processor
+	// BatchResults The results in batches of up to `cfg.Size` events, in place of Results
+	BatchResults(ctx context.Context, consumerID string, cfg BatchConfig, errorCh chan<- error) <-chan []models.EventLike
+}
+
+// BatchProcessor Processors that take a whole batch at a time, e.g. filters evaluating every event of a batch in
+// one call, in batch mode
+type BatchProcessor interface {
+	MessageProcessor
+	AddBatch(ctx context.Context, events []models.EventLike) error
+}
+
+// batchResults A processor's results in batches, its own if it's a BatchSource, or else collected from its events
+func batchResults(ctx context.Context, processor Processor, consumerID string, cfg BatchConfig, errorCh chan<- error) <-chan []models.EventLike {
+	if source, ok := processor.(BatchSource); ok {
+		return source.BatchResults(ctx, consumerID, cfg, errorCh)
+	}
+	return collect(ctx, processor.Results(ctx, consumerID, errorCh), cfg)
+}
+
+// collect Batch the events of `events`, passing each batch on once it's full or its first event has waited
+// cfg.MaxLatency, and closing the batches once `events` closes.
+func collect(ctx context.Context, events <-chan models.EventLike, cfg BatchConfig) <-chan []models.EventLike {
+	batches := make(chan []models.EventLike)
+	go func() {
+		defer close(batches)
+		var batch []models.EventLike
+		timer := time.NewTimer(cfg.maxLatency())
+		timer.Stop()
+		flush := func() bool {
+			if len(batch) == 0 {
+				return true
+			}
+			select {
+			case batches <- batch:
+				batch = nil
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					flush()
+					return
+				}
+				if len(batch) == 0 {
+					timer.Reset(cfg.maxLatency())
+				}
+				batch = append(batch, event)
+				if len(batch) >= cfg.Size {
+					timer.Stop()
+					if !flush() {
+						return
+					}
+				}
+			case <-timer.C:
+				if !flush() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return batches
+}
+
+// batchBuffer How many batches a processor buffers in place of `bufferSize` events, at least one
+func batchBuffer(bufferSize int, cfg BatchConfig) int {
+	return max(1, bufferSize/cfg.Size)
+}
+
+// sendBatch Pass a batch on whole on `batches`, or an event at a time on `events` if the results aren't read in
+// batches, i.e. `batches` is nil. Empty batches aren't passed on.
+func sendBatch(ctx context.Context, batch []models.EventLike, batches chan []models.EventLike, events chan models.EventLike) error {
+	if batches == nil {
+		for _, event := range batch {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	select {
+	case batches <- batch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
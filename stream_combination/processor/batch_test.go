@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"context"
+	"stream_combination/models"
+	"testing"
+	"time"
+)
+
+// receiveBatch The next batch, failing the test if none arrives within `timeout`
+func receiveBatch(t *testing.T, batches <-chan []models.EventLike, timeout time.Duration) []models.EventLike {
+	t.Helper()
+	select {
+	case batch := <-batches:
+		return batch
+	case <-time.After(timeout):
+		t.Fatalf("no batch within %s", timeout)
+		return nil
+	}
+}
+
+func TestCollectFlushesFullBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan models.EventLike, 5)
+	batches := collect(ctx, events, BatchConfig{Size: 2, MaxLatency: time.Hour})
+	for i := 0; i < 5; i++ {
+		events <- models.NewEvent(time.Now(), map[string]interface{}{"id": i})
+	}
+	// Full batches are passed on without waiting for the latency
+	for _, expected := range []int{0, 2} {
+		batch := receiveBatch(t, batches, time.Second)
+		if len(batch) != 2 || batch[0].GetField("id") != expected {
+			t.Errorf("batch %v, expected two events from %d", batch, expected)
+		}
+	}
+	// The last event is passed on when the events close, rather than waiting out the hour
+	close(events)
+	if batch := receiveBatch(t, batches, time.Second); len(batch) != 1 || batch[0].GetField("id") != 4 {
+		t.Errorf("batch %v, expected the last event", batch)
+	}
+	if _, ok := <-batches; ok {
+		t.Error("expected the batches to close once the events had")
+	}
+}
+
+func TestCollectFlushesOnLatency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan models.EventLike)
+	latency := 20 * time.Millisecond
+	batches := collect(ctx, events, BatchConfig{Size: 100, MaxLatency: latency})
+
+	for round := 0; round < 2; round++ {
+		start := time.Now()
+		events <- models.NewEvent(start, nil)
+		events <- models.NewEvent(start, nil)
+		batch := receiveBatch(t, batches, time.Second)
+		if waited := time.Since(start); waited < latency {
+			t.Errorf("round %d: batch passed on after %s, before its first event had waited %s", round, waited, latency)
+		}
+		if len(batch) != 2 {
+			t.Errorf("round %d: batch of %d events, expected the 2 that had arrived", round, len(batch))
+		}
+	}
+}
+
+func TestCollectStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan models.EventLike)
+	batches := collect(ctx, events, BatchConfig{Size: 2})
+	cancel()
+	select {
+	case _, ok := <-batches:
+		if ok {
+			t.Error("expected no batches once the context ended")
+		}
+	case <-time.After(time.Second):
+		t.Error("batches stayed open after the context ended")
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	batch := []models.EventLike{models.NewEvent(time.Now(), nil), models.NewEvent(time.Now(), nil)}
+
+	events := make(chan models.EventLike, 2)
+	if err := sendBatch(context.Background(), batch, nil, events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("%d events sent, expected the batch an event at a time", len(events))
+	}
+
+	batches := make(chan []models.EventLike, 2)
+	if err := sendBatch(context.Background(), nil, batches, events); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendBatch(context.Background(), batch, batches, events); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 {
+		t.Errorf("%d batches sent, expected the empty batch to be dropped", len(batches))
+	}
+
+	if size := batchBuffer(50, BatchConfig{Size: 20}); size != 2 {
+		t.Errorf("buffer of %d batches, expected 2", size)
+	}
+	if size := batchBuffer(5, BatchConfig{Size: 20}); size != 1 {
+		t.Errorf("buffer of %d batches, expected at least 1", size)
+	}
+}
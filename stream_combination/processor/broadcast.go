@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"stream_combination/models"
+)
+
+// SlowConsumerPolicy What a Broadcast does with an event for a dependent whose buffer is full
+type SlowConsumerPolicy string
+
+const (
+	SlowConsumerBlock      SlowConsumerPolicy = "block"       // wait for the dependent, holding up the others
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop_oldest" // discard the dependent's oldest buffered event
+	SlowConsumerError      SlowConsumerPolicy = "error"       // fail the pipeline
+)
+
+func (p SlowConsumerPolicy) valid() bool {
+	switch p {
+	case SlowConsumerBlock, SlowConsumerDropOldest, SlowConsumerError:
+		return true
+	default:
+		return false
+	}
+}
+
+// FanOutConfig How events are copied to the dependents of a processor that has more than one
+type FanOutConfig struct {
+	BufferSize int                `yaml:"buffer_size,omitempty"` // per dependent
+	Policy     SlowConsumerPolicy `yaml:"policy,omitempty"`      // block by default
+}
+
+// Broadcast Copy each event of one processor's results to every dependent, each with its own buffer.
+type Broadcast struct {
+	input    <-chan models.EventLike
+	branches []chan models.EventLike
+	policy   SlowConsumerPolicy
+}
+
+func NewBroadcast(input <-chan models.EventLike, dependents int, cfg FanOutConfig) *Broadcast {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 50 // default
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = SlowConsumerBlock
+	}
+	branches := make([]chan models.EventLike, dependents)
+	for i := range branches {
+		branches[i] = make(chan models.EventLike, cfg.BufferSize)
+	}
+	return &Broadcast{input: input, branches: branches, policy: cfg.Policy}
+}
+
+// Branch The events for the i-th dependent
+func (b *Broadcast) Branch(i int) <-chan models.EventLike {
+	return b.branches[i]
+}
+
+// Run Copy events until the input closes or `ctx` is cancelled, then close every branch.
+func (b *Broadcast) Run(ctx context.Context, errorCh chan<- error) {
+	defer func() {
+		for _, branch := range b.branches {
+			close(branch)
+		}
+	}()
+	for {
+		select {
+		case event, ok := <-b.input:
+			if !ok {
+				return
+			}
+			// One copy for each dependent, each of which is done with it separately
+			models.Retain(event, len(b.branches)-1)
+			for i, branch := range b.branches {
+				if err := b.send(ctx, branch, event); err != nil {
+					select {
+					case errorCh <- fmt.Errorf("fan-out to dependent %d: %w", i, err):
+					case <-ctx.Done():
+					}
+					return
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *Broadcast) send(ctx context.Context, branch chan models.EventLike, event models.EventLike) error {
+	select {
+	case branch <- event:
+		return nil
+	default:
+	}
+	switch b.policy {
+	case SlowConsumerDropOldest:
+		// The broadcast is the only sender, so once an event is taken out there's room for this one
+		for {
+			select {
+			case branch <- event:
+				return nil
+			default:
+			}
+			select {
+			case dropped := <-branch:
+				models.Done(dropped)
+			default:
+			}
+		}
+	case SlowConsumerError:
+		return fmt.Errorf("buffer of %d events is full", cap(branch))
+	default:
+		select {
+		case branch <- event:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
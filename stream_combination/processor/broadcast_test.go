@@ -0,0 +1,77 @@
+package processor
+
+import (
+	"context"
+	"stream_combination/models"
+	"testing"
+	"time"
+)
+
+func TestBroadcastBackpressure(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SlowConsumerPolicy
+		// fails Whether the pipeline fails once the first dependent's buffer is full, rather than waiting for it
+		fails bool
+	}{
+		{"block", SlowConsumerBlock, false},
+		{"default", "", false},
+		{"error", SlowConsumerError, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			input := make(chan models.EventLike)
+			// The first dependent isn't read until the end, the second is read throughout
+			broadcast := NewBroadcast(input, 2, FanOutConfig{BufferSize: 1, Policy: test.policy})
+			errorCh := make(chan error, 1)
+			go broadcast.Run(ctx, errorCh)
+
+			input <- models.NewEvent(time.Now(), map[string]interface{}{"n": 0})
+			if event := <-broadcast.Branch(1); event.GetField("n") != 0 {
+				t.Fatalf("second dependent got %v first", event)
+			}
+			// Fills the first dependent's buffer
+			input <- models.NewEvent(time.Now(), map[string]interface{}{"n": 1})
+
+			if test.fails {
+				select {
+				case err := <-errorCh:
+					if err == nil {
+						t.Fatal("expected an error")
+					}
+				case <-ctx.Done():
+					t.Fatal("the broadcast didn't fail with a dependent's buffer full")
+				}
+				// Every branch is closed, so the dependents stop
+				for range broadcast.Branch(0) {
+				}
+				for range broadcast.Branch(1) {
+				}
+				return
+			}
+
+			select {
+			case event := <-broadcast.Branch(1):
+				t.Fatalf("second dependent got %v while the first's buffer was full", event)
+			case err := <-errorCh:
+				t.Fatal(err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			if event := <-broadcast.Branch(0); event.GetField("n") != 0 {
+				t.Fatalf("first dependent got %v first", event)
+			}
+			if event := <-broadcast.Branch(1); event.GetField("n") != 1 {
+				t.Fatalf("second dependent got %v once the first caught up", event)
+			}
+			close(input)
+			if event := <-broadcast.Branch(0); event.GetField("n") != 1 {
+				t.Fatalf("first dependent got %v second", event)
+			}
+			if _, ok := <-broadcast.Branch(0); ok {
+				t.Error("expected the branch to close with the input")
+			}
+		})
+	}
+}
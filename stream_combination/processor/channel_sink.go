@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"context"
+	"strconv"
+	"stream_combination/models"
+
+	"github.com/google/uuid"
+)
+
+// ChannelSink Forward events to a channel read outside the pipeline, e.g. by the REPL
+type ChannelSink struct {
+	id        uuid.UUID
+	messageCh chan models.EventLike
+}
+
+func NewChannelSink(bufferSize int) *ChannelSink {
+	if bufferSize <= 0 {
+		bufferSize = 50 // default
+	}
+	return &ChannelSink{
+		id:        uuid.New(),
+		messageCh: make(chan models.EventLike, bufferSize),
+	}
+}
+
+func (cs *ChannelSink) ID() string {
+	return cs.id.String()
+}
+
+func (cs *ChannelSink) sink() {}
+
+func (cs *ChannelSink) Describe() map[string]string {
+	return map[string]string{"buffer_size": strconv.Itoa(cap(cs.messageCh))}
+}
+
+// Add Forward an event, acking the messages it came from once it's handed over, as what's done with it outside
+// the pipeline, e.g. writing it to a client, isn't retried.
+func (cs *ChannelSink) Add(ctx context.Context, event models.EventLike) error {
+	select {
+	case cs.messageCh <- event:
+		models.Done(event)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (cs *ChannelSink) Buffer() (int, int) {
+	return len(cs.messageCh), cap(cs.messageCh)
+}
+
+// Events Every event that reached the sink
+func (cs *ChannelSink) Events() <-chan models.EventLike {
+	return cs.messageCh
+}
+
+func (cs *ChannelSink) Results(ctx context.Context, consumerID string, errorCh chan<- error) <-chan models.EventLike {
+	messageCh := make(chan models.EventLike)
+	return messageCh
+}
+
+func (cs *ChannelSink) Close() error {
+	return nil
+}
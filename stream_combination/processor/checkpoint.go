@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"stream_combination/models"
+
+	"github.com/tidwall/btree"
+)
+
+// Checkpointer Processors with state, e.g. join windows, that's saved so another run of the query can carry on
+// from it, e.g. on another instance after a failover
+type Checkpointer interface {
+	// Checkpoint The processor's state, which may be taken while it runs, and `commit`, to be called once it's
+	// saved, which acks the messages whose events it covers
+	Checkpoint() (data []byte, commit func(), err error)
+	// Restore Carry on from a checkpoint, before the pipeline runs
+	Restore(data []byte) error
+}
+
+// Checkpointers The processors with state to checkpoint, keyed by their position in the DAG, which is the same
+// each time the same query is built
+func (pb *ProcessorBuilder) Checkpointers() map[string]Checkpointer {
+	checkpointers := make(map[string]Checkpointer)
+	for i, id := range pb.order {
+		if checkpointer, ok := pb.processors[id].(Checkpointer); ok {
+			checkpointers[strconv.Itoa(i)] = checkpointer
+		}
+	}
+	return checkpointers
+}
+
+// windowCheckpoint The events waiting for a match in a join window
+type windowCheckpoint struct {
+	Left  []*models.Snapshot `json:"left"`
+	Right []*models.Snapshot `json:"right"`
+}
+
+// Checkpoint The events waiting for a match, taken on the event loop between events. Committing it acks the
+// messages of the events it covers, and of those covered by earlier checkpoints that weren't committed.
+func (swj *SlidingWindowJoin) Checkpoint() ([]byte, func(), error) {
+	var checkpoint windowCheckpoint
+	snapshot := func(events map[string]*btree.BTreeG[models.EventLike], snapshots *[]*models.Snapshot) error {
+		for _, tree := range events {
+			var err error
+			tree.Scan(func(event models.EventLike) bool {
+				var s *models.Snapshot
+				if s, err = models.SnapshotOf(event); err != nil {
+					return false
+				}
+				*snapshots = append(*snapshots, s)
+				return true
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var err error
+	var covered int
+	swj.do(func() {
+		for _, bucket := range swj.timeBuckets {
+			if err = snapshot(bucket.leftEvents, &checkpoint.Left); err != nil {
+				return
+			}
+			if err = snapshot(bucket.rightEvents, &checkpoint.Right); err != nil {
+				return
+			}
+		}
+		for d, n := range swj.held {
+			for ; n > 0; n-- {
+				swj.unsaved = append(swj.unsaved, d)
+			}
+		}
+		clear(swj.held)
+		covered = len(swj.unsaved)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("join %s: %w", swj.ID(), err)
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	commit := func() {
+		var saved []*models.Delivery
+		swj.do(func() {
+			saved = swj.unsaved[:covered]
+			swj.unsaved = swj.unsaved[covered:]
+		})
+		for _, d := range saved {
+			d.Done()
+		}
+	}
+	return data, commit, nil
+}
+
+// Restore Put the events of a checkpoint back in the window, without joining them, as those that matched were
+// joined before it was taken. Events that have since fallen out of the window are dropped.
+func (swj *SlidingWindowJoin) Restore(data []byte) error {
+	var checkpoint windowCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return fmt.Errorf("error unmarshalling checkpoint of join %s: %w", swj.ID(), err)
+	}
+	var events []sideEvent
+	for _, s := range checkpoint.Left {
+		events = append(events, sideEvent{event: s.Event(), isLeft: true})
+	}
+	for _, s := range checkpoint.Right {
+		events = append(events, sideEvent{event: s.Event(), isLeft: false})
+	}
+	// Oldest first, so the window slides forward as it would have while they arrived
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].event.GetTimestamp().Before(events[j].event.GetTimestamp())
+	})
+
+	swj.do(func() {
+		for _, se := range events {
+			_ = swj.store(se.event, se.isLeft) // only events too old for the window fail
+		}
+	})
+	return nil
+}
+
+// Checkpoint The checkpoints of the partitions, which are taken while they run, one at a time, and committed
+// together
+func (pj *PartitionedJoin) Checkpoint() ([]byte, func(), error) {
+	partitions := make([]json.RawMessage, len(pj.partitions))
+	commits := make([]func(), len(pj.partitions))
+	for i, partition := range pj.partitions {
+		data, commit, err := partition.join.Checkpoint()
+		if err != nil {
+			return nil, nil, err
+		}
+		partitions[i], commits[i] = data, commit
+	}
+	data, err := json.Marshal(partitions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() {
+		for _, commit := range commits {
+			commit()
+		}
+	}, nil
+}
+
+func (pj *PartitionedJoin) Restore(data []byte) error {
+	var partitions []json.RawMessage
+	if err := json.Unmarshal(data, &partitions); err != nil {
+		return fmt.Errorf("error unmarshalling checkpoint of join %s: %w", pj.ID(), err)
+	}
+	if len(partitions) != len(pj.partitions) {
+		return fmt.Errorf("checkpoint of join %s has %d partitions, expected %d", pj.ID(), len(partitions), len(pj.partitions))
+	}
+	for i, partition := range pj.partitions {
+		if err := partition.join.Restore(partitions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
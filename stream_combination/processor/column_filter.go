@@ -2,7 +2,11 @@ package processor
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"stream_combination/models"
+	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 )
@@ -11,7 +15,10 @@ import (
 type ColumnFilter struct {
 	id        uuid.UUID
 	fields    []string
+	aliases   []string
 	messageCh chan models.EventLike
+	batchCh   chan []models.EventLike // set once the results are read in batches
+	closeOnce sync.Once
 }
 
 func NewColumnFilter(fields []string, bufferSize int) (*ColumnFilter, error) {
@@ -21,32 +28,107 @@ func NewColumnFilter(fields []string, bufferSize int) (*ColumnFilter, error) {
 	return &ColumnFilter{
 		id:        uuid.New(),
 		fields:    fields,
+		aliases:   fields,
 		messageCh: make(chan models.EventLike, bufferSize),
 	}, nil
 }
 
+// NewAliasedColumnFilter Filter an event down to `fields`, renaming each to the matching entry in `aliases`
+func NewAliasedColumnFilter(fields []string, aliases []string, bufferSize int) (*ColumnFilter, error) {
+	if len(fields) != len(aliases) {
+		return nil, fmt.Errorf("expected %d aliases, got %d", len(fields), len(aliases))
+	}
+	cf, err := NewColumnFilter(fields, bufferSize)
+	if err != nil {
+		return nil, err
+	}
+	cf.aliases = aliases
+	return cf, nil
+}
+
 func (cf *ColumnFilter) ID() string {
 	return cf.id.String()
 }
 
+func (cf *ColumnFilter) Describe() map[string]string {
+	columns := make([]string, len(cf.fields))
+	for i, field := range cf.fields {
+		columns[i] = field
+		if cf.aliases[i] != field {
+			columns[i] += " AS " + cf.aliases[i]
+		}
+	}
+	return map[string]string{
+		"columns":     strings.Join(columns, ", "),
+		"buffer_size": strconv.Itoa(cap(cf.messageCh)),
+	}
+}
+
 func (cf *ColumnFilter) Add(ctx context.Context, event models.EventLike) error {
+	return cf.send(ctx, cf.project(event))
+}
+
+// AddBatch Project a whole batch, passing it on as one batch
+func (cf *ColumnFilter) AddBatch(ctx context.Context, events []models.EventLike) error {
+	projected := make([]models.EventLike, len(events))
+	for i, event := range events {
+		projected[i] = cf.project(event)
+	}
+	return sendBatch(ctx, projected, cf.batchCh, cf.messageCh)
+}
+
+func (cf *ColumnFilter) project(event models.EventLike) models.EventLike {
+	if len(cf.fields) == 1 && cf.fields[0] == "*" {
+		// SELECT * keeps the whole event
+		return event
+	}
 	data := make(map[string]interface{})
-	for _, field := range cf.fields {
+	for i, field := range cf.fields {
 		fieldData := event.GetField(field)
 		if fieldData == nil {
 			continue
 		}
-		data[field] = fieldData
+		data[cf.aliases[i]] = fieldData
 	}
-	cf.messageCh <- models.NewEvent(event.GetTimestamp(), data)
-	return nil
+	// Keep the metadata, so pseudo-columns can still be read downstream, e.g. by an output subject template
+	return models.NewDerivedEvent(event, data)
+}
+
+func (cf *ColumnFilter) send(ctx context.Context, event models.EventLike) error {
+	select {
+	case cf.messageCh <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (cf *ColumnFilter) Buffer() (int, int) {
+	if cf.batchCh != nil {
+		return len(cf.batchCh), cap(cf.batchCh)
+	}
+	return len(cf.messageCh), cap(cf.messageCh)
 }
 
 func (cf *ColumnFilter) Results(ctx context.Context, consumerID string, errorCh chan<- error) <-chan models.EventLike {
 	return cf.messageCh
 }
 
+// BatchResults The results in batches, buffering as many events as Results would, in batches
+func (cf *ColumnFilter) BatchResults(ctx context.Context, consumerID string, cfg BatchConfig, errorCh chan<- error) <-chan []models.EventLike {
+	if cf.batchCh == nil {
+		cf.batchCh = make(chan []models.EventLike, batchBuffer(cap(cf.messageCh), cfg))
+	}
+	return cf.batchCh
+}
+
+// Close Close the results, once every input has closed. Adding events afterwards panics.
 func (cf *ColumnFilter) Close() error {
-	close(cf.messageCh)
+	cf.closeOnce.Do(func() {
+		close(cf.messageCh)
+		if cf.batchCh != nil {
+			close(cf.batchCh)
+		}
+	})
 	return nil
 }
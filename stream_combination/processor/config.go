@@ -2,6 +2,7 @@ package processor
 
 import (
 	"github.com/nats-io/nats.go/jetstream"
+	"stream_combination/codec"
 	"stream_combination/models"
 	"time"
 )
@@ -10,31 +11,54 @@ type ProcessorConfig struct {
 	Sources   []StreamSource           `yaml:"sources"`
 	Selectors []models.SelectCondition `yaml:"selectors"`
 	Output    StreamOutput             `yaml:"output"`
-	Window    *WindowConfig            `yaml:"window,omitempty"`
-	Join      *JoinConfig              `yaml:"join,omitempty"`
+	// Outputs Publish every row to each of several outputs, in place of Output
+	Outputs []StreamOutput `yaml:"outputs,omitempty"`
+	Window  *WindowConfig  `yaml:"window,omitempty"`
+	Join    *JoinConfig    `yaml:"join,omitempty"`
+	// FanOut How rows are copied to each of Outputs
+	FanOut FanOutConfig `yaml:"fan_out,omitempty"`
+	// BufferSize How many events each processor buffers, and each source fetches ahead
+	BufferSize int `yaml:"buffer_size,omitempty"`
+	// Parallelism How many partitions the join is split into by join key, each joined by its own goroutine
+	Parallelism int `yaml:"parallelism,omitempty"`
+	// Batch Pass events between processors in batches, rather than one at a time
+	Batch BatchConfig `yaml:"batch,omitempty"`
 }
 
 type StreamSource struct {
 	Stream   string         `yaml:"stream"`  // NATS stream name
 	Subject  string         `yaml:"subject"` // Subject pattern to subscribe to
 	Consumer ConsumerConfig `yaml:"consumer"`
+	Encoding codec.Config   `yaml:"encoding,omitempty"` // Payload format, JSON by default
+	// DeadLetterSubject Where messages that can't be decoded are published
+	DeadLetterSubject string `yaml:"dead_letter_subject,omitempty"`
 }
 
 type ConsumerConfig struct {
 	Name          string                  `yaml:"name"`
 	Durable       bool                    `yaml:"durable"`
 	DeliverPolicy jetstream.DeliverPolicy `yaml:"deliver_policy"` // All, Last, New, etc.
-	AckPolicy     jetstream.AckPolicy     `yaml:"ack_policy"`     // Explicit, None, All
-	MaxDeliver    int                     `yaml:"max_deliver,omitempty"`
-	FilterSubject string                  `yaml:"filter_subject,omitempty"`
+	// AckPolicy Explicit, None or All, explicit when it's nil
+	AckPolicy     *jetstream.AckPolicy `yaml:"ack_policy"`
+	MaxDeliver    int                  `yaml:"max_deliver,omitempty"`
+	FilterSubject string               `yaml:"filter_subject,omitempty"`
+	// FilterSubjects Read any of several subjects of the stream, in place of FilterSubject
+	FilterSubjects []string `yaml:"filter_subjects,omitempty"`
 }
 
 type StreamOutput struct {
-	Stream  string            `yaml:"stream"`  // Target NATS stream
-	Subject string            `yaml:"subject"` // Subject to publish to
-	Headers map[string]string `yaml:"headers,omitempty"`
-	MaxAge  time.Duration     `yaml:"max_age,omitempty"`
-	MaxMsgs int64             `yaml:"max_msgs,omitempty"`
+	Stream  string `yaml:"stream"`  // Target NATS stream
+	Subject string `yaml:"subject"` // Subject to publish to, which can have `{field}` placeholders
+	// FallbackSubject Where rows are published when the subject's placeholders can't be filled in
+	FallbackSubject string            `yaml:"fallback_subject,omitempty"`
+	Headers         map[string]string `yaml:"headers,omitempty"`
+	MaxAge          time.Duration     `yaml:"max_age,omitempty"`
+	MaxMsgs         int64             `yaml:"max_msgs,omitempty"`
+	Encoding        codec.Config      `yaml:"encoding,omitempty"`
+	// MsgID The column whose value is each row's Nats-Msg-Id. By default it's the source sequences of the row.
+	MsgID string `yaml:"msg_id,omitempty"`
+	// DuplicateWindow How long the output stream remembers message IDs, the server's default when zero
+	DuplicateWindow time.Duration `yaml:"duplicate_window,omitempty"`
 }
 
 type WindowConfig struct {
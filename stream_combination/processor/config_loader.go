@@ -0,0 +1,383 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"stream_combination/codec"
+	"stream_combination/models"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError An invalid value in a ProcessorConfig, located by its YAML path
+type ConfigError struct {
+	Path    string // e.g. sources[1].consumer.name
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s (line %d:%d): %s", e.Path, e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// LoadProcessorConfig Read and validate a pipeline definition from a YAML file
+func LoadProcessorConfig(path string) (*ProcessorConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config %s: %w", path, err)
+	}
+	return ParseProcessorConfig(data)
+}
+
+func ParseProcessorConfig(data []byte) (*ProcessorConfig, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("error parsing config: %w", err)
+	}
+	var cfg ProcessorConfig
+	if err := root.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("error decoding config: %w", err)
+	}
+	if errs := cfg.Validate(); len(errs) > 0 {
+		for i := range errs {
+			if node := lookupNode(&root, errs[i].Path); node != nil {
+				errs[i].Line, errs[i].Column = node.Line, node.Column
+			}
+		}
+		return nil, errs
+	}
+	return &cfg, nil
+}
+
+// Validate Check the config for values a pipeline can't be built from. Positions are left empty.
+func (cfg *ProcessorConfig) Validate() ConfigErrors {
+	var errs ConfigErrors
+	addError := func(path string, format string, args ...interface{}) {
+		errs = append(errs, ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(cfg.Sources) == 0 {
+		addError("sources", "at least one source is required")
+	}
+	for i, source := range cfg.Sources {
+		path := fmt.Sprintf("sources[%d]", i)
+		if source.Stream == "" {
+			addError(path+".stream", "stream is required")
+		}
+		if source.Consumer.Durable && source.Consumer.Name == "" {
+			addError(path+".consumer.name", "durable consumers require a name")
+		}
+		if source.Consumer.MaxDeliver < 0 {
+			addError(path+".consumer.max_deliver", "must not be negative")
+		}
+		if _, err := codec.NewDecoder(source.Encoding); err != nil {
+			addError(path+".encoding", "%v", err)
+		}
+	}
+
+	if len(cfg.Selectors) == 0 {
+		addError("selectors", "at least one selector is required")
+	}
+	for i, selector := range cfg.Selectors {
+		path := fmt.Sprintf("selectors[%d]", i)
+		if len(selector.Field) == 0 {
+			addError(path+".field", "field is required")
+		}
+		if selector.StreamName != nil && cfg.sourceSide(*selector.StreamName) == "" {
+			addError(path+".stream", "unknown source %q", *selector.StreamName)
+		}
+	}
+
+	if len(cfg.Outputs) > 0 && (cfg.Output.Stream != "" || cfg.Output.Subject != "") {
+		addError("outputs", "set either output or outputs, not both")
+	}
+	for i, output := range cfg.outputs() {
+		path := "output"
+		if len(cfg.Outputs) > 0 {
+			path = fmt.Sprintf("outputs[%d]", i)
+		}
+		if output.Subject == "" {
+			addError(path+".subject", "subject is required")
+		} else if _, err := ParseSubjectTemplate(output.Subject); err != nil {
+			addError(path+".subject", "%v", err)
+		}
+		if output.FallbackSubject != "" {
+			if err := validateSubject(output.FallbackSubject); err != nil {
+				addError(path+".fallback_subject", "%v", err)
+			}
+		}
+		if output.MaxMsgs < 0 {
+			addError(path+".max_msgs", "must not be negative")
+		}
+		if _, err := codec.NewEncoder(output.Encoding); err != nil {
+			addError(path+".encoding", "%v", err)
+		}
+	}
+
+	if cfg.BufferSize < 0 {
+		addError("buffer_size", "must not be negative")
+	}
+	if cfg.Parallelism < 0 {
+		addError("parallelism", "must not be negative")
+	}
+	if cfg.Batch.Size < 0 {
+		addError("batch.size", "must not be negative")
+	}
+	if cfg.Batch.MaxLatency < 0 {
+		addError("batch.max_latency", "must not be negative")
+	}
+	if cfg.FanOut.BufferSize < 0 {
+		addError("fan_out.buffer_size", "must not be negative")
+	}
+	if cfg.FanOut.Policy != "" && !cfg.FanOut.Policy.valid() {
+		addError("fan_out.policy", "unknown policy %q, expected %q, %q or %q", cfg.FanOut.Policy, SlowConsumerBlock, SlowConsumerDropOldest, SlowConsumerError)
+	}
+
+	if cfg.Window != nil {
+		if cfg.Join == nil {
+			addError("window", "windows are only supported for joins")
+		}
+		if cfg.Window.Type != WindowTypeSliding {
+			addError("window.type", "unsupported window type %q, expected %q", cfg.Window.Type, WindowTypeSliding)
+		}
+		if cfg.Window.Duration <= 0 {
+			addError("window.duration", "duration must be positive")
+		}
+		if cfg.Window.Advance != 0 {
+			addError("window.advance", "advance is not supported for sliding joins")
+		}
+	}
+
+	if cfg.Join != nil {
+		if len(cfg.Sources) != 2 {
+			addError("join", "joins require exactly 2 sources, got %d", len(cfg.Sources))
+		}
+		if cfg.Window == nil {
+			addError("join", "joins require a window")
+		}
+		if cfg.Join.Type != JoinTypeInner {
+			addError("join.type", "unsupported join type %q, expected %q", cfg.Join.Type, JoinTypeInner)
+		}
+		if len(cfg.Join.OnFields) == 0 {
+			addError("join.on_fields", "at least one field is required")
+		}
+		if cfg.Join.TimeField != "" {
+			addError("join.time_field", "time_field is not supported, events are joined on their stream timestamp")
+		}
+	}
+	return errs
+}
+
+// outputs Where rows are published: Outputs, or Output when there's only one
+func (cfg *ProcessorConfig) outputs() []StreamOutput {
+	if len(cfg.Outputs) > 0 {
+		return cfg.Outputs
+	}
+	return []StreamOutput{cfg.Output}
+}
+
+// sourceSide Resolve a selector's stream to the side of the join it was read from.
+func (cfg *ProcessorConfig) sourceSide(stream string) string {
+	for i, source := range cfg.Sources {
+		if source.Stream != stream {
+			continue
+		}
+		switch {
+		case cfg.Join == nil:
+			return "single"
+		case i == 0:
+			return "left"
+		default:
+			return "right"
+		}
+	}
+	return ""
+}
+
+func (cfg *ProcessorConfig) selectorField(selector models.SelectCondition) string {
+	field := strings.Join(selector.Field, ".")
+	if cfg.Join != nil && selector.StreamName != nil {
+		return cfg.sourceSide(*selector.StreamName) + "." + field
+	}
+	return field
+}
+
+// Apply Add the processors described by the config to a ProcessorBuilder, returning the sink of each output.
+func (cfg *ProcessorConfig) Apply(pb *ProcessorBuilder) ([]*JetStreamSink, error) {
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+
+	readers := make([]*SubjectReader, len(cfg.Sources))
+	for i, source := range cfg.Sources {
+		reader, err := NewSubjectReaderFromSource(pb.JetStream, source)
+		if err != nil {
+			return nil, err
+		}
+		reader.SetMaxPending(pb.BufferSize())
+		pb.AddProcessor(reader.ID(), reader)
+		pb.SetLocation(reader.ID(), fmt.Sprintf("sources[%d]", i))
+		pb.AddAlias(source.Stream, reader.ID())
+		readers[i] = reader
+	}
+
+	upstreamID := readers[0].ID()
+	if cfg.Join != nil {
+		predicates := make([]EquiJoinPredicate, len(cfg.Join.OnFields))
+		for i, field := range cfg.Join.OnFields {
+			field := field
+			getField := func(event models.EventLike) string { return event.GetString(field) }
+			predicates[i] = *NewEquiJoin(getField, getField)
+		}
+		join := pb.NewWindowJoin(cfg.Window.Duration, predicates)
+		pb.AddDualProcessor(join.ID(), join, readers[0].ID(), readers[1].ID())
+		pb.SetLocation(join.ID(), "join")
+		upstreamID = join.ID()
+	}
+
+	fields := make([]string, len(cfg.Selectors))
+	aliases := make([]string, len(cfg.Selectors))
+	for i, selector := range cfg.Selectors {
+		fields[i] = cfg.selectorField(selector)
+		aliases[i] = fields[i]
+		if selector.Alias != "" {
+			aliases[i] = selector.Alias
+		}
+	}
+	columnFilter, err := NewAliasedColumnFilter(fields, aliases, pb.BufferSize())
+	if err != nil {
+		return nil, err
+	}
+	pb.AddProcessor(columnFilter.ID(), columnFilter, upstreamID)
+	pb.SetLocation(columnFilter.ID(), "selectors")
+
+	// With several outputs, the column filter fans out to their sinks
+	sinks := make([]*JetStreamSink, 0, len(cfg.outputs()))
+	for i, output := range cfg.outputs() {
+		sink, err := NewJetStreamSink(pb.JetStream, output)
+		if err != nil {
+			return nil, err
+		}
+		pb.AddProcessor(sink.ID(), sink, columnFilter.ID())
+		if len(cfg.Outputs) > 0 {
+			pb.SetLocation(sink.ID(), fmt.Sprintf("outputs[%d]", i))
+		} else {
+			pb.SetLocation(sink.ID(), "output")
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// BuildFromConfig Build a StreamProcessor from a validated config, creating the output streams if required.
+func BuildFromConfig(ctx context.Context, js jetstream.JetStream, cfg *ProcessorConfig, errorCh chan<- error) (*StreamProcessor, error) {
+	builder := NewProcessorBuilder(js)
+	builder.SetFanOut(cfg.FanOut)
+	builder.SetBufferSize(cfg.BufferSize)
+	builder.SetParallelism(cfg.Parallelism)
+	builder.SetBatch(cfg.Batch)
+	sinks, err := cfg.Apply(builder)
+	if err != nil {
+		return nil, err
+	}
+	for _, sink := range sinks {
+		if err := sink.EnsureStream(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return builder.Build(ctx, errorCh)
+}
+
+// lookupNode Find the YAML node at a path such as `sources[0].consumer.name`,
+// falling back to the closest parent that exists.
+func lookupNode(root *yaml.Node, path string) *yaml.Node {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, segment := range strings.Split(path, ".") {
+		key, index := segment, -1
+		if open := strings.Index(segment, "["); open >= 0 {
+			key = segment[:open]
+			index, _ = strconv.Atoi(strings.TrimSuffix(segment[open+1:], "]"))
+		}
+		child := mappingValue(node, key)
+		if child == nil {
+			return node
+		}
+		node = child
+		if index >= 0 {
+			if node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return node
+			}
+			node = node.Content[index]
+		}
+	}
+	return node
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// UnmarshalYAML Decode policies from their JetStream names, e.g. `deliver_policy: last_per_subject`
+func (c *ConsumerConfig) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Name           string   `yaml:"name"`
+		Durable        bool     `yaml:"durable"`
+		DeliverPolicy  string   `yaml:"deliver_policy"`
+		AckPolicy      string   `yaml:"ack_policy"`
+		MaxDeliver     int      `yaml:"max_deliver"`
+		FilterSubject  string   `yaml:"filter_subject"`
+		FilterSubjects []string `yaml:"filter_subjects"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*c = ConsumerConfig{
+		Name:           raw.Name,
+		Durable:        raw.Durable,
+		MaxDeliver:     raw.MaxDeliver,
+		FilterSubject:  raw.FilterSubject,
+		FilterSubjects: raw.FilterSubjects,
+	}
+	if raw.DeliverPolicy != "" {
+		if err := c.DeliverPolicy.UnmarshalJSON([]byte(strconv.Quote(raw.DeliverPolicy))); err != nil {
+			return fmt.Errorf("line %d: invalid deliver_policy %q", value.Line, raw.DeliverPolicy)
+		}
+	}
+	if raw.AckPolicy != "" {
+		var policy jetstream.AckPolicy
+		if err := policy.UnmarshalJSON([]byte(strconv.Quote(raw.AckPolicy))); err != nil {
+			return fmt.Errorf("line %d: invalid ack_policy %q", value.Line, raw.AckPolicy)
+		}
+		c.AckPolicy = &policy
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package processor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// joinPipeline A valid pipeline file joining two sources
+const joinPipeline = `
+sources:
+  - stream: users
+    consumer:
+      name: user-purchases
+      durable: true
+      deliver_policy: last_per_subject
+      ack_policy: none
+  - stream: purchases
+    subject: purchases.eu
+selectors:
+  - field: [name]
+    stream: users
+  - field: [amount]
+    stream: purchases
+    alias: purchase_amount
+window:
+  type: sliding
+  duration: 1h
+join:
+  type: inner
+  on_fields: [user_id]
+output:
+  stream: user_purchases
+  subject: user_purchases.created
+`
+
+func TestParseProcessorConfig(t *testing.T) {
+	cfg, err := ParseProcessorConfig([]byte(joinPipeline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	none := jetstream.AckNonePolicy
+	expected := ConsumerConfig{
+		Name:          "user-purchases",
+		Durable:       true,
+		DeliverPolicy: jetstream.DeliverLastPerSubjectPolicy,
+		AckPolicy:     &none,
+	}
+	if !reflect.DeepEqual(cfg.Sources[0].Consumer, expected) {
+		t.Errorf("consumer %+v, expected %+v", cfg.Sources[0].Consumer, expected)
+	}
+	if cfg.Sources[1].Consumer.AckPolicy != nil {
+		t.Errorf("ack policy %v without ack_policy, expected none to be set", *cfg.Sources[1].Consumer.AckPolicy)
+	}
+	if cfg.Window.Duration != time.Hour || cfg.Join.OnFields[0] != "user_id" || cfg.Output.Subject != "user_purchases.created" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
+
+func TestParseProcessorConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		// path The path of the first ConfigError, with its line, or "" if parsing fails before validation
+		path string
+		line int
+	}{
+		{"not YAML", "sources: [", "", 0},
+		{"unknown ack policy", "sources:\n  - stream: a\n    consumer:\n      ack_policy: sometimes\n", "", 0},
+		{"unknown deliver policy", "sources:\n  - stream: a\n    consumer:\n      deliver_policy: soon\n", "", 0},
+		{"located at the value", "sources:\n  - stream: a\n    consumer:\n      max_deliver: -1\nselectors:\n  - field: [id]\noutput:\n  subject: out\n",
+			"sources[0].consumer.max_deliver", 4},
+		// The name is missing, so the error is at the consumer
+		{"located at the closest parent", "sources:\n  - stream: a\n    consumer:\n      durable: true\nselectors:\n  - field: [id]\noutput:\n  subject: out\n",
+			"sources[0].consumer.name", 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseProcessorConfig([]byte(test.yaml))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var errs ConfigErrors
+			if !errors.As(err, &errs) {
+				if test.path != "" {
+					t.Fatalf("error %v, expected a ConfigError at %s", err, test.path)
+				}
+				return
+			}
+			if test.path == "" {
+				t.Fatalf("validation errors %v, expected parsing to fail", errs)
+			}
+			if errs[0].Path != test.path || errs[0].Line != test.line {
+				t.Errorf("first error %v, expected one at %s on line %d", errs[0], test.path, test.line)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *ProcessorConfig)
+		paths  []string
+	}{
+		{"valid", func(cfg *ProcessorConfig) {}, nil},
+		{"no sources", func(cfg *ProcessorConfig) { cfg.Sources = nil }, []string{"sources", "selectors[0].stream", "selectors[1].stream", "join"}},
+		{"source without a stream", func(cfg *ProcessorConfig) { cfg.Sources[1].Stream = "" }, []string{"sources[1].stream", "selectors[1].stream"}},
+		{"unknown source format", func(cfg *ProcessorConfig) { cfg.Sources[0].Encoding.Format = "xml" }, []string{"sources[0].encoding"}},
+		{"negative max deliver", func(cfg *ProcessorConfig) { cfg.Sources[0].Consumer.MaxDeliver = -1 }, []string{"sources[0].consumer.max_deliver"}},
+		{"no selectors", func(cfg *ProcessorConfig) { cfg.Selectors = nil }, []string{"selectors"}},
+		{"selector of an unknown source", func(cfg *ProcessorConfig) {
+			stream := "orders"
+			cfg.Selectors[0].StreamName = &stream
+		}, []string{"selectors[0].stream"}},
+		{"no output subject", func(cfg *ProcessorConfig) { cfg.Output.Subject = "" }, []string{"output.subject"}},
+		{"invalid output subject", func(cfg *ProcessorConfig) { cfg.Output.Subject = "out.{id" }, []string{"output.subject"}},
+		{"invalid fallback subject", func(cfg *ProcessorConfig) { cfg.Output.FallbackSubject = "out.*" }, []string{"output.fallback_subject"}},
+		{"output and outputs", func(cfg *ProcessorConfig) { cfg.Outputs = []StreamOutput{{Subject: "copy"}} }, []string{"outputs"}},
+		{"invalid one of outputs", func(cfg *ProcessorConfig) {
+			cfg.Outputs = []StreamOutput{{Subject: "copy"}, {Subject: "copy", MaxMsgs: -1}}
+			cfg.Output = StreamOutput{}
+		}, []string{"outputs[1].max_msgs"}},
+		{"unknown fan-out policy", func(cfg *ProcessorConfig) { cfg.FanOut.Policy = "drop_newest" }, []string{"fan_out.policy"}},
+		{"negative sizes", func(cfg *ProcessorConfig) {
+			cfg.BufferSize, cfg.Parallelism, cfg.Batch.Size = -1, -1, -1
+		}, []string{"buffer_size", "parallelism", "batch.size"}},
+		{"join without a window", func(cfg *ProcessorConfig) { cfg.Window = nil }, []string{"join"}},
+		{"join of one source", func(cfg *ProcessorConfig) {
+			cfg.Sources = cfg.Sources[:1]
+		}, []string{"selectors[1].stream", "join"}},
+		{"window without a join", func(cfg *ProcessorConfig) {
+			cfg.Join = nil
+			cfg.Sources = cfg.Sources[:1]
+			cfg.Selectors = cfg.Selectors[:1]
+		}, []string{"window"}},
+		{"unsupported window", func(cfg *ProcessorConfig) {
+			cfg.Window.Type = WindowTypeTumbling
+			cfg.Window.Advance = time.Minute
+		}, []string{"window.type", "window.advance"}},
+		{"unsupported join", func(cfg *ProcessorConfig) {
+			cfg.Join.Type = JoinTypeLeft
+			cfg.Join.OnFields = nil
+			cfg.Join.TimeField = "at"
+		}, []string{"join.type", "join.on_fields", "join.time_field"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := ParseProcessorConfig([]byte(joinPipeline))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.change(cfg)
+			var paths []string
+			for _, err := range cfg.Validate() {
+				paths = append(paths, err.Path)
+			}
+			if !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("errors at %v, expected %v", paths, test.paths)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	cfg, err := ParseProcessorConfig([]byte(joinPipeline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewProcessorBuilder(nil)
+	sinks, err := cfg.Apply(builder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := builder.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	var readers []*SubjectReader
+	var join DualInputProcessor
+	var filter *ColumnFilter
+	for _, id := range builder.order {
+		switch processor := builder.processors[id].(type) {
+		case *SubjectReader:
+			readers = append(readers, processor)
+		case DualInputProcessor:
+			join = processor
+		case *ColumnFilter:
+			filter = processor
+		}
+	}
+	if len(readers) != 2 || join == nil || filter == nil || len(sinks) != 1 {
+		t.Fatalf("expected two readers, a join, a column filter and a sink, got %v", builder.order)
+	}
+	if inputs := builder.dualInputs[join.ID()]; inputs != [2]string{readers[0].ID(), readers[1].ID()} {
+		t.Errorf("join inputs %v, expected the first source on the left", inputs)
+	}
+	if !reflect.DeepEqual(builder.edges[join.ID()], []string{filter.ID()}) || !reflect.DeepEqual(builder.edges[filter.ID()], []string{sinks[0].ID()}) {
+		t.Error("expected the join to feed the column filter, and the column filter the sink")
+	}
+	if expected := []string{"left.name", "right.amount"}; !reflect.DeepEqual(filter.fields, expected) {
+		t.Errorf("selected %v, expected the fields of each side %v", filter.fields, expected)
+	}
+	if expected := []string{"left.name", "purchase_amount"}; !reflect.DeepEqual(filter.aliases, expected) {
+		t.Errorf("aliases %v, expected %v", filter.aliases, expected)
+	}
+
+	consumers := []struct {
+		name          string
+		ackPolicy     jetstream.AckPolicy
+		filterSubject string
+	}{
+		{"user-purchases", jetstream.AckNonePolicy, ""},
+		{"purchases-q-reader", jetstream.AckExplicitPolicy, "purchases.eu"},
+	}
+	for i, expected := range consumers {
+		got := readers[i].consumerConfig("q")
+		if got.Name != expected.name || got.AckPolicy != expected.ackPolicy || got.FilterSubject != expected.filterSubject {
+			t.Errorf("consumer %d is %s with %v on %q, expected %s with %v on %q", i,
+				got.Name, got.AckPolicy, got.FilterSubject, expected.name, expected.ackPolicy, expected.filterSubject)
+		}
+	}
+}
+
+func TestApplyFansOutToEveryOutput(t *testing.T) {
+	cfg, err := ParseProcessorConfig([]byte(`
+sources:
+  - stream: purchases
+selectors:
+  - field: [amount]
+outputs:
+  - stream: totals
+    subject: totals.created
+  - stream: archive
+    subject: archive.totals
+    encoding:
+      format: msgpack
+fan_out:
+  policy: error
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewProcessorBuilder(nil)
+	sinks, err := cfg.Apply(builder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sinks) != 2 {
+		t.Fatalf("%d sinks, expected one for each output", len(sinks))
+	}
+	if errs := builder.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for fromID, dependentIDs := range builder.edges {
+		if _, ok := builder.processors[fromID].(*ColumnFilter); !ok {
+			continue
+		}
+		if len(dependentIDs) != 2 || dependentIDs[0] != sinks[0].ID() || dependentIDs[1] != sinks[1].ID() {
+			t.Errorf("column filter feeds %v, expected both sinks", dependentIDs)
+		}
+		return
+	}
+	t.Error("no column filter in the pipeline")
+}
@@ -22,8 +22,11 @@ func (cs *ConsoleSink) ID() string {
 	return cs.id.String()
 }
 
+func (cs *ConsoleSink) sink() {}
+
 func (cs *ConsoleSink) Add(ctx context.Context, event models.EventLike) error {
 	log.Println(event)
+	models.Done(event)
 	return nil
 }
 
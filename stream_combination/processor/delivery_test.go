@@ -0,0 +1,206 @@
+package processor
+
+import (
+	"context"
+	"stream_combination/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readEvent An event as a reader passes it on, counting its acks in `acks`
+func readEvent(at time.Time, data map[string]interface{}, acks *atomic.Int32) *models.Event {
+	event := models.NewEvent(at, data)
+	event.SetDelivery(models.NewDelivery(func() { acks.Add(1) }))
+	return event
+}
+
+func TestWhereFilterAcksDroppedEvents(t *testing.T) {
+	ctx := context.Background()
+	filter, _ := NewWhereFilter(func(event models.EventLike) bool { return event.GetString("keep") == "true" }, 4)
+	var kept, dropped atomic.Int32
+	if err := filter.Add(ctx, readEvent(time.Now(), map[string]interface{}{"keep": "true"}, &kept)); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.AddBatch(ctx, []models.EventLike{
+		readEvent(time.Now(), map[string]interface{}{"keep": "false"}, &dropped),
+		readEvent(time.Now(), map[string]interface{}{"keep": "false"}, &dropped),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := dropped.Load(); n != 2 {
+		t.Errorf("%d of the events filtered out were acked, expected 2", n)
+	}
+	if n := kept.Load(); n != 0 {
+		t.Error("the event passed on was acked before the sink was done with it")
+	}
+
+	sink := NewChannelSink(1)
+	if err := sink.Add(ctx, <-filter.Results(ctx, "test", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if n := kept.Load(); n != 1 {
+		t.Errorf("the event was acked %d times once it reached the sink, expected once", n)
+	}
+}
+
+func TestBroadcastAcksOnceEveryDependentIsDone(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SlowConsumerPolicy
+		events int
+		// readFirst Whether the first branch's dependent is done with what's left in it. The second's always is.
+		readFirst bool
+		acks      int32
+	}{
+		{"every branch done", SlowConsumerBlock, 1, true, 1},
+		// The first branch's buffer holds one event, so all but the newest are dropped from it
+		{"dropped from a slow branch", SlowConsumerDropOldest, 3, false, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			input := make(chan models.EventLike)
+			broadcast := NewBroadcast(input, 2, FanOutConfig{BufferSize: 1, Policy: test.policy})
+			broadcast.branches[1] = make(chan models.EventLike, test.events)
+			go broadcast.Run(ctx, make(chan error, 1))
+
+			var acks atomic.Int32
+			for i := 0; i < test.events; i++ {
+				input <- readEvent(time.Now(), map[string]interface{}{"n": i}, &acks)
+			}
+			close(input)
+			for event := range broadcast.Branch(1) {
+				models.Done(event)
+			}
+			if test.readFirst {
+				for event := range broadcast.Branch(0) {
+					models.Done(event)
+				}
+			}
+			if n := acks.Load(); n != test.acks {
+				t.Errorf("%d acks, expected %d", n, test.acks)
+			}
+		})
+	}
+}
+
+func TestJoinAcksOnceResultsAreDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	join := NewSlidingWindowJoin(10*time.Minute, keyPredicates(), 4)
+	now := time.Now()
+	var left, right, unmatched, tooOld atomic.Int32
+
+	// Waiting in the window, so done with once stored
+	if err := join.addEvent(ctx, readEvent(now, map[string]interface{}{"key": "a"}, &left), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := join.addEvent(ctx, readEvent(now, map[string]interface{}{"key": "b"}, &unmatched), true); err != nil {
+		t.Fatal(err)
+	}
+	if left.Load() != 1 || unmatched.Load() != 1 {
+		t.Errorf("events stored in the window were acked %d and %d times, expected once", left.Load(), unmatched.Load())
+	}
+	if err := join.addEvent(ctx, readEvent(now.Add(-time.Hour), map[string]interface{}{"key": "a"}, &tooOld), false); err == nil {
+		t.Error("expected an event too old for the window to fail")
+	}
+	if tooOld.Load() != 1 {
+		t.Error("an event too old for the window should be acked, as it's dropped")
+	}
+
+	if err := join.addEvent(ctx, readEvent(now, map[string]interface{}{"key": "a"}, &right), false); err != nil {
+		t.Fatal(err)
+	}
+	joined := <-join.resultsChan
+	if right.Load() != 0 {
+		t.Error("the matching event was acked before its result was done with")
+	}
+	models.Done(joined)
+	if right.Load() != 1 || left.Load() != 1 {
+		t.Errorf("once the result was done with, the sides were acked %d and %d times, expected once", left.Load(), right.Load())
+	}
+}
+
+func TestPartitionedJoinAcksEventsOfOtherInstances(t *testing.T) {
+	join := NewPartitionedJoin(time.Minute, keyPredicates(), 4, 4)
+	join.SetOwned(nil)
+	var acks atomic.Int32
+	if err := join.AddLeft(context.Background(), readEvent(time.Now(), map[string]interface{}{"key": "a"}, &acks)); err != nil {
+		t.Fatal(err)
+	}
+	if acks.Load() != 1 {
+		t.Error("an event of a partition another instance runs should be acked, as this instance is done with it")
+	}
+}
+
+func TestCheckpointedJoinAcksOnceCommitted(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	tests := []struct {
+		name string
+		// steps Run against a checkpointed join with a 10 minute window, which already holds a left event with
+		// key `a` at 1m, whose acks are counted
+		steps func(t *testing.T, join *SlidingWindowJoin)
+		acks  int32
+	}{
+		{"waiting", func(t *testing.T, join *SlidingWindowJoin) {}, 0},
+		{"checkpointed, not saved", func(t *testing.T, join *SlidingWindowJoin) { checkpoint(t, join) }, 0},
+		{"checkpoint saved", func(t *testing.T, join *SlidingWindowJoin) { checkpoint(t, join)() }, 1},
+		{"saved with a later checkpoint", func(t *testing.T, join *SlidingWindowJoin) {
+			checkpoint(t, join)
+			checkpoint(t, join)()
+		}, 1},
+		{"matched, result not done", func(t *testing.T, join *SlidingWindowJoin) {
+			add(t, join, models.NewEvent(at(2), map[string]interface{}{"key": "a"}), false)
+		}, 0},
+		{"matched, result done", func(t *testing.T, join *SlidingWindowJoin) {
+			add(t, join, models.NewEvent(at(2), map[string]interface{}{"key": "a"}), false)
+			models.Done(<-join.resultsChan)
+		}, 1},
+		{"matched once checkpointed, result not done", func(t *testing.T, join *SlidingWindowJoin) {
+			commit := checkpoint(t, join)
+			add(t, join, models.NewEvent(at(2), map[string]interface{}{"key": "a"}), false)
+			commit()
+		}, 0},
+		{"matched once checkpointed, result done", func(t *testing.T, join *SlidingWindowJoin) {
+			commit := checkpoint(t, join)
+			add(t, join, models.NewEvent(at(2), map[string]interface{}{"key": "a"}), false)
+			commit()
+			models.Done(<-join.resultsChan)
+		}, 1},
+		{"evicted", func(t *testing.T, join *SlidingWindowJoin) {
+			add(t, join, models.NewEvent(at(60), map[string]interface{}{"key": "b"}), false)
+		}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			join := NewSlidingWindowJoin(10*time.Minute, keyPredicates(), 4)
+			join.SetCheckpointed()
+			var acks atomic.Int32
+			add(t, join, readEvent(at(1), map[string]interface{}{"key": "a"}, &acks), true)
+			test.steps(t, join)
+			if n := acks.Load(); n != test.acks {
+				t.Errorf("%d acks, expected %d", n, test.acks)
+			}
+		})
+	}
+}
+
+func add(t *testing.T, join *SlidingWindowJoin, event models.EventLike, isLeft bool) {
+	t.Helper()
+	if err := join.addEvent(context.Background(), event, isLeft); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkpoint Take a checkpoint of `join`, returning its commit
+func checkpoint(t *testing.T, join *SlidingWindowJoin) func() {
+	t.Helper()
+	_, commit, err := join.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit
+}
@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Describable Processors that report their configuration, e.g. buffer sizes, for EXPLAIN
+type Describable interface {
+	Describe() map[string]string
+}
+
+func processorType(processor Processor) string {
+	typeName := strings.TrimPrefix(fmt.Sprintf("%T", processor), "*")
+	return strings.TrimPrefix(typeName, "processor.")
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func describeProcessor(id string, processor Processor) string {
+	return fmt.Sprintf("%s[%s]", processorType(processor), shortID(id))
+}
+
+// processorProperties Sorted `key=value` pairs of a processor's configuration, if it's Describable
+func processorProperties(processor Processor) []string {
+	describable, ok := processor.(Describable)
+	if !ok {
+		return nil
+	}
+	var properties []string
+	for key, value := range describable.Describe() {
+		properties = append(properties, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(properties)
+	return properties
+}
+
+func (pb *ProcessorBuilder) aliasesByProcessor() map[string][]string {
+	aliases := make(map[string][]string)
+	for alias, id := range pb.aliases {
+		aliases[id] = append(aliases[id], alias)
+	}
+	for _, names := range aliases {
+		sort.Strings(names)
+	}
+	return aliases
+}
+
+// String Describe each processor, in the order it was added, with its configuration and the processors it feeds.
+func (pb *ProcessorBuilder) String() string {
+	aliases := pb.aliasesByProcessor()
+
+	var sb strings.Builder
+	for _, id := range pb.order {
+		sb.WriteString(describeProcessor(id, pb.processors[id]))
+		if names := aliases[id]; len(names) > 0 {
+			fmt.Fprintf(&sb, " (%s)", strings.Join(names, ", "))
+		}
+		sb.WriteString("\n")
+		for _, property := range processorProperties(pb.processors[id]) {
+			fmt.Fprintf(&sb, "    %s\n", property)
+		}
+		sides := pb.edgeSides(id)
+		for i, dependentID := range pb.edges[id] {
+			fmt.Fprintf(&sb, "  -> %s", describeProcessor(dependentID, pb.processors[dependentID]))
+			if sides[i] != "" {
+				fmt.Fprintf(&sb, " (%s)", sides[i])
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// DOT The processor DAG in Graphviz DOT format
+func (pb *ProcessorBuilder) DOT() string {
+	aliases := pb.aliasesByProcessor()
+
+	var sb strings.Builder
+	sb.WriteString("digraph query {\n")
+	sb.WriteString("  rankdir=LR;\n")
+	sb.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	for _, id := range pb.order {
+		lines := []string{describeProcessor(id, pb.processors[id])}
+		if names := aliases[id]; len(names) > 0 {
+			lines = append(lines, "alias="+strings.Join(names, ", "))
+		}
+		lines = append(lines, processorProperties(pb.processors[id])...)
+		fmt.Fprintf(&sb, "  %q [label=%q];\n", shortID(id), strings.Join(lines, "\n"))
+	}
+	for _, id := range pb.order {
+		sides := pb.edgeSides(id)
+		for i, dependentID := range pb.edges[id] {
+			if sides[i] != "" {
+				fmt.Fprintf(&sb, "  %q -> %q [label=%q];\n", shortID(id), shortID(dependentID), sides[i])
+				continue
+			}
+			fmt.Fprintf(&sb, "  %q -> %q;\n", shortID(id), shortID(dependentID))
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
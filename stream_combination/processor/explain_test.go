@@ -0,0 +1,100 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// explainedJoin A self-join of users, filtered and selected, with short IDs so the output is predictable
+func explainedJoin(t *testing.T) *ProcessorBuilder {
+	builder := NewProcessorBuilder(nil)
+	builder.SetBufferSize(10)
+	for _, alias := range []string{"u", "v"} {
+		reader, err := NewSubjectReader(nil, "users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder.AddProcessor(alias+"-reader", reader)
+		builder.AddAlias(alias, alias+"-reader")
+	}
+	builder.AddDualProcessor("join", builder.NewWindowJoin(time.Hour, nil), "u-reader", "v-reader")
+	where, _ := NewWhereFilter(nil, builder.BufferSize())
+	builder.AddProcessor("where", where, "join")
+	columns, _ := NewAliasedColumnFilter([]string{"u.name", "v.name"}, []string{"name", "friend"}, builder.BufferSize())
+	builder.AddProcessor("columns", columns, "where")
+	builder.AddProcessor("sink", NewChannelSink(builder.BufferSize()), "columns")
+	return builder
+}
+
+func TestExplainProcessors(t *testing.T) {
+	expected := `SubjectReader[u-reader] (u)
+    deliver_policy=` + jetstream.DeliverAllPolicy.String() + `
+    format=json
+    stream=users
+  -> SlidingWindowJoin[join] (left)
+SubjectReader[v-reader] (v)
+    deliver_policy=` + jetstream.DeliverAllPolicy.String() + `
+    format=json
+    stream=users
+  -> SlidingWindowJoin[join] (right)
+SlidingWindowJoin[join]
+    bucket_size=5m0s
+    buckets=19
+    buffer_size=10
+    join_keys=0
+    window=1h0m0s
+  -> WhereFilter[where]
+WhereFilter[where]
+    buffer_size=10
+  -> ColumnFilter[columns]
+ColumnFilter[columns]
+    buffer_size=10
+    columns=u.name AS name, v.name AS friend
+  -> ChannelSink[sink]
+ChannelSink[sink]
+    buffer_size=10
+`
+	if got := explainedJoin(t).String(); got != expected {
+		t.Errorf("explained as\n%s\nexpected\n%s", got, expected)
+	}
+}
+
+func TestExplainSelfJoinSides(t *testing.T) {
+	builder := NewProcessorBuilder(nil)
+	reader, _ := NewSubjectReader(nil, "users")
+	builder.AddProcessor("reader", reader)
+	builder.AddDualProcessor("join", builder.NewWindowJoin(time.Hour, nil), "reader", "reader")
+	// One reader feeding both sides of a join has an edge to each
+	expected := `digraph query {
+  rankdir=LR;
+  node [shape=box, fontname="monospace"];
+  "reader" [label="SubjectReader[reader]\ndeliver_policy=` + jetstream.DeliverAllPolicy.String() + `\nformat=json\nstream=users"];
+  "join" [label="SlidingWindowJoin[join]\nbucket_size=5m0s\nbuckets=19\nbuffer_size=50\njoin_keys=0\nwindow=1h0m0s"];
+  "reader" -> "join" [label="left"];
+  "reader" -> "join" [label="right"];
+}
+`
+	if got := builder.DOT(); got != expected {
+		t.Errorf("DOT\n%s\nexpected\n%s", got, expected)
+	}
+}
+
+func TestExplainDOT(t *testing.T) {
+	got := explainedJoin(t).DOT()
+	expected := []string{
+		`"u-reader" [label="SubjectReader[u-reader]\nalias=u\n`,
+		`"columns" [label="ColumnFilter[columns]\nbuffer_size=10\ncolumns=u.name AS name, v.name AS friend"];`,
+		`"u-reader" -> "join" [label="left"];`,
+		`"v-reader" -> "join" [label="right"];`,
+		`"join" -> "where";`,
+		`"columns" -> "sink";`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(got, line) {
+			t.Errorf("DOT\n%s\nexpected it to contain %s", got, line)
+		}
+	}
+}
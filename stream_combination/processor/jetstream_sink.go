@@ -0,0 +1,170 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"stream_combination/codec"
+	"stream_combination/models"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// JetStreamSink publishes each event to `StreamOutput.Subject`, encoded as `StreamOutput.Encoding`. The subject
+// can be a template filled in from each row, with rows that can't fill it in published to the fallback subject.
+// Each row has a Nats-Msg-Id that's the same when it's reprocessed, so replays are dropped by the stream's
+// duplicate window.
+type JetStreamSink struct {
+	id      uuid.UUID
+	js      jetstream.JetStream
+	output  StreamOutput
+	subject *SubjectTemplate
+	encoder codec.Encoder
+}
+
+func NewJetStreamSink(js jetstream.JetStream, output StreamOutput) (*JetStreamSink, error) {
+	encoder, err := codec.NewEncoder(output.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("output %s: %w", output.Subject, err)
+	}
+	subject, err := ParseSubjectTemplate(output.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("output: %w", err)
+	}
+	if output.FallbackSubject != "" {
+		if err := validateSubject(output.FallbackSubject); err != nil {
+			return nil, fmt.Errorf("output fallback subject %s: %w", output.FallbackSubject, err)
+		}
+	}
+	return &JetStreamSink{
+		id:      uuid.New(),
+		js:      js,
+		output:  output,
+		subject: subject,
+		encoder: encoder,
+	}, nil
+}
+
+// EnsureStream creates or updates the output stream, if one is configured.
+func (jss *JetStreamSink) EnsureStream(ctx context.Context) error {
+	if jss.output.Stream == "" {
+		return nil
+	}
+	_, err := jss.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       jss.output.Stream,
+		Subjects:   jss.streamSubjects(),
+		MaxAge:     jss.output.MaxAge,
+		MaxMsgs:    jss.output.MaxMsgs,
+		Duplicates: jss.output.DuplicateWindow,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create output stream %s: %w", jss.output.Stream, err)
+	}
+	return nil
+}
+
+// streamSubjects Every subject the sink can publish to. The fallback is left out when the template covers it,
+// as a stream's subjects can't overlap.
+func (jss *JetStreamSink) streamSubjects() []string {
+	pattern := jss.subject.Pattern()
+	subjects := []string{pattern}
+	if jss.output.FallbackSubject != "" && !subjectMatches(pattern, jss.output.FallbackSubject) {
+		subjects = append(subjects, jss.output.FallbackSubject)
+	}
+	return subjects
+}
+
+// subjectMatches Whether `subject` is one of the subjects of `pattern`, which only has `*` wildcards
+func subjectMatches(pattern string, subject string) bool {
+	patternTokens, tokens := strings.Split(pattern, "."), strings.Split(subject, ".")
+	if len(patternTokens) != len(tokens) {
+		return false
+	}
+	for i, token := range patternTokens {
+		if token != "*" && token != tokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (jss *JetStreamSink) ID() string {
+	return jss.id.String()
+}
+
+func (jss *JetStreamSink) sink() {}
+
+func (jss *JetStreamSink) Describe() map[string]string {
+	properties := map[string]string{
+		"subject":      jss.output.Subject,
+		"content_type": jss.encoder.ContentType(),
+	}
+	if jss.output.Stream != "" {
+		properties["stream"] = jss.output.Stream
+	}
+	if jss.output.FallbackSubject != "" {
+		properties["fallback_subject"] = jss.output.FallbackSubject
+	}
+	properties["msg_id"] = "origin"
+	if jss.output.MsgID != "" {
+		properties["msg_id"] = jss.output.MsgID
+	}
+	return properties
+}
+
+// Add Publish a row, acking the messages it came from once it's stored. Rows that can't be encoded, or routed
+// to a subject, are dropped, and their messages acked, as they'd fail again if redelivered; those that fail to
+// publish are redelivered.
+func (jss *JetStreamSink) Add(ctx context.Context, event models.EventLike) error {
+	data, err := jss.encoder.Encode(event)
+	if err != nil {
+		models.Done(event)
+		return fmt.Errorf("error encoding event: %w", err)
+	}
+	subject, err := jss.subject.Render(event)
+	if err != nil {
+		if jss.output.FallbackSubject == "" {
+			models.Done(event)
+			return err
+		}
+		subject = jss.output.FallbackSubject
+	}
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set("Content-Type", jss.encoder.ContentType())
+	if id := jss.msgID(event); id != "" {
+		msg.Header.Set(jetstream.MsgIDHeader, id)
+	}
+	for key, value := range jss.output.Headers {
+		msg.Header.Set(key, value)
+	}
+	if _, err := jss.js.PublishMsg(ctx, msg); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", subject, err)
+	}
+	models.Done(event)
+	return nil
+}
+
+// msgID The row's MsgID column or, by default, the stream sequences it was computed from. Rows without either
+// aren't deduplicated.
+func (jss *JetStreamSink) msgID(event models.EventLike) string {
+	if jss.output.MsgID == "" {
+		return models.OriginOf(event)
+	}
+	value := event.GetField(jss.output.MsgID)
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func (jss *JetStreamSink) Results(ctx context.Context, consumerID string, errorCh chan<- error) <-chan models.EventLike {
+	messageCh := make(chan models.EventLike)
+	return messageCh
+}
+
+func (jss *JetStreamSink) Close() error {
+	return nil
+}
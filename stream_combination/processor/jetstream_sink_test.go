@@ -0,0 +1,53 @@
+package processor
+
+import (
+	"context"
+	"stream_combination/models"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestJetStreamSinkMsgID(t *testing.T) {
+	read := func(stream string, sequence uint64, data map[string]interface{}) *models.Event {
+		return models.NewEventWithMetadata(time.Now(), data, &models.Metadata{Subject: stream, Stream: stream, Sequence: sequence})
+	}
+	order := read("orders", 7, map[string]interface{}{"order_id": 42})
+	tests := []struct {
+		name  string
+		msgID string // the MSG_ID column, or "" to use the origin
+		event models.EventLike
+		// expected The Nats-Msg-Id header, or "" if the row isn't deduplicated
+		expected string
+	}{
+		{"origin", "", order, "orders:7"},
+		{"origin of a join", "", models.NewJoinEvent(time.Now(), order, read("users", 3, nil)), "orders:7+users:3"},
+		{"not read from NATS", "", models.NewEvent(time.Now(), map[string]interface{}{"order_id": 42}), ""},
+		{"column", "order_id", order, "42"},
+		// The column replaces the origin, even when the row doesn't have it
+		{"missing column", "invoice_id", order, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			sink, err := NewJetStreamSink(js, StreamOutput{Subject: "out", MsgID: test.msgID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Add(context.Background(), test.event); err != nil {
+				t.Fatal(err)
+			}
+			if len(js.published) != 1 {
+				t.Fatalf("published %d messages", len(js.published))
+			}
+			if got := js.published[0].Header.Get(jetstream.MsgIDHeader); got != test.expected {
+				t.Errorf("%s %q, expected %q", jetstream.MsgIDHeader, got, test.expected)
+			}
+			// The same row reprocessed is published with the same ID, for the stream to drop
+			if again := sink.msgID(test.event); again != test.expected {
+				t.Errorf("ID %q when reprocessed, expected %q", again, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"stream_combination/models"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PartitionedJoin A SlidingWindowJoin split into partitions by hashing the join key. Each partition is a join with
+// its own window and event loop, so partitions join in parallel without sharing state.
+type PartitionedJoin struct {
+	id          uuid.UUID
+	partitions  []*joinPartition
+	resultsChan chan models.EventLike
+	closeOnce   sync.Once
+}
+
+type joinPartition struct {
+	join  *SlidingWindowJoin
+	owned bool // false if another instance runs the partition
+}
+
+func NewPartitionedJoin(windowDuration time.Duration, equiJoinPreds []EquiJoinPredicate, bufferSize int, partitions int) *PartitionedJoin {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	if partitions <= 0 {
+		partitions = 1
+	}
+	pj := &PartitionedJoin{
+		id:          uuid.New(),
+		partitions:  make([]*joinPartition, partitions),
+		resultsChan: make(chan models.EventLike, bufferSize),
+	}
+	for i := range pj.partitions {
+		join := NewSlidingWindowJoin(windowDuration, equiJoinPreds, bufferSize)
+		// Every partition emits to the join's results, which are closed once all partitions have stopped
+		join.resultsChan = pj.resultsChan
+		pj.partitions[i] = &joinPartition{join: join, owned: true}
+	}
+	return pj
+}
+
+// SetOwned Only join the events of `partitions`, dropping those whose keys are in partitions other instances run.
+func (pj *PartitionedJoin) SetOwned(partitions []int) {
+	for _, partition := range pj.partitions {
+		partition.owned = false
+	}
+	for _, i := range partitions {
+		if i >= 0 && i < len(pj.partitions) {
+			pj.partitions[i].owned = true
+		}
+	}
+}
+
+// SetCheckpointed Hold on to the messages of events waiting in the partitions until a checkpoint covers them
+func (pj *PartitionedJoin) SetCheckpointed() {
+	for _, partition := range pj.partitions {
+		partition.join.SetCheckpointed()
+	}
+}
+
+func (pj *PartitionedJoin) SetAliases(left string, right string) {
+	for _, partition := range pj.partitions {
+		partition.join.SetAliases(left, right)
+	}
+}
+
+// Span How far back in time the partitions' windows keep events
+func (pj *PartitionedJoin) Span() time.Duration {
+	return pj.partitions[0].join.Span()
+}
+
+func (pj *PartitionedJoin) ID() string {
+	return pj.id.String()
+}
+
+func (pj *PartitionedJoin) Describe() map[string]string {
+	properties := pj.partitions[0].join.Describe()
+	properties["partitions"] = strconv.Itoa(len(pj.partitions))
+	var owned []string
+	for i, partition := range pj.partitions {
+		if partition.owned {
+			owned = append(owned, strconv.Itoa(i))
+		}
+	}
+	if len(owned) < len(pj.partitions) {
+		properties["owned_partitions"] = strings.Join(owned, ",")
+	}
+	return properties
+}
+
+func (pj *PartitionedJoin) Buffer() (int, int) {
+	return len(pj.resultsChan), cap(pj.resultsChan)
+}
+
+func (pj *PartitionedJoin) AddLeft(ctx context.Context, event models.EventLike) error {
+	return pj.route(ctx, event, true)
+}
+
+func (pj *PartitionedJoin) AddRight(ctx context.Context, event models.EventLike) error {
+	return pj.route(ctx, event, false)
+}
+
+// route Send an event to the partition of its join key, so matching events from both sides meet in one window
+func (pj *PartitionedJoin) route(ctx context.Context, event models.EventLike, isLeft bool) error {
+	// The partitions share their predicates, so any of them can compute the key
+	key := pj.partitions[0].join.getCompositeKey(event, isLeft)
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	partition := pj.partitions[hash.Sum32()%uint32(len(pj.partitions))]
+	if !partition.owned {
+		// Joined by the instance that owns the partition, from its own copy of the message
+		models.Done(event)
+		return nil
+	}
+	return partition.join.send(ctx, sideEvent{event: event, isLeft: isLeft})
+}
+
+// Results Start the event loop of each partition, joining its events until the join is flushed or `ctx` ends.
+func (pj *PartitionedJoin) Results(ctx context.Context, consumerID string, errorCh chan<- error) <-chan models.EventLike {
+	for _, partition := range pj.partitions {
+		partition.join.start(ctx)
+	}
+	return pj.resultsChan
+}
+
+// drain Stop the partitions once they've joined the events already routed to them
+func (pj *PartitionedJoin) drain() {
+	for _, partition := range pj.partitions {
+		partition.join.drain()
+	}
+}
+
+// Flush Join the events already routed to each partition, then flush each partition's window.
+func (pj *PartitionedJoin) Flush(ctx context.Context) error {
+	for _, partition := range pj.partitions {
+		if err := partition.join.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close Close the results, once both inputs have closed.
+func (pj *PartitionedJoin) Close() error {
+	pj.drain()
+	pj.closeOnce.Do(func() { close(pj.resultsChan) })
+	return nil
+}
@@ -2,10 +2,15 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
+	"sort"
+	"strconv"
 	"stream_combination/models"
+	"sync"
+	"time"
 
 	"github.com/nats-io/nats.go/jetstream"
 )
@@ -27,16 +32,72 @@ type DualInputProcessor interface {
 	AddRight(ctx context.Context, event models.EventLike) error
 }
 
+// Source Processors that read events from outside the pipeline, and so have no inputs
+type Source interface {
+	Processor
+	source()
+}
+
+// Sink Processors that write events out of the pipeline, and so have no dependents
+type Sink interface {
+	MessageProcessor
+	sink()
+}
+
+// DefaultBufferSize The number of events each processor buffers for its dependents, unless configured
+const DefaultBufferSize = 50
+
+// Buffered Processors that buffer their results. A full buffer blocks the processors feeding it, and so on
+// back to the SubjectReaders, which stop fetching from JetStream.
+type Buffered interface {
+	Buffer() (length int, capacity int)
+}
+
+// BufferUsage How full a processor's buffer is. Buffers that stay full show where a pipeline is saturated.
+type BufferUsage struct {
+	Processor string `json:"processor"`
+	Length    int    `json:"length"`
+	Capacity  int    `json:"capacity"`
+}
+
+// Aliased Dual-input processors whose results qualify fields by the aliases of their inputs, e.g. `u.user_id` for
+// a join of a source aliased `u`
+type Aliased interface {
+	SetAliases(left string, right string)
+}
+
+// Flusher Processors with state, e.g. windows, that's flushed to their dependents or a checkpoint on Stop
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
 type StreamProcessor struct {
 	inputs     map[string][]<-chan models.EventLike
+	batches    map[string][]<-chan []models.EventLike // in place of inputs in batch mode
 	Processors map[string]Processor
+	ctx        context.Context // cancelled to force the pipeline to stop
+	cancel     context.CancelFunc
+	stopOnce   sync.Once
+	drained    chan struct{} // closed once every processor with inputs has been closed
 }
 
 type ProcessorBuilder struct {
-	JetStream  jetstream.JetStream
-	aliases    map[string]string // Aliases => ProcessorID
-	processors map[string]Processor
-	edges      map[string][]string // processor_id -> [dependents]
+	JetStream   jetstream.JetStream
+	aliases     map[string]string // Aliases => ProcessorID
+	processors  map[string]Processor
+	order       []string             // processor IDs in the order they were added
+	edges       map[string][]string  // processor_id -> [dependents]
+	locations   map[string]string    // processor_id -> where it was defined, e.g. `line 1:15`
+	dualInputs  map[string][2]string // processor_id -> [left, right] dependencies of a DualInputProcessor
+	newSink     func() MessageProcessor
+	fanOut      FanOutConfig
+	batch       BatchConfig
+	bufferSize  int
+	parallelism int
+	partitions  []int  // the join partitions this pipeline runs, or nil for all of them
+	consumer    string // prefix of the durable consumers shared with other instances, or "" for consumers of its own
+	// checkpointInterval How often the pipeline is checkpointed, or 0 if it isn't
+	checkpointInterval time.Duration
 }
 
 func NewProcessorBuilder(js jetstream.JetStream) *ProcessorBuilder {
@@ -45,95 +106,400 @@ func NewProcessorBuilder(js jetstream.JetStream) *ProcessorBuilder {
 		aliases:    make(map[string]string), // Aliases => ProcessorID
 		processors: make(map[string]Processor),
 		edges:      make(map[string][]string),
+		locations:  make(map[string]string),
+		dualInputs: make(map[string][2]string),
+	}
+}
+
+// SetSinkFactory Replace the ConsoleSink queries end in by default.
+func (pb *ProcessorBuilder) SetSinkFactory(newSink func() MessageProcessor) {
+	pb.newSink = newSink
+}
+
+// NewSink A sink for the end of a query, as configured by SetSinkFactory.
+func (pb *ProcessorBuilder) NewSink() MessageProcessor {
+	if pb.newSink == nil {
+		sink := NewConsoleSink()
+		return &sink
+	}
+	return pb.newSink()
+}
+
+// SetBufferSize Set how many events each processor of the query buffers, and each source fetches ahead.
+func (pb *ProcessorBuilder) SetBufferSize(size int) {
+	pb.bufferSize = size
+}
+
+// BufferSize The buffer size for processors added to the builder
+func (pb *ProcessorBuilder) BufferSize() int {
+	if pb.bufferSize <= 0 {
+		return DefaultBufferSize
+	}
+	return pb.bufferSize
+}
+
+// SetParallelism Set how many partitions joins are split into by join key, each joined by its own goroutine.
+func (pb *ProcessorBuilder) SetParallelism(n int) {
+	pb.parallelism = n
+}
+
+// Parallelism The number of partitions for joins added to the builder
+func (pb *ProcessorBuilder) Parallelism() int {
+	if pb.parallelism <= 0 {
+		return 1
+	}
+	return pb.parallelism
+}
+
+// SetPartitions Only run `partitions` of each join, leaving events with keys in the others to other instances.
+func (pb *ProcessorBuilder) SetPartitions(partitions []int) {
+	pb.partitions = partitions
+}
+
+// SetSharedConsumer Read sources through durable consumers named `prefix-source`, shared with other instances
+// running the same query, so each message is processed by one of them.
+func (pb *ProcessorBuilder) SetSharedConsumer(prefix string) {
+	pb.consumer = prefix
+}
+
+// SharedConsumer The durable consumer of a source, or "" if sources have consumers of their own
+func (pb *ProcessorBuilder) SharedConsumer(source string) string {
+	if pb.consumer == "" {
+		return ""
+	}
+	return pb.consumer + "-" + source
+}
+
+// Readers The sources of the pipeline, keyed by their position in the DAG, which is the same each time the same
+// query is built
+func (pb *ProcessorBuilder) Readers() map[string]*SubjectReader {
+	readers := make(map[string]*SubjectReader)
+	for i, id := range pb.order {
+		if reader, ok := pb.processors[id].(*SubjectReader); ok {
+			readers[strconv.Itoa(i)] = reader
+		}
+	}
+	return readers
+}
+
+// WindowSpan How far back in time the longest window of the pipeline keeps events, or 0 if it has none
+func (pb *ProcessorBuilder) WindowSpan() time.Duration {
+	var span time.Duration
+	for _, id := range pb.order {
+		if window, ok := pb.processors[id].(interface{ Span() time.Duration }); ok {
+			span = max(span, window.Span())
+		}
+	}
+	return span
+}
+
+// SetCheckpointInterval Checkpoint the pipeline every `interval`, so that joins hold on to the messages of the
+// events in their windows until a checkpoint covers them, and sources leave those messages unacked that long.
+func (pb *ProcessorBuilder) SetCheckpointInterval(interval time.Duration) {
+	pb.checkpointInterval = interval
+}
+
+// CheckpointInterval How often the pipeline is checkpointed, or 0 if it isn't
+func (pb *ProcessorBuilder) CheckpointInterval() time.Duration {
+	return pb.checkpointInterval
+}
+
+// NewWindowJoin A join of events within `window` of each other, partitioned by join key if Parallelism is above 1
+// or only some partitions are run.
+func (pb *ProcessorBuilder) NewWindowJoin(window time.Duration, equiJoinPreds []EquiJoinPredicate) DualInputProcessor {
+	if pb.Parallelism() > 1 || pb.partitions != nil {
+		join := NewPartitionedJoin(window, equiJoinPreds, pb.BufferSize(), pb.Parallelism())
+		if pb.partitions != nil {
+			join.SetOwned(pb.partitions)
+		}
+		if pb.checkpointInterval > 0 {
+			join.SetCheckpointed()
+		}
+		return join
 	}
+	join := NewSlidingWindowJoin(window, equiJoinPreds, pb.BufferSize())
+	if pb.checkpointInterval > 0 {
+		join.SetCheckpointed()
+	}
+	return join
+}
+
+// SetFanOut Configure how events are copied to the dependents of processors that have more than one. Only pipeline
+// files with several outputs fan out, as each source of an NSQL query has a reader of its own.
+func (pb *ProcessorBuilder) SetFanOut(cfg FanOutConfig) {
+	pb.fanOut = cfg
+}
+
+// SetBatch Pass events between processors in batches, as configured, rather than one at a time.
+func (pb *ProcessorBuilder) SetBatch(cfg BatchConfig) {
+	pb.batch = cfg
 }
 
 func (pb *ProcessorBuilder) AddAlias(alias string, processorId string) {
 	pb.aliases[alias] = processorId
 }
 
+// aliasOf The alias of a processor, or "" if it has none
+func (pb *ProcessorBuilder) aliasOf(id string) string {
+	for alias, processorID := range pb.aliases {
+		if processorID == id {
+			return alias
+		}
+	}
+	return ""
+}
+
+// SetLocation Record where a processor was defined, e.g. `line 1:15` of a query, for errors about it
+func (pb *ProcessorBuilder) SetLocation(id string, location string) {
+	pb.locations[id] = location
+}
+
 func (pb *ProcessorBuilder) AddProcessor(id string, processor MessageProcessor, dependencies ...string) {
+	pb.order = append(pb.order, id)
 	pb.processors[id] = processor
 	for _, depID := range dependencies {
 		pb.edges[depID] = append(pb.edges[depID], id)
 	}
 }
 
-func (pb *ProcessorBuilder) AddDualProcessor(id string, dualProcessor DualInputProcessor, dependencies ...string) {
+// AddDualProcessor Add a processor fed by `left` through AddLeft and `right` through AddRight. They may be the
+// same processor, e.g. for a self-join.
+func (pb *ProcessorBuilder) AddDualProcessor(id string, dualProcessor DualInputProcessor, left string, right string) {
+	pb.order = append(pb.order, id)
 	pb.processors[id] = dualProcessor
-	for _, depID := range dependencies {
-		pb.edges[depID] = append(pb.edges[depID], id)
+	pb.dualInputs[id] = [2]string{left, right}
+	if aliased, ok := dualProcessor.(Aliased); ok {
+		aliased.SetAliases(pb.aliasOf(left), pb.aliasOf(right))
+	}
+	for _, depID := range []string{left, right} {
+		if depID != "" {
+			pb.edges[depID] = append(pb.edges[depID], id)
+		}
+	}
+}
+
+// Indexes of the inputs of a DualInputProcessor
+const (
+	leftInput  = 0
+	rightInput = 1
+)
+
+// bindInput Add `input` from `fromID` to the inputs of `toID`, at the side it was bound to if `toID` has two.
+func bindInput[T any](pb *ProcessorBuilder, inputs map[string][]<-chan T, fromID string, toID string, input <-chan T) {
+	sides, isDual := pb.dualInputs[toID]
+	if !isDual {
+		inputs[toID] = append(inputs[toID], input)
+		return
+	}
+	if inputs[toID] == nil {
+		inputs[toID] = make([]<-chan T, 2)
+	}
+	// A self-join has two edges from the same processor, the first of which is bound to the left
+	side := rightInput
+	if sides[leftInput] == fromID && inputs[toID][leftInput] == nil {
+		side = leftInput
 	}
+	inputs[toID][side] = input
 }
 
+// Build Wire each processor to its dependents, once Validate finds nothing wrong with the DAG. In batch mode, every
+// edge carries batches, which processors that aren't BatchSources have collected from their results.
 func (pb *ProcessorBuilder) Build(ctx context.Context, errorCh chan<- error) (*StreamProcessor, error) {
+	if errs := pb.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
 	inputs := make(map[string][]<-chan models.EventLike)
+	batches := make(map[string][]<-chan []models.EventLike)
+	ctx, cancel := context.WithCancel(ctx)
 
 	for fromID, dependentIDs := range pb.edges {
-		if _, exists := pb.processors[fromID]; !exists {
-			return nil, fmt.Errorf("processor %s not found", fromID)
-		}
 		fromProcessor := pb.processors[fromID]
-		for _, toID := range dependentIDs {
-			if _, exists := pb.processors[toID]; !exists {
-				return nil, fmt.Errorf("dependent processor %s not found", toID)
+		if len(dependentIDs) == 1 {
+			consumerID := fmt.Sprintf("%s-to-%s", fromID, dependentIDs[0])
+			if pb.batch.enabled() {
+				bindInput(pb, batches, fromID, dependentIDs[0], batchResults(ctx, fromProcessor, consumerID, pb.batch, errorCh))
+			} else {
+				bindInput(pb, inputs, fromID, dependentIDs[0], fromProcessor.Results(ctx, consumerID, errorCh))
+			}
+			continue
+		}
+		// Processors share one results channel between callers, so it's read once and copied to every dependent
+		fanOut := pb.fanOut
+		if fanOut.BufferSize <= 0 {
+			fanOut.BufferSize = pb.BufferSize()
+		}
+		broadcast := NewBroadcast(fromProcessor.Results(ctx, fromID+"-fanout", errorCh), len(dependentIDs), fanOut)
+		go broadcast.Run(ctx, errorCh)
+		for i, toID := range dependentIDs {
+			if pb.batch.enabled() {
+				bindInput(pb, batches, fromID, toID, collect(ctx, broadcast.Branch(i), pb.batch))
+			} else {
+				bindInput(pb, inputs, fromID, toID, broadcast.Branch(i))
 			}
-			consumerID := fmt.Sprintf("%s-to-%s", fromID, toID)
-			resultsChan := fromProcessor.Results(ctx, consumerID, errorCh)
-			inputs[toID] = append(inputs[toID], resultsChan)
 		}
 	}
 
 	return &StreamProcessor{
 		inputs:     inputs,
+		batches:    batches,
 		Processors: pb.processors,
+		ctx:        ctx,
+		cancel:     cancel,
+		drained:    make(chan struct{}),
 	}, nil
 }
 
+// Saturation How full the buffer of each buffered processor is, in a stable order
+func (sp *StreamProcessor) Saturation() []BufferUsage {
+	var usage []BufferUsage
+	for id, processor := range sp.Processors {
+		if buffered, ok := processor.(Buffered); ok {
+			length, capacity := buffered.Buffer()
+			usage = append(usage, BufferUsage{Processor: describeProcessor(id, processor), Length: length, Capacity: capacity})
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Processor < usage[j].Processor })
+	return usage
+}
+
+// Run Feed each processor from its inputs until the pipeline has drained after Stop, or `ctx` ends. A processor
+// is closed once all of its inputs have closed, so closing the sources drains the DAG in topological order.
 func (sp *StreamProcessor) Run(ctx context.Context) error {
-	// Start consuming from inputs and feeding to processors
-	for processorID, inputChannels := range sp.inputs {
-		processor := sp.Processors[processorID]
-
-		if dualProc, ok := processor.(DualInputProcessor); ok {
-			slog.Info("Adding DualInputProcessor", "id", processorID)
-			if len(inputChannels) != 2 && len(inputChannels) != 0 {
-				return fmt.Errorf("dual processor %s requires exactly 2 inputs, got %d", processorID, len(inputChannels))
-			}
-			for i, inputChan := range inputChannels {
-				slog.Info("Submitting processor", "inputChan", inputChan, "isLeft", i == 0)
-				go func(isLeft bool, ch <-chan models.EventLike) {
-					for event := range ch {
-						if isLeft {
-							if err := dualProc.AddLeft(ctx, event); err != nil {
-								log.Printf("Error processing event: %v", err)
-							}
-						} else {
-							if err := dualProc.AddRight(ctx, event); err != nil {
-								log.Printf("Error processing event: %v", err)
-							}
-						}
+	// Ending `ctx` forces the pipeline to stop, as Stop does on timeout
+	defer context.AfterFunc(ctx, sp.cancel)()
+	ctx = sp.ctx
+
+	var drained sync.WaitGroup
+	for processorID, processor := range sp.Processors {
+		inputChannels := sp.inputs[processorID]
+		batchChannels := sp.batches[processorID]
+		if !sp.hasInputs(processorID) {
+			// Sources are closed by Stop
+			continue
+		}
+		add := addFunc(processor)
+		if add == nil {
+			continue
+		}
+		addBatch := addBatchFunc(processor, add)
+		slog.Info("Starting processor", "id", processorID, "inputs", len(inputChannels)+len(batchChannels))
+
+		drained.Add(1)
+		var inputsDone sync.WaitGroup
+		for i, inputChan := range inputChannels {
+			inputsDone.Add(1)
+			go func(input int, ch <-chan models.EventLike) {
+				defer inputsDone.Done()
+				for event := range ch {
+					if err := add(ctx, input, event); err != nil {
+						log.Printf("Error processing event: %v", err)
 					}
-				}(i == 0, inputChan) // Initial input is 'left', second input is 'right'.
-			}
-		} else if singleProc, ok := processor.(MessageProcessor); ok {
-			slog.Info("Adding SingleInputProcessor", "id", processorID)
-			// Fan-in: merge all input channels for this processor
-			go func(proc MessageProcessor, inputs []<-chan models.EventLike) {
-				for _, inputChan := range inputs {
-					go func(ch <-chan models.EventLike) {
-						for event := range ch {
-							if err := proc.Add(ctx, event); err != nil {
-								log.Printf("Error processing event: %v", err)
-							}
-						}
-					}(inputChan)
 				}
-			}(singleProc, inputChannels)
+			}(i, inputChan)
+		}
+		for i, batchChan := range batchChannels {
+			inputsDone.Add(1)
+			go func(input int, ch <-chan []models.EventLike) {
+				defer inputsDone.Done()
+				for batch := range ch {
+					if err := addBatch(ctx, input, batch); err != nil {
+						log.Printf("Error processing batch: %v", err)
+					}
+				}
+			}(i, batchChan)
+		}
+		go func(id string, proc Processor) {
+			defer drained.Done()
+			inputsDone.Wait()
+			sp.closeProcessor(ctx, id, proc)
+		}(processorID, processor)
+	}
+	go func() {
+		drained.Wait()
+		close(sp.drained)
+	}()
+
+	select {
+	case <-sp.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addFunc How events from the i-th input reach a processor. A DualInputProcessor's inputs are bound by
+// AddDualProcessor, left then right.
+func addFunc(processor Processor) func(ctx context.Context, input int, event models.EventLike) error {
+	switch proc := processor.(type) {
+	case DualInputProcessor:
+		return func(ctx context.Context, input int, event models.EventLike) error {
+			if input == leftInput {
+				return proc.AddLeft(ctx, event)
+			}
+			return proc.AddRight(ctx, event)
+		}
+	case MessageProcessor:
+		return func(ctx context.Context, input int, event models.EventLike) error {
+			return proc.Add(ctx, event)
+		}
+	default:
+		return nil
+	}
+}
+
+// addBatchFunc How batches from the i-th input reach a processor: whole if it's a BatchProcessor, or else an
+// event at a time through `add`.
+func addBatchFunc(processor Processor, add func(ctx context.Context, input int, event models.EventLike) error) func(ctx context.Context, input int, batch []models.EventLike) error {
+	if proc, ok := processor.(BatchProcessor); ok {
+		return func(ctx context.Context, input int, batch []models.EventLike) error {
+			return proc.AddBatch(ctx, batch)
 		}
 	}
+	return func(ctx context.Context, input int, batch []models.EventLike) error {
+		var errs []error
+		for _, event := range batch {
+			if err := add(ctx, input, event); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
 
-	// Keep running until context cancelled
-	<-ctx.Done()
-	return ctx.Err()
+// hasInputs Whether a processor is fed by others, i.e. isn't a source
+func (sp *StreamProcessor) hasInputs(id string) bool {
+	return len(sp.inputs[id])+len(sp.batches[id]) > 0
+}
+
+// closeProcessor Flush a processor's state, if it has any, and close it, which closes its results for its dependents.
+func (sp *StreamProcessor) closeProcessor(ctx context.Context, id string, processor Processor) {
+	if flusher, ok := processor.(Flusher); ok {
+		if err := flusher.Flush(ctx); err != nil {
+			slog.Error("Error flushing processor", "id", id, "error", err)
+		}
+	}
+	if err := processor.Close(); err != nil {
+		slog.Error("Error closing processor", "id", id, "error", err)
+	}
+}
+
+// Stop Stop reading from the sources, and wait for the events already read to drain through the pipeline to its
+// sinks. If `ctx` ends first, the pipeline is cancelled, dropping the events still in flight.
+func (sp *StreamProcessor) Stop(ctx context.Context) error {
+	defer sp.cancel()
+	sp.stopOnce.Do(func() {
+		for id, processor := range sp.Processors {
+			if !sp.hasInputs(id) {
+				if err := processor.Close(); err != nil {
+					slog.Error("Error closing source", "id", id, "error", err)
+				}
+			}
+		}
+	})
+	select {
+	case <-sp.drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("pipeline didn't drain before stopping: %w", ctx.Err())
+	}
 }
@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"stream_combination/models"
+	"testing"
+	"time"
+)
+
+func TestBufferSize(t *testing.T) {
+	builder := NewProcessorBuilder(nil)
+	if size := builder.BufferSize(); size != DefaultBufferSize {
+		t.Errorf("buffer size %d, expected the default %d", size, DefaultBufferSize)
+	}
+	builder.SetBufferSize(5)
+	if size := builder.BufferSize(); size != 5 {
+		t.Errorf("buffer size %d, expected 5", size)
+	}
+}
+
+// bufferedProcessor A processor whose Add blocks once its buffer is full
+type bufferedProcessor interface {
+	MessageProcessor
+	Buffered
+}
+
+func TestFullBuffersBlock(t *testing.T) {
+	tests := []struct {
+		name      string
+		processor func(size int) bufferedProcessor
+	}{
+		{"where filter", func(size int) bufferedProcessor {
+			where, _ := NewWhereFilter(func(models.EventLike) bool { return true }, size)
+			return where
+		}},
+		{"column filter", func(size int) bufferedProcessor {
+			columns, _ := NewAliasedColumnFilter([]string{"id"}, []string{"id"}, size)
+			return columns
+		}},
+		{"channel sink", func(size int) bufferedProcessor { return NewChannelSink(size) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			processor := test.processor(2)
+			event := models.NewEvent(time.Now(), map[string]interface{}{"id": 1})
+			for i := 0; i < 2; i++ {
+				if err := processor.Add(context.Background(), event); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if length, capacity := processor.Buffer(); length != 2 || capacity != 2 {
+				t.Errorf("buffer %d/%d, expected 2/2", length, capacity)
+			}
+
+			// The buffer is full, so the processor feeding it waits rather than dropping or queueing the event
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if err := processor.Add(ctx, event); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("add to a full buffer returned %v, expected it to block until the context ended", err)
+			}
+
+			var results <-chan models.EventLike
+			if sink, ok := processor.(*ChannelSink); ok {
+				results = sink.Events()
+			} else {
+				results = processor.Results(context.Background(), "test", nil)
+			}
+			<-results
+			if err := processor.Add(context.Background(), event); err != nil {
+				t.Errorf("add once the buffer had room failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestSaturation(t *testing.T) {
+	where, _ := NewWhereFilter(func(models.EventLike) bool { return true }, 4)
+	if err := where.Add(context.Background(), models.NewEvent(time.Now(), nil)); err != nil {
+		t.Fatal(err)
+	}
+	reader, _ := NewSubjectReader(nil, "orders")
+	pipeline := &StreamProcessor{Processors: map[string]Processor{
+		"where":  where,
+		"sink":   NewChannelSink(3),
+		"reader": reader,
+	}}
+	// Sources don't buffer, so they're left out
+	expected := []BufferUsage{
+		{Processor: "ChannelSink[sink]", Length: 0, Capacity: 3},
+		{Processor: "WhereFilter[where]", Length: 1, Capacity: 4},
+	}
+	if got := pipeline.Saturation(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("saturation %+v, expected %+v", got, expected)
+	}
+}
@@ -5,76 +5,306 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"strconv"
+	"stream_combination/codec"
 	"stream_combination/models"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
 type SubjectReader struct {
-	id      uuid.UUID
-	js      jetstream.JetStream
-	subject string
+	id       uuid.UUID
+	js       jetstream.JetStream
+	subject  string
+	consumer ConsumerConfig
+	format   string
+	decoder  codec.Decoder
+	// negotiator Chooses the decoder from the contentTypeHeader of each message, when that's configured
+	negotiator        *codec.Negotiator
+	contentTypeHeader string
+	// deadLetterSubject Where messages that can't be decoded are published, with the reason in a header
+	deadLetterSubject string
+	// maxPending How many messages are fetched ahead of the pipeline. Once they're buffered, fetching waits.
+	maxPending int
+	// ackWait How long a message may go unacked before it's redelivered, the server's default when zero
+	ackWait       time.Duration
+	maxAckPending int           // 0 for the server's default, -1 for no limit
+	stopping      chan struct{} // closed by Close
+	stopOnce      sync.Once
+	// startTime Where reading starts, in place of the consumer's deliver policy, unless zero
+	startTime time.Time
+	// progress guards inflight and latest, the times of the messages read that the pipeline isn't done with,
+	// by stream sequence, and of the newest message read
+	progress sync.Mutex
+	inflight map[uint64]time.Time
+	latest   time.Time
 }
 
 func NewSubjectReader(js jetstream.JetStream, subject string) (*SubjectReader, error) {
+	decoder, _ := codec.NewDecoder(codec.Config{})
 	return &SubjectReader{
-		id:      uuid.New(),
-		js:      js,
-		subject: subject,
+		id:       uuid.New(),
+		js:       js,
+		subject:  subject,
+		format:   "json",
+		decoder:  decoder,
+		stopping: make(chan struct{}),
+		inflight: make(map[uint64]time.Time),
 	}, nil
 }
 
+// NewSubjectReaderFromSource reads from a StreamSource, applying its consumer settings.
+func NewSubjectReaderFromSource(js jetstream.JetStream, source StreamSource) (*SubjectReader, error) {
+	consumer := source.Consumer
+	if consumer.FilterSubject == "" && len(consumer.FilterSubjects) == 0 {
+		consumer.FilterSubject = source.Subject
+	}
+	reader := &SubjectReader{
+		id:       uuid.New(),
+		js:       js,
+		subject:  source.Stream,
+		consumer: consumer,
+		stopping: make(chan struct{}),
+		inflight: make(map[uint64]time.Time),
+	}
+	if err := reader.SetEncoding(source.Encoding); err != nil {
+		return nil, err
+	}
+	reader.SetDeadLetterSubject(source.DeadLetterSubject)
+	return reader, nil
+}
+
+// SetEncoding Decode payloads in the given format, rather than as JSON.
+func (sr *SubjectReader) SetEncoding(cfg codec.Config) error {
+	decoder, err := codec.NewDecoder(cfg)
+	if err != nil {
+		return fmt.Errorf("source %s: %w", sr.subject, err)
+	}
+	sr.decoder = decoder
+	sr.format = strings.ToLower(cfg.Format)
+	if sr.format == "" {
+		sr.format = "json"
+	}
+	sr.negotiator = nil
+	sr.contentTypeHeader = cfg.ContentTypeHeader
+	if cfg.ContentTypeHeader != "" {
+		sr.negotiator, err = codec.NewNegotiator(cfg)
+		if err != nil {
+			return fmt.Errorf("source %s: %w", sr.subject, err)
+		}
+	}
+	return nil
+}
+
+// SetFilterSubjects Only read messages on `subjects` of the stream, which may have wildcards.
+func (sr *SubjectReader) SetFilterSubjects(subjects ...string) {
+	sr.consumer.FilterSubject = ""
+	sr.consumer.FilterSubjects = subjects
+	if len(subjects) == 1 {
+		sr.consumer.FilterSubject = subjects[0]
+		sr.consumer.FilterSubjects = nil
+	}
+}
+
+// SetDurable Read through the durable consumer `name`, which keeps its position across restarts and is shared
+// by every reader using it.
+func (sr *SubjectReader) SetDurable(name string) {
+	sr.consumer.Name = name
+	sr.consumer.Durable = true
+}
+
+// SetDeadLetterSubject Publish messages that can't be decoded to `subject`, rather than only logging them.
+func (sr *SubjectReader) SetDeadLetterSubject(subject string) {
+	sr.deadLetterSubject = subject
+}
+
+// SetMaxPending Fetch at most `n` messages ahead of the pipeline, rather than the client's default of 500.
+func (sr *SubjectReader) SetMaxPending(n int) {
+	sr.maxPending = n
+}
+
+// SetCheckpointed Leave messages unacked until a checkpoint taken every `interval` covers them, rather than
+// redelivering them after the server's ack wait, or stopping once the server's limit of unacked messages is
+// reached, while they wait.
+func (sr *SubjectReader) SetCheckpointed(interval time.Duration) {
+	sr.ackWait = 2 * interval
+	sr.maxAckPending = -1
+}
+
+// SetStartTime Start reading at the first message stored at or after `t`, rather than where the consumer's
+// deliver policy says.
+func (sr *SubjectReader) SetStartTime(t time.Time) {
+	sr.startTime = t
+}
+
+// Watermark The time of the oldest message read that the pipeline isn't done with yet, or of the newest message
+// read if it's done with every one, so every message after the watermark is yet to be done with. Zero until a
+// message is read.
+func (sr *SubjectReader) Watermark() time.Time {
+	sr.progress.Lock()
+	defer sr.progress.Unlock()
+	watermark := sr.latest
+	for _, t := range sr.inflight {
+		if t.Before(watermark) {
+			watermark = t
+		}
+	}
+	return watermark
+}
+
+func (sr *SubjectReader) decode(msg jetstream.Msg) (map[string]interface{}, error) {
+	if sr.negotiator != nil {
+		return sr.negotiator.Decode(msg.Headers().Get(sr.contentTypeHeader), msg.Data())
+	}
+	return sr.decoder.Decode(msg.Data())
+}
+
+// reject Send a message that couldn't be decoded to the dead letter subject, with the error and its original subject.
+// It's an error if the dead letter isn't stored, including when there's no dead letter subject.
+func (sr *SubjectReader) reject(ctx context.Context, msg jetstream.Msg, reason error) error {
+	if sr.deadLetterSubject == "" {
+		return fmt.Errorf("no dead letter subject: %w", reason)
+	}
+	deadLetter := nats.NewMsg(sr.deadLetterSubject)
+	deadLetter.Data = msg.Data()
+	for key, values := range msg.Headers() {
+		deadLetter.Header[key] = values
+	}
+	deadLetter.Header.Set("Nsql-Error", reason.Error())
+	deadLetter.Header.Set("Nsql-Subject", msg.Subject())
+	if _, err := sr.js.PublishMsg(ctx, deadLetter); err != nil {
+		return fmt.Errorf("failed to publish dead letter to %s: %w", sr.deadLetterSubject, err)
+	}
+	return nil
+}
+
 func (sr *SubjectReader) ID() string {
 	return sr.id.String()
 }
 
+func (sr *SubjectReader) source() {}
+
+func (sr *SubjectReader) Describe() map[string]string {
+	properties := map[string]string{
+		"stream":         sr.subject,
+		"deliver_policy": sr.consumer.DeliverPolicy.String(),
+		"format":         sr.format,
+	}
+	if sr.consumer.FilterSubject != "" {
+		properties["filter_subject"] = sr.consumer.FilterSubject
+	}
+	if len(sr.consumer.FilterSubjects) > 0 {
+		properties["filter_subjects"] = strings.Join(sr.consumer.FilterSubjects, ", ")
+	}
+	if sr.consumer.Durable {
+		properties["durable"] = sr.consumer.Name
+	}
+	if sr.contentTypeHeader != "" {
+		properties["content_type_header"] = sr.contentTypeHeader
+	}
+	if sr.deadLetterSubject != "" {
+		properties["dead_letter_subject"] = sr.deadLetterSubject
+	}
+	if sr.maxPending > 0 {
+		properties["max_pending"] = strconv.Itoa(sr.maxPending)
+	}
+	if sr.ackWait > 0 {
+		properties["ack_wait"] = sr.ackWait.String()
+	}
+	if !sr.startTime.IsZero() {
+		properties["start_time"] = sr.startTime.Format(time.RFC3339)
+	}
+	return properties
+}
+
 func (sr *SubjectReader) Add(ctx context.Context, event models.EventLike) error {
 	return nil
 }
 
+func (sr *SubjectReader) consumerConfig(consumerID string) jetstream.ConsumerConfig {
+	cfg := jetstream.ConsumerConfig{
+		Name:          fmt.Sprintf("%s-%s-reader", sr.subject, consumerID),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		DeliverPolicy: sr.consumer.DeliverPolicy,
+		MaxDeliver:    sr.consumer.MaxDeliver,
+		FilterSubject: sr.consumer.FilterSubject,
+		AckWait:       sr.ackWait,
+		MaxAckPending: sr.maxAckPending,
+	}
+	if len(sr.consumer.FilterSubjects) > 0 {
+		// A consumer can't have both
+		cfg.FilterSubject = ""
+		cfg.FilterSubjects = sr.consumer.FilterSubjects
+	}
+	if !sr.startTime.IsZero() {
+		cfg.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		cfg.OptStartTime = &sr.startTime
+	}
+	if sr.consumer.AckPolicy != nil {
+		cfg.AckPolicy = *sr.consumer.AckPolicy
+	}
+	if sr.consumer.Name != "" {
+		cfg.Name = fmt.Sprintf("%s-%s", sr.consumer.Name, consumerID)
+	}
+	if sr.consumer.Durable {
+		// Durable consumers must keep their name across restarts
+		cfg.Name = sr.consumer.Name
+		cfg.Durable = sr.consumer.Name
+	}
+	return cfg
+}
+
 func (sr *SubjectReader) Results(ctx context.Context, consumerID string, errorCh chan<- error) <-chan models.EventLike {
 	messageCh := make(chan models.EventLike)
+	// closed is set, under mu, once messageCh is closed, so a late callback can't send on it
+	var mu sync.Mutex
+	closed := false
 
 	go func() {
-		defer close(messageCh)
-
-		// Create consumer on-demand with unique ID
-		consumer, err := sr.js.CreateOrUpdateConsumer(ctx, sr.subject, jetstream.ConsumerConfig{
-			Name:      fmt.Sprintf("%s-%s-reader", sr.subject, consumerID),
-			AckPolicy: jetstream.AckExplicitPolicy,
-		})
-		slog.Info("Consumer created for subject", "subject", sr.subject)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error creating consumer", "error", err)
-			select {
-			case errorCh <- fmt.Errorf("failed to create consumer: %w", err):
-			case <-ctx.Done():
-			}
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+			closed = true
+			close(messageCh)
+		}()
+
+		consumer, ok := sr.createConsumer(ctx, consumerID, errorCh)
+		if !ok {
 			return
 		}
 
+		var opts []jetstream.PullConsumeOpt
+		if sr.maxPending > 0 {
+			opts = append(opts, jetstream.PullMaxMessages(sr.maxPending))
+		}
+		// The callback blocks while the pipeline is full, so at most maxPending messages wait behind it
 		iter, err := consumer.Consume(func(msg jetstream.Msg) {
-			meta, err := msg.Metadata()
-			if err != nil {
-				log.Println("Error getting metadata:", err)
-				msg.Ack()
+			event, ok := sr.event(ctx, msg)
+			if !ok {
 				return
 			}
-			// TODO: Currently we assume all messages are JSON serialised
-			event, err := models.NewEventFromJson(meta.Timestamp, msg.Data())
-			if err != nil {
-				log.Printf("error creating Event: %v", err)
-				msg.Ack()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if closed {
+				sr.untrack(event)
 				return
 			}
-			msg.Ack()
+			// Messages are acked once the pipeline is done with their events, e.g. once their rows are published.
+			// Those it isn't done with when it stops are redelivered.
 			select {
 			case messageCh <- event:
+			case <-sr.stopping:
+				sr.untrack(event)
 			case <-ctx.Done():
-				return
+				sr.untrack(event)
 			}
-		})
+		}, opts...)
 
 		if err != nil {
 			select {
@@ -84,14 +314,148 @@ func (sr *SubjectReader) Results(ctx context.Context, consumerID string, errorCh
 			return
 		}
 
-		<-ctx.Done()
+		select {
+		case <-ctx.Done():
+		case <-sr.stopping:
+		}
 		iter.Stop()
 	}()
 
 	return messageCh
 }
 
+// BatchResults The messages read in batches fetched from the consumer, each of up to `cfg.Size` messages and
+// fetched once it's full or cfg.MaxLatency has passed. Like those of Results, messages are acked once the pipeline
+// is done with their events.
+func (sr *SubjectReader) BatchResults(ctx context.Context, consumerID string, cfg BatchConfig, errorCh chan<- error) <-chan []models.EventLike {
+	batchCh := make(chan []models.EventLike)
+	go func() {
+		defer close(batchCh)
+		consumer, ok := sr.createConsumer(ctx, consumerID, errorCh)
+		if !ok {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sr.stopping:
+				return
+			default:
+			}
+			msgs, err := consumer.Fetch(cfg.Size, jetstream.FetchMaxWait(cfg.maxLatency()))
+			if err != nil {
+				select {
+				case errorCh <- fmt.Errorf("failed to fetch: %w", err):
+				case <-ctx.Done():
+				}
+				return
+			}
+			var batch []models.EventLike
+			for msg := range msgs.Messages() {
+				if event, ok := sr.event(ctx, msg); ok {
+					batch = append(batch, event)
+				}
+			}
+			if err := msgs.Error(); err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "Error fetching messages", "stream", sr.subject, "error", err)
+			}
+			if len(batch) == 0 {
+				continue
+			}
+			// Messages that aren't in the pipeline when it stops are redelivered
+			select {
+			case batchCh <- batch:
+			case <-sr.stopping:
+				sr.untrack(batch...)
+				return
+			case <-ctx.Done():
+				sr.untrack(batch...)
+				return
+			}
+		}
+	}()
+	return batchCh
+}
+
+// createConsumer Create the consumer the reader reads through, reporting the error to `errorCh` if it can't
+func (sr *SubjectReader) createConsumer(ctx context.Context, consumerID string, errorCh chan<- error) (jetstream.Consumer, bool) {
+	// Create consumer on-demand with unique ID
+	consumer, err := sr.js.CreateOrUpdateConsumer(ctx, sr.subject, sr.consumerConfig(consumerID))
+	slog.Info("Consumer created for subject", "subject", sr.subject)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating consumer", "error", err)
+		select {
+		case errorCh <- fmt.Errorf("failed to create consumer: %w", err):
+		case <-ctx.Done():
+		}
+		return nil, false
+	}
+	return consumer, true
+}
+
+// event Decode a message into an event, which acks the message once the pipeline is done with it. Messages that
+// can't be decoded are dead lettered and acked, or redelivered if they can't be, and `ok` is false.
+func (sr *SubjectReader) event(ctx context.Context, msg jetstream.Msg) (event *models.Event, ok bool) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		log.Println("Error getting metadata:", err)
+		msg.Ack()
+		return nil, false
+	}
+	data, err := sr.decode(msg)
+	if err != nil {
+		// Only a message that's dead lettered is done with, others are redelivered
+		if err := sr.reject(ctx, msg, err); err != nil {
+			slog.WarnContext(ctx, "Error decoding message", "stream", sr.subject, "error", err)
+			msg.Nak()
+			return nil, false
+		}
+		msg.Ack()
+		return nil, false
+	}
+	event = models.NewEventWithMetadata(meta.Timestamp, data, &models.Metadata{
+		Subject:          msg.Subject(),
+		Stream:           meta.Stream,
+		Sequence:         meta.Sequence.Stream,
+		ConsumerSequence: meta.Sequence.Consumer,
+		NumDelivered:     meta.NumDelivered,
+		Timestamp:        meta.Timestamp,
+		Headers:          msg.Headers(),
+	})
+	sequence := meta.Sequence.Stream
+	sr.progress.Lock()
+	sr.inflight[sequence] = meta.Timestamp
+	if meta.Timestamp.After(sr.latest) {
+		sr.latest = meta.Timestamp
+	}
+	sr.progress.Unlock()
+	event.SetDelivery(models.NewDelivery(func() {
+		sr.progress.Lock()
+		delete(sr.inflight, sequence)
+		sr.progress.Unlock()
+		if err := msg.Ack(); err != nil {
+			slog.Warn("Error acking message", "stream", meta.Stream, "sequence", meta.Sequence.Stream, "error", err)
+		}
+	}))
+	return event, true
+}
+
+// untrack Forget the messages of events that never reached the pipeline, which are left unacked to be redelivered,
+// so the watermark moves past them.
+func (sr *SubjectReader) untrack(events ...models.EventLike) {
+	sr.progress.Lock()
+	defer sr.progress.Unlock()
+	for _, event := range events {
+		if meta := models.MetadataOf(event); meta != nil {
+			delete(sr.inflight, meta.Sequence)
+		}
+	}
+}
+
+// Close Stop reading, closing the results once the message being delivered, if any, is in the pipeline or
+// left to be redelivered.
 func (sr *SubjectReader) Close() error {
-	// Cancellation happens within `Results`
+	sr.stopOnce.Do(func() { close(sr.stopping) })
 	return nil
 }
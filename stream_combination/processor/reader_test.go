@@ -0,0 +1,226 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"stream_combination/codec"
+	"stream_combination/models"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeMsg A message of a stream, as a consumer delivers it. Without data, it's a JSON object.
+type fakeMsg struct {
+	jetstream.Msg
+	sequence  uint64
+	timestamp time.Time
+	data      []byte
+	header    nats.Header
+	acked     bool
+	naked     bool
+}
+
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{
+		Sequence:  jetstream.SequencePair{Stream: m.sequence, Consumer: m.sequence},
+		Stream:    "orders",
+		Timestamp: m.timestamp,
+	}, nil
+}
+
+func (m *fakeMsg) Data() []byte {
+	if m.data == nil {
+		return []byte(`{"id": 1}`)
+	}
+	return m.data
+}
+
+func (m *fakeMsg) Headers() nats.Header {
+	if m.header == nil {
+		return nats.Header{}
+	}
+	return m.header
+}
+
+func (m *fakeMsg) Subject() string { return "orders" }
+func (m *fakeMsg) Ack() error      { m.acked = true; return nil }
+func (m *fakeMsg) Nak() error      { m.naked = true; return nil }
+
+// fakeJetStream Records the messages published, failing every publish if `err` is set
+type fakeJetStream struct {
+	jetstream.JetStream
+	published []*nats.Msg
+	err       error
+}
+
+func (js *fakeJetStream) PublishMsg(_ context.Context, msg *nats.Msg, _ ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	if js.err != nil {
+		return nil, js.err
+	}
+	js.published = append(js.published, msg)
+	return &jetstream.PubAck{}, nil
+}
+
+func TestSubjectReaderWatermark(t *testing.T) {
+	reader, _ := NewSubjectReader(nil, "orders")
+	if !reader.Watermark().IsZero() {
+		t.Fatal("watermark before any message was read")
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var msgs []*fakeMsg
+	var finish []func() // finish the i-th event
+	for i := 0; i < 3; i++ {
+		msg := &fakeMsg{sequence: uint64(i + 1), timestamp: start.Add(time.Duration(i) * time.Minute)}
+		event, ok := reader.event(context.Background(), msg)
+		if !ok {
+			t.Fatal("message not decoded")
+		}
+		msgs = append(msgs, msg)
+		finish = append(finish, func() {
+			for _, d := range event.Deliveries() {
+				d.Done()
+			}
+		})
+	}
+
+	steps := []struct {
+		name     string
+		done     int // the event the pipeline is done with at this step
+		expected time.Time
+	}{
+		// The oldest message isn't done with, so reading must start there again
+		{"a later message done", 1, start},
+		{"the oldest done", 0, start.Add(2 * time.Minute)},
+		{"every message done", 2, start.Add(2 * time.Minute)},
+	}
+	if got := reader.Watermark(); !got.Equal(start) {
+		t.Errorf("watermark %v with nothing done, expected %v", got, start)
+	}
+	for _, step := range steps {
+		finish[step.done]()
+		if !msgs[step.done].acked {
+			t.Errorf("%s: message %d wasn't acked", step.name, step.done)
+		}
+		if got := reader.Watermark(); !got.Equal(step.expected) {
+			t.Errorf("%s: watermark %v, expected %v", step.name, got, step.expected)
+		}
+	}
+}
+
+func TestSubjectReaderForgetsDroppedEvents(t *testing.T) {
+	reader, _ := NewSubjectReader(nil, "orders")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	dropped := &fakeMsg{sequence: 1, timestamp: start}
+	read := &fakeMsg{sequence: 2, timestamp: start.Add(time.Minute)}
+	droppedEvent, _ := reader.event(context.Background(), dropped)
+	keptEvent, _ := reader.event(context.Background(), read)
+
+	// Stopping before the first event reached the pipeline, which finishes with the second
+	reader.untrack(droppedEvent)
+	models.Done(keptEvent)
+	if dropped.acked {
+		t.Error("a message whose event never reached the pipeline was acked, rather than left to be redelivered")
+	}
+	if got := reader.Watermark(); !got.Equal(read.timestamp) {
+		t.Errorf("watermark %v, expected it to move past the dropped message to %v", got, read.timestamp)
+	}
+}
+
+func TestSubjectReaderStartTime(t *testing.T) {
+	reader, _ := NewSubjectReader(nil, "orders")
+	if cfg := reader.consumerConfig("q"); cfg.DeliverPolicy != jetstream.DeliverAllPolicy || cfg.OptStartTime != nil {
+		t.Errorf("deliver policy %v from %v, expected all", cfg.DeliverPolicy, cfg.OptStartTime)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reader.SetStartTime(start)
+	cfg := reader.consumerConfig("q")
+	if cfg.DeliverPolicy != jetstream.DeliverByStartTimePolicy || cfg.OptStartTime == nil || !cfg.OptStartTime.Equal(start) {
+		t.Errorf("deliver policy %v from %v, expected by start time from %v", cfg.DeliverPolicy, cfg.OptStartTime, start)
+	}
+}
+
+func TestSubjectReaderDecoding(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        string
+		deadLetter  string
+		publishErr  error
+		// expected The fields of the event, or nil if the message isn't decoded
+		expected map[string]interface{}
+		acked    bool
+		naked    bool
+		// deadLettered Whether the message is published to the dead letter subject
+		deadLettered bool
+	}{
+		{"configured format without a content type", "", "1,book", "", nil,
+			map[string]interface{}{"id": "1", "item": "book"}, false, false, false},
+		{"format from the content type", "application/json", `{"id": 2}`, "", nil,
+			map[string]interface{}{"id": float64(2)}, false, false, false},
+		{"unknown content type dead lettered", "application/xml", "<order/>", "orders.dlq", nil, nil, true, false, true},
+		{"undecodable dead lettered", "application/json", "{", "orders.dlq", nil, nil, true, false, true},
+		// The message must not be lost, so it's redelivered
+		{"dead letter not published", "application/json", "{", "orders.dlq", errors.New("no responders"), nil, false, true, false},
+		{"no dead letter subject", "application/json", "{", "", nil, nil, false, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			js := &fakeJetStream{err: test.publishErr}
+			reader, err := NewSubjectReaderFromSource(js, StreamSource{
+				Stream:            "orders",
+				Encoding:          codec.Config{Format: "csv", Columns: []string{"id", "item"}, ContentTypeHeader: "Content-Type"},
+				DeadLetterSubject: test.deadLetter,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := &fakeMsg{sequence: 1, timestamp: time.Now(), data: []byte(test.data), header: nats.Header{}}
+			if test.contentType != "" {
+				msg.header.Set("Content-Type", test.contentType)
+			}
+
+			event, ok := reader.event(context.Background(), msg)
+			if test.expected == nil {
+				if ok {
+					t.Fatalf("decoded %v, expected the message to be rejected", event)
+				}
+			} else if !ok {
+				t.Fatal("message not decoded")
+			} else {
+				for field, value := range test.expected {
+					if got := event.GetField(field); got != value {
+						t.Errorf("%s decoded as %v, expected %v", field, got, value)
+					}
+				}
+			}
+			if msg.acked != test.acked || msg.naked != test.naked {
+				t.Errorf("acked %t and nacked %t, expected %t and %t", msg.acked, msg.naked, test.acked, test.naked)
+			}
+			if got := len(js.published) > 0; got != test.deadLettered {
+				t.Fatalf("dead lettered %t, expected %t", got, test.deadLettered)
+			}
+			if test.deadLettered {
+				deadLetter := js.published[0]
+				if deadLetter.Subject != test.deadLetter || string(deadLetter.Data) != test.data ||
+					deadLetter.Header.Get("Nsql-Subject") != "orders" || deadLetter.Header.Get("Nsql-Error") == "" ||
+					deadLetter.Header.Get("Content-Type") != test.contentType {
+					t.Errorf("dead letter %+v, expected the message with its subject and the error in headers", deadLetter)
+				}
+			}
+		})
+	}
+}
+
+func TestSubjectReaderMaxPending(t *testing.T) {
+	reader, _ := NewSubjectReader(nil, "orders")
+	if _, ok := reader.Describe()["max_pending"]; ok {
+		t.Error("max_pending described without being set")
+	}
+	reader.SetMaxPending(10)
+	if got := reader.Describe()["max_pending"]; got != "10" {
+		t.Errorf("max_pending %q, expected 10", got)
+	}
+}
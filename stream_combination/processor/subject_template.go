@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"stream_combination/models"
+	"strings"
+)
+
+// SubjectTemplate A subject with `{field}` placeholders filled in from each row, e.g. `purchases.{tenant}.{region}`.
+// Placeholders can name pseudo-columns too, e.g. `{_subject_token(2)}`.
+type SubjectTemplate struct {
+	template string
+	literals []string // literals[i] comes before fields[i], and the last literal after every field
+	fields   []string
+}
+
+func ParseSubjectTemplate(template string) (*SubjectTemplate, error) {
+	st := &SubjectTemplate{template: template}
+	rest := template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("subject %s: unclosed '{'", template)
+		}
+		field := strings.TrimSpace(rest[open+1 : open+end])
+		if field == "" {
+			return nil, fmt.Errorf("subject %s: empty placeholder", template)
+		}
+		st.literals = append(st.literals, rest[:open])
+		st.fields = append(st.fields, field)
+		rest = rest[open+end+1:]
+	}
+	if strings.ContainsRune(rest, '}') {
+		return nil, fmt.Errorf("subject %s: unmatched '}'", template)
+	}
+	st.literals = append(st.literals, rest)
+
+	// Placeholders are checked as they're rendered, so only the literal tokens are checked here
+	if err := validateSubject(st.join(func(string) string { return "x" })); err != nil {
+		return nil, fmt.Errorf("subject %s: %w", template, err)
+	}
+	return st, nil
+}
+
+// IsStatic Whether the template has no placeholders
+func (st *SubjectTemplate) IsStatic() bool {
+	return len(st.fields) == 0
+}
+
+func (st *SubjectTemplate) String() string {
+	return st.template
+}
+
+// Pattern The subjects the template can render, with each token that has a placeholder as `*`
+func (st *SubjectTemplate) Pattern() string {
+	tokens := strings.Split(st.join(func(field string) string { return "{" + field + "}" }), ".")
+	for i, token := range tokens {
+		if strings.ContainsRune(token, '{') {
+			tokens[i] = "*"
+		}
+	}
+	return strings.Join(tokens, ".")
+}
+
+// join The template with each placeholder replaced
+func (st *SubjectTemplate) join(replace func(field string) string) string {
+	var sb strings.Builder
+	for i, field := range st.fields {
+		sb.WriteString(st.literals[i])
+		sb.WriteString(replace(field))
+	}
+	sb.WriteString(st.literals[len(st.literals)-1])
+	return sb.String()
+}
+
+// Render The subject for a row. It's an error for a placeholder's field to be missing, or for its value not to
+// be a legal subject token.
+func (st *SubjectTemplate) Render(event models.EventLike) (string, error) {
+	if st.IsStatic() {
+		return st.template, nil
+	}
+	var sb strings.Builder
+	for i, field := range st.fields {
+		sb.WriteString(st.literals[i])
+		value := event.GetField(field)
+		if value == nil {
+			return "", fmt.Errorf("subject %s: %s is missing", st.template, field)
+		}
+		token := fmt.Sprint(value)
+		if err := validateToken(token); err != nil {
+			return "", fmt.Errorf("subject %s: %s value %q %w", st.template, field, token, err)
+		}
+		sb.WriteString(token)
+	}
+	sb.WriteString(st.literals[len(st.literals)-1])
+	return sb.String(), nil
+}
+
+func validateSubject(subject string) error {
+	for _, token := range strings.Split(subject, ".") {
+		if err := validateToken(token); err != nil {
+			return fmt.Errorf("token %q %w", token, err)
+		}
+	}
+	return nil
+}
+
+// validateToken Whether a value can be a token of a published subject
+func validateToken(token string) error {
+	if token == "" {
+		return errors.New("is empty")
+	}
+	for _, r := range token {
+		switch {
+		case r == '.':
+			return errors.New("contains '.'")
+		case r == '*' || r == '>':
+			return errors.New("contains a wildcard")
+		case r <= ' ' || r == 0x7f:
+			return errors.New("contains whitespace or a control character")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"stream_combination/models"
+	"testing"
+	"time"
+)
+
+func TestParseSubjectTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		pattern  string
+		static   bool
+		invalid  bool
+	}{
+		{"purchases", "purchases", true, false},
+		{"purchases.{tenant}.{region}", "purchases.*.*", false, false},
+		{"purchases.{ tenant }", "purchases.*", false, false},
+		// A placeholder within a token makes the whole token a wildcard
+		{"purchases.eu-{region}.all", "purchases.*.all", false, false},
+		{"{_subject_token(2)}.copy", "*.copy", false, false},
+		{"purchases.{tenant", "", false, true},
+		{"purchases.tenant}", "", false, true},
+		{"purchases.{}", "", false, true},
+		{"purchases..{tenant}", "", false, true},
+		{"purchases.*", "", false, true},
+		{"purchases.>", "", false, true},
+		{"purchases.{tenant}.", "", false, true},
+		{"purchases of.{tenant}", "", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			st, err := ParseSubjectTemplate(test.template)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("expected an error, got a template with pattern %s", st.Pattern())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.Pattern() != test.pattern {
+				t.Errorf("pattern %s, expected %s", st.Pattern(), test.pattern)
+			}
+			if st.IsStatic() != test.static {
+				t.Errorf("IsStatic() = %t, expected %t", st.IsStatic(), test.static)
+			}
+			if st.String() != test.template {
+				t.Errorf("String() = %s, expected the template", st.String())
+			}
+		})
+	}
+}
+
+func TestSubjectTemplateRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		data     map[string]interface{}
+		expected string
+		invalid  bool
+	}{
+		{"static", "purchases", nil, "purchases", false},
+		{"fields", "purchases.{tenant}.{region}", map[string]interface{}{"tenant": "acme", "region": "eu"}, "purchases.acme.eu", false},
+		{"within a token", "purchases.eu-{region}", map[string]interface{}{"region": "west"}, "purchases.eu-west", false},
+		{"number", "orders.{id}", map[string]interface{}{"id": float64(7)}, "orders.7", false},
+		{"missing field", "purchases.{tenant}", map[string]interface{}{}, "", true},
+		{"empty value", "purchases.{tenant}", map[string]interface{}{"tenant": ""}, "", true},
+		{"value with a dot", "purchases.{tenant}", map[string]interface{}{"tenant": "a.b"}, "", true},
+		{"value with a wildcard", "purchases.{tenant}", map[string]interface{}{"tenant": "*"}, "", true},
+		{"value with whitespace", "purchases.{tenant}", map[string]interface{}{"tenant": "a b"}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st, err := ParseSubjectTemplate(test.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subject, err := st.Render(models.NewEvent(time.Now(), test.data))
+			if test.invalid {
+				if err == nil {
+					t.Errorf("expected an error, rendered %s", subject)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != test.expected {
+				t.Errorf("rendered %s, expected %s", subject, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DAGError A processor wired into the DAG in a way the pipeline can't run
+type DAGError struct {
+	Processor string // e.g. WhereFilter[1b9d6bcd]
+	Location  string // e.g. line 1:15, from SetLocation
+	Message   string
+}
+
+func (e DAGError) Error() string {
+	if e.Location != "" {
+		return fmt.Sprintf("%s (%s): %s", e.Processor, e.Location, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Processor, e.Message)
+}
+
+type DAGErrors []DAGError
+
+func (errs DAGErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Validate Check the DAG for edges to processors that weren't added, cycles, processors whose events never reach
+// a sink, sources nothing reads, processors with the wrong number of inputs, and sinks with dependents.
+func (pb *ProcessorBuilder) Validate() DAGErrors {
+	var errs DAGErrors
+	addError := func(id string, format string, args ...interface{}) {
+		errs = append(errs, DAGError{Processor: pb.describe(id), Location: pb.locations[id], Message: fmt.Sprintf(format, args...)})
+	}
+
+	inputs := make(map[string]int)
+	for _, fromID := range pb.sortedEdges() {
+		if _, exists := pb.processors[fromID]; !exists {
+			addError(fromID, "has dependents but was never added")
+		}
+		for _, toID := range pb.edges[fromID] {
+			if _, exists := pb.processors[toID]; !exists {
+				addError(toID, "depends on %s but was never added", pb.describe(fromID))
+			}
+			inputs[toID]++
+		}
+	}
+
+	for _, cycle := range pb.cycles() {
+		names := make([]string, len(cycle))
+		for i, id := range cycle {
+			names[i] = pb.describe(id)
+		}
+		addError(cycle[0], "is part of a cycle: %s", strings.Join(names, " -> "))
+	}
+
+	reachesSink := pb.reachingSinks()
+	for _, id := range pb.order {
+		processor := pb.processors[id]
+		dependents := len(pb.edges[id])
+		_, isSource := processor.(Source)
+		_, isSink := processor.(Sink)
+		_, isDual := processor.(DualInputProcessor)
+
+		switch {
+		case isSink && dependents > 0:
+			addError(id, "is a sink, but has %d dependents", dependents)
+		case isSource && dependents == 0:
+			addError(id, "is a source, but nothing reads from it")
+		case !isSink && !reachesSink[id]:
+			addError(id, "has no path to a sink")
+		}
+
+		switch {
+		case isSource && inputs[id] > 0:
+			addError(id, "is a source, but has %d inputs", inputs[id])
+		case isDual:
+			for side, depID := range pb.dualInputs[id] {
+				if depID == "" {
+					addError(id, "has no %s input", sideNames[side])
+				}
+			}
+		case !isSource && inputs[id] == 0:
+			addError(id, "has no inputs")
+		}
+	}
+	return errs
+}
+
+var sideNames = [2]string{leftInput: "left", rightInput: "right"}
+
+// edgeSides The side of a DualInputProcessor each of a processor's edges is bound to, or "" for other dependents
+func (pb *ProcessorBuilder) edgeSides(id string) []string {
+	sides := make([]string, len(pb.edges[id]))
+	boundLeft := make(map[string]bool)
+	for i, toID := range pb.edges[id] {
+		inputs, isDual := pb.dualInputs[toID]
+		if !isDual {
+			continue
+		}
+		if inputs[leftInput] == id && !boundLeft[toID] {
+			sides[i] = sideNames[leftInput]
+			boundLeft[toID] = true
+		} else {
+			sides[i] = sideNames[rightInput]
+		}
+	}
+	return sides
+}
+
+func (pb *ProcessorBuilder) describe(id string) string {
+	processor, exists := pb.processors[id]
+	if !exists {
+		return fmt.Sprintf("processor[%s]", shortID(id))
+	}
+	return describeProcessor(id, processor)
+}
+
+// sortedEdges The processors with dependents, in the order they were added, then any that weren't added
+func (pb *ProcessorBuilder) sortedEdges() []string {
+	var ids, missing []string
+	for _, id := range pb.order {
+		if _, ok := pb.edges[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	for id := range pb.edges {
+		if _, exists := pb.processors[id]; !exists {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	return append(ids, missing...)
+}
+
+// cycles Each cycle found by a depth-first search, as the processors on it from the first one visited
+func (pb *ProcessorBuilder) cycles() [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var cycles [][]string
+
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		path = append(path, id)
+		for _, dependentID := range pb.edges[id] {
+			switch state[dependentID] {
+			case unvisited:
+				visit(dependentID)
+			case visiting:
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == dependentID {
+						cycle := append([]string{}, path[i:]...)
+						cycles = append(cycles, append(cycle, dependentID))
+						break
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+	}
+	for _, id := range pb.order {
+		if state[id] == unvisited {
+			visit(id)
+		}
+	}
+	return cycles
+}
+
+// reachingSinks The processors with a path to a sink, i.e. whose events are written out of the pipeline
+func (pb *ProcessorBuilder) reachingSinks() map[string]bool {
+	dependencies := make(map[string][]string)
+	for fromID, dependentIDs := range pb.edges {
+		for _, toID := range dependentIDs {
+			dependencies[toID] = append(dependencies[toID], fromID)
+		}
+	}
+
+	reaches := make(map[string]bool)
+	var queue []string
+	for id, processor := range pb.processors {
+		if _, ok := processor.(Sink); ok {
+			reaches[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, fromID := range dependencies[id] {
+			if !reaches[fromID] {
+				reaches[fromID] = true
+				queue = append(queue, fromID)
+			}
+		}
+	}
+	return reaches
+}
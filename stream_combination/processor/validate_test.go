@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"reflect"
+	"stream_combination/models"
+	"testing"
+	"time"
+)
+
+func TestValidateDAG(t *testing.T) {
+	reader := func() *SubjectReader {
+		reader, _ := NewSubjectReader(nil, "orders")
+		return reader
+	}
+	where := func() *WhereFilter {
+		where, _ := NewWhereFilter(func(models.EventLike) bool { return true }, 1)
+		return where
+	}
+	join := func() DualInputProcessor { return NewSlidingWindowJoin(time.Minute, nil, 1) }
+	sink := func() MessageProcessor { return NewChannelSink(1) }
+
+	tests := []struct {
+		name   string
+		build  func(pb *ProcessorBuilder)
+		errors []string
+	}{
+		{"valid", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddProcessor("where", where(), "reader")
+			pb.AddProcessor("sink", sink(), "where")
+		}, nil},
+		{"self-join", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddDualProcessor("join", join(), "reader", "reader")
+			pb.AddProcessor("sink", sink(), "join")
+		}, nil},
+		{"cycle", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddProcessor("a", where(), "reader", "b")
+			pb.AddProcessor("b", where(), "a")
+			pb.AddProcessor("sink", sink(), "b")
+		}, []string{"WhereFilter[a] (line 1:1): is part of a cycle: WhereFilter[a] -> WhereFilter[b] -> WhereFilter[a]"}},
+		{"unreachable sink", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddProcessor("where", where(), "reader")
+			pb.AddProcessor("sink", sink())
+		}, []string{"SubjectReader[reader]: has no path to a sink", "WhereFilter[where]: has no path to a sink", "ChannelSink[sink]: has no inputs"}},
+		{"unread source", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddProcessor("unread", reader())
+			pb.AddProcessor("sink", sink(), "reader")
+		}, []string{"SubjectReader[unread]: is a source, but nothing reads from it"}},
+		{"source with an input", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddProcessor("second", reader(), "reader")
+			pb.AddProcessor("sink", sink(), "second")
+		}, []string{"SubjectReader[second]: is a source, but has 1 inputs"}},
+		{"sink with a dependent", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddProcessor("sink", sink(), "reader")
+			pb.AddProcessor("second", sink(), "sink")
+		}, []string{"ChannelSink[sink]: is a sink, but has 1 dependents"}},
+		{"join with one input", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddDualProcessor("join", join(), "reader", "")
+			pb.AddProcessor("sink", sink(), "join")
+		}, []string{"SlidingWindowJoin[join]: has no right input"}},
+		{"join fed as a single input processor", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddProcessor("where", where(), "reader")
+			pb.edges["where"] = append(pb.edges["where"], "join")
+			pb.processors["join"] = join()
+			pb.order = append(pb.order, "join")
+			pb.AddProcessor("sink", sink(), "join")
+		}, []string{"SlidingWindowJoin[join]: has no left input", "SlidingWindowJoin[join]: has no right input"}},
+		{"processor that wasn't added", func(pb *ProcessorBuilder) {
+			pb.AddProcessor("reader", reader())
+			pb.AddProcessor("sink", sink(), "reader", "missing")
+		}, []string{"processor[missing]: has dependents but was never added"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder := NewProcessorBuilder(nil)
+			test.build(builder)
+			builder.SetLocation("a", "line 1:1")
+			var got []string
+			for _, err := range builder.Validate() {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, test.errors) {
+				t.Errorf("errors %q, expected %q", got, test.errors)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package processor
 
 import (
 	"context"
+	"strconv"
 	"stream_combination/models"
+	"sync"
 
 	"github.com/google/uuid"
 )
@@ -12,6 +14,8 @@ type WhereFilter struct {
 	id        uuid.UUID
 	cond      func(like models.EventLike) bool
 	messageCh chan models.EventLike
+	batchCh   chan []models.EventLike // set once the results are read in batches
+	closeOnce sync.Once
 }
 
 func NewWhereFilter(cond func(like models.EventLike) bool, bufferSize int) (*WhereFilter, error) {
@@ -29,18 +33,63 @@ func (wf *WhereFilter) ID() string {
 	return wf.id.String()
 }
 
+func (wf *WhereFilter) Describe() map[string]string {
+	return map[string]string{"buffer_size": strconv.Itoa(cap(wf.messageCh))}
+}
+
 func (wf *WhereFilter) Add(ctx context.Context, event models.EventLike) error {
-	if wf.cond(event) {
-		wf.messageCh <- event
+	if !wf.cond(event) {
+		// Filtered out, so the query is done with it
+		models.Done(event)
+		return nil
 	}
-	return nil
+	select {
+	case wf.messageCh <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AddBatch Filter a whole batch, passing on the events that meet the condition as one batch
+func (wf *WhereFilter) AddBatch(ctx context.Context, events []models.EventLike) error {
+	kept := make([]models.EventLike, 0, len(events))
+	for _, event := range events {
+		if wf.cond(event) {
+			kept = append(kept, event)
+		} else {
+			models.Done(event)
+		}
+	}
+	return sendBatch(ctx, kept, wf.batchCh, wf.messageCh)
+}
+
+func (wf *WhereFilter) Buffer() (int, int) {
+	if wf.batchCh != nil {
+		return len(wf.batchCh), cap(wf.batchCh)
+	}
+	return len(wf.messageCh), cap(wf.messageCh)
 }
 
 func (wf *WhereFilter) Results(ctx context.Context, consumerID string, errorCh chan<- error) <-chan models.EventLike {
 	return wf.messageCh
 }
 
+// BatchResults The results in batches, buffering as many events as Results would, in batches
+func (wf *WhereFilter) BatchResults(ctx context.Context, consumerID string, cfg BatchConfig, errorCh chan<- error) <-chan []models.EventLike {
+	if wf.batchCh == nil {
+		wf.batchCh = make(chan []models.EventLike, batchBuffer(cap(wf.messageCh), cfg))
+	}
+	return wf.batchCh
+}
+
+// Close Close the results, once every input has closed. Adding events afterwards panics.
 func (wf *WhereFilter) Close() error {
-	close(wf.messageCh)
+	wf.closeOnce.Do(func() {
+		close(wf.messageCh)
+		if wf.batchCh != nil {
+			close(wf.batchCh)
+		}
+	})
 	return nil
 }
@@ -2,12 +2,15 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/url"
 	"sort"
+	"strconv"
 	"stream_combination/models"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -59,15 +62,44 @@ func (tb *TimeBucket) String() string { // Pointer receiver
 		strings.Join(rightSummary, ", "))
 }
 
+// SlidingWindowJoin Join events of the left and right inputs with equal keys within a window of each other. The
+// window is only touched by the join's event loop, which AddLeft and AddRight hand events to, so inputs running on
+// separate goroutines don't need to lock it.
 type SlidingWindowJoin struct {
 	id             uuid.UUID
 	timeBuckets    []*TimeBucket
 	windowDuration time.Duration
 	bucketSize     time.Duration
-	numBuckets     int
+	numBuckets     int // the most buckets kept; older ones are dropped as the window slides
 	equiJoinPreds  []EquiJoinPredicate
+	aliases        [2]string // of the left and right inputs, set on each result
 	resultsChan    chan models.EventLike
 	bufferSize     int
+	closeOnce      sync.Once
+	events         chan sideEvent // both inputs, in the order they're handed to the loop
+	calls          chan func()    // run on the loop, between events
+	stopped        chan struct{}  // closed once the loop has stopped
+	lifecycle      sync.Mutex     // guards started and running
+	started        bool
+	running        bool
+	drainOnce      sync.Once
+	sending        sync.RWMutex // held by senders while they hand an event to the loop, and by drain to stop them
+	closed         bool         // set once drained, after which events are refused
+	// checkpointed Whether the window is checkpointed, in which case it holds a reference to the deliveries of
+	// the events waiting in it, in `held`, until a checkpoint covers them. Held deliveries a checkpoint has been
+	// taken of are moved to `unsaved` until it's saved.
+	checkpointed bool
+	held         map[*models.Delivery]int
+	unsaved      []*models.Delivery
+}
+
+// ErrJoinClosed The error of handing an event to a join whose inputs have been drained
+var ErrJoinClosed = errors.New("join is closed")
+
+// sideEvent An event for a join, and which side of it the event arrived on
+type sideEvent struct {
+	event  models.EventLike
+	isLeft bool
 }
 
 func (swj *SlidingWindowJoin) slideWindowForward(newEventTime time.Time) {
@@ -88,12 +120,13 @@ func (swj *SlidingWindowJoin) slideWindowForward(newEventTime time.Time) {
 		}
 
 		swj.timeBuckets = append(swj.timeBuckets, newBucket)
-		swj.numBuckets++
 
 		// Drop oldest bucket to maintain window size
 		// TODO: Persist to disk here
-		swj.timeBuckets = swj.timeBuckets[1:]
-		swj.numBuckets--
+		if len(swj.timeBuckets) > swj.numBuckets {
+			swj.evict(swj.timeBuckets[0])
+			swj.timeBuckets = swj.timeBuckets[1:]
+		}
 	}
 }
 
@@ -104,15 +137,104 @@ func (swj *SlidingWindowJoin) addFirstBucket(fromTime time.Time) {
 		rightEvents: make(map[string]*btree.BTreeG[models.EventLike]),
 	}
 	swj.timeBuckets = append(swj.timeBuckets, newBucket)
-	swj.numBuckets++
 }
 
 func (swj *SlidingWindowJoin) AddLeft(ctx context.Context, event models.EventLike) error {
-	return swj.addEvent(ctx, event, true)
+	return swj.send(ctx, sideEvent{event: event, isLeft: true})
 }
 
 func (swj *SlidingWindowJoin) AddRight(ctx context.Context, event models.EventLike) error {
-	return swj.addEvent(ctx, event, false)
+	return swj.send(ctx, sideEvent{event: event, isLeft: false})
+}
+
+// send Hand an event to the event loop, or refuse it with ErrJoinClosed once the join has been drained. Events
+// is only closed once no sender holds `sending`, so a send racing with Flush or Close can't panic.
+func (swj *SlidingWindowJoin) send(ctx context.Context, se sideEvent) error {
+	swj.sending.RLock()
+	defer swj.sending.RUnlock()
+	if swj.closed {
+		return fmt.Errorf("join %s: %w", swj.ID(), ErrJoinClosed)
+	}
+	select {
+	case swj.events <- se:
+		return nil
+	case <-swj.stopped:
+		return fmt.Errorf("join %s: %w", swj.ID(), ErrJoinClosed)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start Start the event loop, unless it has been started already
+func (swj *SlidingWindowJoin) start(ctx context.Context) {
+	swj.lifecycle.Lock()
+	defer swj.lifecycle.Unlock()
+	if swj.started {
+		return
+	}
+	swj.started = true
+	swj.running = true
+	go swj.run(ctx)
+}
+
+// run Join events, and run calls between them, until both inputs have closed or `ctx` ends
+func (swj *SlidingWindowJoin) run(ctx context.Context) {
+	defer func() {
+		swj.lifecycle.Lock()
+		swj.running = false
+		swj.lifecycle.Unlock()
+		close(swj.stopped)
+	}()
+	for {
+		select {
+		case se, ok := <-swj.events:
+			if !ok {
+				return
+			}
+			if err := swj.addEvent(ctx, se.event, se.isLeft); err != nil {
+				slog.Error("Error joining event", "id", swj.ID(), "error", err)
+			}
+		case call := <-swj.calls:
+			call()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// do Run `fn` on the event loop, or straight away if the loop isn't running, i.e. before Results or once both
+// inputs have closed
+func (swj *SlidingWindowJoin) do(fn func()) {
+	swj.lifecycle.Lock()
+	if !swj.running {
+		defer swj.lifecycle.Unlock()
+		fn()
+		return
+	}
+	swj.lifecycle.Unlock()
+	done := make(chan struct{})
+	select {
+	case swj.calls <- func() { fn(); close(done) }:
+		<-done
+	case <-swj.stopped:
+		swj.do(fn)
+	}
+}
+
+// drain Stop the event loop once it has joined the events already handed to it
+func (swj *SlidingWindowJoin) drain() {
+	swj.drainOnce.Do(func() {
+		swj.sending.Lock()
+		swj.closed = true
+		close(swj.events)
+		swj.sending.Unlock()
+		swj.lifecycle.Lock()
+		started := swj.started
+		swj.lifecycle.Unlock()
+		if started {
+			<-swj.stopped
+		}
+	})
 }
 
 func (swj *SlidingWindowJoin) getCompositeKey(event models.EventLike, isLeft bool) string {
@@ -129,32 +251,87 @@ func (swj *SlidingWindowJoin) getCompositeKey(event models.EventLike, isLeft boo
 }
 
 func (swj *SlidingWindowJoin) addEvent(ctx context.Context, event models.EventLike, isLeft bool) error {
-	slog.Info("Added message to SlidingWindowJoin", "event", event, "isLeft", isLeft, "buckets", swj.timeBuckets)
 	if len(swj.timeBuckets) == 0 {
 		swj.addFirstBucket(event.GetTimestamp())
 	}
 
 	if matches := swj.findMatch(event, isLeft); len(matches) > 0 {
-		slog.Info("Found match for event", "event", event, "isLeft", isLeft, "matches", matches)
+		// Each result holds a copy of the event, and of its match, which no longer waits in the window
+		models.Retain(event, len(matches)-1)
 		for _, match := range matches {
-			var joinResult models.EventLike
+			models.Retain(match, 1)
+			swj.release(match)
+			var joined models.JoinEvent
 			if isLeft {
-				joinResult = models.NewJoinEvent(time.Now(), event, match)
+				joined = models.NewJoinEvent(time.Now(), event, match)
 			} else {
-				joinResult = models.NewJoinEvent(time.Now(), match, event)
+				joined = models.NewJoinEvent(time.Now(), match, event)
 			}
+			joined.LeftAlias, joined.RightAlias = swj.aliases[leftInput], swj.aliases[rightInput]
 			select {
-			case swj.resultsChan <- joinResult:
+			case swj.resultsChan <- joined:
 			case <-ctx.Done():
 				return ctx.Err()
-			default:
-				return fmt.Errorf("results channel full")
 			}
 		}
 
 		return nil
 	}
+	if err := swj.store(event, isLeft); err != nil {
+		models.Done(event)
+		return err
+	}
+	if swj.checkpointed {
+		swj.hold(event)
+		return nil
+	}
+	// The window is only kept in memory, so an event waiting in it is as done with as it will be. Its message is
+	// acked rather than held for as long as the window, and the event is lost if the query stops before it matches.
+	models.Done(event)
+	return nil
+}
 
+// hold Keep the reference of an event stored in a checkpointed window, until a checkpoint covers it
+func (swj *SlidingWindowJoin) hold(event models.EventLike) {
+	for _, d := range models.DeliveriesOf(event) {
+		swj.held[d]++
+	}
+}
+
+// release Give up the reference the window holds to an event that no longer waits in it, unless a checkpoint
+// already covers it
+func (swj *SlidingWindowJoin) release(event models.EventLike) {
+	for _, d := range models.DeliveriesOf(event) {
+		if swj.held[d] == 0 {
+			continue
+		}
+		if swj.held[d]--; swj.held[d] == 0 {
+			delete(swj.held, d)
+		}
+		d.Done()
+	}
+}
+
+// evict Release the events of a bucket the window has slid past, which are done with, as they can't match any more
+func (swj *SlidingWindowJoin) evict(bucket *TimeBucket) {
+	if len(swj.held) == 0 {
+		return
+	}
+	for _, events := range []map[string]*btree.BTreeG[models.EventLike]{bucket.leftEvents, bucket.rightEvents} {
+		for _, tree := range events {
+			tree.Scan(func(event models.EventLike) bool {
+				swj.release(event)
+				return true
+			})
+		}
+	}
+}
+
+// store Keep an event in the bucket of its timestamp, sliding the window forward if it's newer than every bucket
+func (swj *SlidingWindowJoin) store(event models.EventLike, isLeft bool) error {
+	if len(swj.timeBuckets) == 0 {
+		swj.addFirstBucket(event.GetTimestamp())
+	}
 	newestBucket := swj.timeBuckets[len(swj.timeBuckets)-1]
 	bucketEndTime := newestBucket.timestamp.Add(swj.bucketSize)
 
@@ -162,7 +339,7 @@ func (swj *SlidingWindowJoin) addEvent(ctx context.Context, event models.EventLi
 		swj.slideWindowForward(event.GetTimestamp())
 	}
 
-	eventBucket := sort.Search(swj.numBuckets, func(i int) bool {
+	eventBucket := sort.Search(len(swj.timeBuckets), func(i int) bool {
 		return swj.timeBuckets[i].timestamp.After(event.GetTimestamp())
 	}) - 1
 
@@ -171,7 +348,6 @@ func (swj *SlidingWindowJoin) addEvent(ctx context.Context, event models.EventLi
 	}
 
 	compositeKey := swj.getCompositeKey(event, isLeft)
-	slog.Info("Adding event to SlidingWindowJoin", "compositeKey", compositeKey)
 
 	// Get the correct events map
 	var eventsMap map[string]*btree.BTreeG[models.EventLike]
@@ -203,7 +379,7 @@ func (swj *SlidingWindowJoin) findMatch(event models.EventLike, isLeft bool) []m
 	startPivot := models.Event{Timestamp: earliestTime}
 
 	for _, bucket := range swj.timeBuckets {
-		if bucket.timestamp.Before(earliestTime) || bucket.timestamp.After(latestTime) {
+		if bucket.timestamp.Add(swj.bucketSize).Before(earliestTime) || bucket.timestamp.After(latestTime) {
 			continue
 		}
 
@@ -237,11 +413,14 @@ func (swj *SlidingWindowJoin) findMatch(event models.EventLike, isLeft bool) []m
 	return matchedEvents
 }
 
-func NewSlidingWindowJoin(windowDuration time.Duration, equiJoinPreds []EquiJoinPredicate) *SlidingWindowJoin {
-	bufferSize := 512 // Magic number - add to configuration
+func NewSlidingWindowJoin(windowDuration time.Duration, equiJoinPreds []EquiJoinPredicate, bufferSize int) *SlidingWindowJoin {
+	if bufferSize <= 0 {
+		bufferSize = 512 // default
+	}
 	bucketSize := calculateBucketSize(windowDuration)
 	totalDuration := windowDuration + (windowDuration / 2)
-	numBuckets := int(totalDuration / bucketSize)
+	// One more than the window needs, for the newest bucket, which is still filling
+	numBuckets := int(totalDuration/bucketSize) + 1
 
 	return &SlidingWindowJoin{
 		id:             uuid.New(),
@@ -252,18 +431,81 @@ func NewSlidingWindowJoin(windowDuration time.Duration, equiJoinPreds []EquiJoin
 		equiJoinPreds:  equiJoinPreds,
 		resultsChan:    make(chan models.EventLike, bufferSize),
 		bufferSize:     bufferSize,
+		events:         make(chan sideEvent, bufferSize),
+		calls:          make(chan func()),
+		stopped:        make(chan struct{}),
 	}
 }
 
+// SetCheckpointed Hold on to the messages of events waiting in the window until a checkpoint covers them, rather
+// than acking them once they're stored, so that those a checkpoint doesn't cover are redelivered if the query
+// stops.
+func (swj *SlidingWindowJoin) SetCheckpointed() {
+	swj.checkpointed = true
+	swj.held = make(map[*models.Delivery]int)
+}
+
+// SetAliases Let the fields of results be qualified by the aliases of the inputs, as well as by left and right
+func (swj *SlidingWindowJoin) SetAliases(left string, right string) {
+	swj.aliases = [2]string{leftInput: left, rightInput: right}
+}
+
+// Span How far back in time the window keeps events, from the start of its oldest bucket to the end of its newest
+func (swj *SlidingWindowJoin) Span() time.Duration {
+	return time.Duration(swj.numBuckets) * swj.bucketSize
+}
+
 func (swj *SlidingWindowJoin) ID() string {
 	return swj.id.String()
 }
 
+func (swj *SlidingWindowJoin) Describe() map[string]string {
+	return map[string]string{
+		"window":      swj.windowDuration.String(),
+		"bucket_size": swj.bucketSize.String(),
+		"buckets":     strconv.Itoa(swj.numBuckets),
+		"join_keys":   strconv.Itoa(len(swj.equiJoinPreds)),
+		"buffer_size": strconv.Itoa(swj.bufferSize),
+	}
+}
+
+func (swj *SlidingWindowJoin) Buffer() (int, int) {
+	return len(swj.resultsChan), cap(swj.resultsChan)
+}
+
+// Results Start the event loop, joining events until the join is flushed or `ctx` ends.
 func (swj *SlidingWindowJoin) Results(ctx context.Context, consumerID string, errorCh chan<- error) <-chan models.EventLike {
+	swj.start(ctx)
 	return swj.resultsChan
 }
 
-func (swj *SlidingWindowJoin) Close() error { return nil }
+// Flush Join the events already handed to the event loop. An inner join has nothing to emit for events still
+// waiting for a match, so they're dropped, and counted in the log.
+func (swj *SlidingWindowJoin) Flush(ctx context.Context) error {
+	swj.drain()
+	unmatched := 0
+	swj.do(func() {
+		for _, bucket := range swj.timeBuckets {
+			for _, tree := range bucket.leftEvents {
+				unmatched += tree.Len()
+			}
+			for _, tree := range bucket.rightEvents {
+				unmatched += tree.Len()
+			}
+		}
+	})
+	if unmatched > 0 {
+		slog.InfoContext(ctx, "Dropping unmatched events from join window", "id", swj.ID(), "events", unmatched)
+	}
+	return nil
+}
+
+// Close Close the results, once both inputs have closed.
+func (swj *SlidingWindowJoin) Close() error {
+	swj.drain()
+	swj.closeOnce.Do(func() { close(swj.resultsChan) })
+	return nil
+}
 
 func calculateBucketSize(window time.Duration) time.Duration {
 	switch {
@@ -0,0 +1,350 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"stream_combination/models"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testJoin The joins under test, which are fed from several goroutines at once
+type testJoin interface {
+	AddLeft(ctx context.Context, event models.EventLike) error
+	AddRight(ctx context.Context, event models.EventLike) error
+	Results(ctx context.Context, consumerID string, errorCh chan<- error) <-chan models.EventLike
+	Flush(ctx context.Context) error
+	Close() error
+	Checkpoint() ([]byte, func(), error)
+}
+
+func keyPredicates() []EquiJoinPredicate {
+	key := func(event models.EventLike) string { return event.GetString("key") }
+	return []EquiJoinPredicate{*NewEquiJoin(key, key)}
+}
+
+// pairEvents One left and one right event per key, at random times within `spread` of `start`, each side in time
+// order as the join expects of an input, counting their acks in `acks`
+func pairEvents(rng *rand.Rand, keys int, start time.Time, spread time.Duration, acks *atomic.Int32) (left, right []models.EventLike) {
+	at := func() time.Time { return start.Add(time.Duration(rng.Int63n(int64(spread)))) }
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("k%d", i)
+		left = append(left, readEvent(at(), map[string]interface{}{"key": key, "side": "left"}, acks))
+		right = append(right, readEvent(at(), map[string]interface{}{"key": key, "side": "right"}, acks))
+	}
+	byTime := func(events []models.EventLike) {
+		sort.Slice(events, func(i, j int) bool { return events[i].GetTimestamp().Before(events[j].GetTimestamp()) })
+	}
+	byTime(left)
+	byTime(right)
+	return left, right
+}
+
+// referenceJoin The keys a nested-loop join over the events of each key matches, i.e. those whose events are
+// within `window` of each other
+func referenceJoin(left, right []models.EventLike, window time.Duration) map[string]int {
+	rightByKey := make(map[string][]models.EventLike)
+	for _, r := range right {
+		rightByKey[r.GetString("key")] = append(rightByKey[r.GetString("key")], r)
+	}
+	matches := make(map[string]int)
+	for _, l := range left {
+		for _, r := range rightByKey[l.GetString("key")] {
+			if d := l.GetTimestamp().Sub(r.GetTimestamp()); d <= window && d >= -window {
+				matches[l.GetString("key")]++
+			}
+		}
+	}
+	return matches
+}
+
+// runJoin Feed both sides to `join` concurrently, checkpointing it meanwhile, and count the matches per key. Each
+// result is done with once it's counted.
+func runJoin(t *testing.T, join testJoin, left, right []models.EventLike) map[string]int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	results := join.Results(ctx, "test", make(chan error, 1))
+	matches := make(map[string]int)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for result := range results {
+			joined := result.(models.JoinEvent)
+			if joined.LeftEvent.GetString("side") != "left" || joined.RightEvent.GetString("side") != "right" {
+				t.Errorf("sides swapped in %v", joined)
+			}
+			if l, r := joined.LeftEvent.GetString("key"), joined.RightEvent.GetString("key"); l != r {
+				t.Errorf("joined keys %s and %s", l, r)
+			}
+			matches[joined.LeftEvent.GetString("key")]++
+			models.Done(joined)
+		}
+	}()
+
+	var inputs sync.WaitGroup
+	feed := func(events []models.EventLike, add func(context.Context, models.EventLike) error) {
+		defer inputs.Done()
+		for _, event := range events {
+			if err := add(ctx, event); err != nil {
+				t.Errorf("adding event: %v", err)
+				return
+			}
+		}
+	}
+	inputs.Add(2)
+	go feed(left, join.AddLeft)
+	go feed(right, join.AddRight)
+
+	stopCheckpoints := make(chan struct{})
+	checkpointed := make(chan struct{})
+	go func() {
+		defer close(checkpointed)
+		for {
+			select {
+			case <-stopCheckpoints:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			_, commit, err := join.Checkpoint()
+			if err != nil {
+				t.Errorf("checkpoint: %v", err)
+				return
+			}
+			commit()
+		}
+	}()
+
+	inputs.Wait()
+	close(stopCheckpoints)
+	<-checkpointed
+	if err := join.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if err := join.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	<-collected
+	return matches
+}
+
+func TestJoinMatchesReference(t *testing.T) {
+	const window = 10 * time.Minute
+	checkpointed := func(join interface {
+		testJoin
+		SetCheckpointed()
+	}) testJoin {
+		join.SetCheckpointed()
+		return join
+	}
+	tests := []struct {
+		name string
+		join func() testJoin
+	}{
+		{"sliding window", func() testJoin { return NewSlidingWindowJoin(window, keyPredicates(), 16) }},
+		{"one partition", func() testJoin { return NewPartitionedJoin(window, keyPredicates(), 16, 1) }},
+		{"eight partitions", func() testJoin { return NewPartitionedJoin(window, keyPredicates(), 16, 8) }},
+		{"checkpointed sliding window", func() testJoin { return checkpointed(NewSlidingWindowJoin(window, keyPredicates(), 16)) }},
+		{"checkpointed partitions", func() testJoin { return checkpointed(NewPartitionedJoin(window, keyPredicates(), 16, 8)) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for seed := int64(1); seed <= 3; seed++ {
+				rng := rand.New(rand.NewSource(seed))
+				// Spread over the whole window and half of it again, so that no event falls out of the window
+				var acks atomic.Int32
+				left, right := pairEvents(rng, 2000, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), window+window/2, &acks)
+				expected := referenceJoin(left, right, window)
+
+				join := test.join()
+				matches := runJoin(t, join, left, right)
+				if len(matches) != len(expected) {
+					t.Errorf("seed %d: %d keys matched, expected %d", seed, len(matches), len(expected))
+				}
+				for key, n := range expected {
+					if matches[key] != n {
+						t.Errorf("seed %d: key %s matched %d times, expected %d", seed, key, matches[key], n)
+					}
+				}
+				// Once every result is done with, and a last checkpoint covers the events still waiting, every
+				// message is acked, once
+				_, commit, err := join.Checkpoint()
+				if err != nil {
+					t.Fatal(err)
+				}
+				commit()
+				if n := acks.Load(); n != int32(len(left)+len(right)) {
+					t.Errorf("seed %d: %d acks, expected %d", seed, n, len(left)+len(right))
+				}
+			}
+		})
+	}
+}
+
+func TestSlidingWindowJoinRefusesEventsOnceClosed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	join := NewSlidingWindowJoin(time.Minute, keyPredicates(), 4)
+	results := join.Results(ctx, "test", make(chan error, 1))
+	go func() {
+		for range results {
+		}
+	}()
+
+	// Senders race with Close, which must neither panic nor leave them blocked
+	var senders sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		senders.Add(1)
+		go func(i int) {
+			defer senders.Done()
+			for n := 0; ; n++ {
+				event := models.NewEvent(time.Unix(int64(n), 0), map[string]interface{}{"key": fmt.Sprintf("%d-%d", i, n)})
+				if err := join.AddLeft(ctx, event); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := join.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	senders.Wait()
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, ErrJoinClosed) {
+			t.Errorf("expected ErrJoinClosed, got %v", err)
+		}
+	}
+}
+
+func TestJoinProjectsAliasedColumns(t *testing.T) {
+	for _, parallelism := range []int{1, 4} {
+		t.Run(fmt.Sprintf("parallelism %d", parallelism), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			// Stand-ins for the readers of `users u` and `purchases p`
+			pb := NewProcessorBuilder(nil)
+			pb.SetParallelism(parallelism)
+			users, _ := NewColumnFilter([]string{"*"}, 1)
+			purchases, _ := NewColumnFilter([]string{"*"}, 1)
+			pb.AddProcessor(users.ID(), users)
+			pb.AddAlias("u", users.ID())
+			pb.AddProcessor(purchases.ID(), purchases)
+			pb.AddAlias("p", purchases.ID())
+			key := func(field string) func(models.EventLike) string {
+				return func(event models.EventLike) string { return event.GetString(field) }
+			}
+			join := pb.NewWindowJoin(time.Minute, []EquiJoinPredicate{*NewEquiJoin(key("user_id"), key("user_id"))})
+			pb.AddDualProcessor(join.ID(), join, users.ID(), purchases.ID())
+
+			results := join.Results(ctx, "test", make(chan error, 1))
+			now := time.Now()
+			if err := join.AddLeft(ctx, models.NewEvent(now, map[string]interface{}{"user_id": "u1", "name": "Ada"})); err != nil {
+				t.Fatal(err)
+			}
+			if err := join.AddRight(ctx, models.NewEvent(now, map[string]interface{}{"user_id": "u1", "amount": 12.5})); err != nil {
+				t.Fatal(err)
+			}
+			var joined models.EventLike
+			select {
+			case joined = <-results:
+			case <-ctx.Done():
+				t.Fatal("no join result")
+			}
+
+			projection, _ := NewAliasedColumnFilter(
+				[]string{"u.user_id", "u.name", "p.amount", "right.user_id"},
+				[]string{"user_id", "name", "amount", "purchase_user_id"},
+				1,
+			)
+			projected := projection.project(joined)
+			expected := map[string]interface{}{"user_id": "u1", "name": "Ada", "amount": 12.5, "purchase_user_id": "u1"}
+			for field, value := range expected {
+				if got := projected.GetField(field); got != value {
+					t.Errorf("%s = %v, expected %v", field, got, value)
+				}
+			}
+		})
+	}
+}
+
+// TestSlidingWindowJoinBuckets Regressions of the window's bucket bookkeeping, with a 10 minute window of 5 minute
+// buckets, which keeps 15 minutes of events and one bucket still filling
+func TestSlidingWindowJoinBuckets(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes float64) time.Time { return start.Add(time.Duration(minutes * float64(time.Minute))) }
+	type add struct {
+		left    bool
+		key     string
+		minutes float64
+	}
+	tests := []struct {
+		name    string
+		events  []add
+		matches int
+		buckets int
+	}{
+		{
+			// The bucket count started at the window's capacity, so the first event was searched for past the
+			// end of the buckets
+			name:    "first event",
+			events:  []add{{true, "a", 1}},
+			matches: 0,
+			buckets: 1,
+		},
+		{
+			// Sliding dropped the oldest bucket as it added one, so the window only ever held the newest bucket
+			name:    "match in an earlier bucket",
+			events:  []add{{true, "a", 1}, {false, "b", 6}, {false, "a", 7}},
+			matches: 1,
+			buckets: 2,
+		},
+		{
+			// Matching skipped the bucket that starts before the window does, though it holds events within it
+			name:    "match in the bucket the window starts in",
+			events:  []add{{true, "a", 4}, {false, "b", 12}, {false, "a", 12}},
+			matches: 1,
+			buckets: 3,
+		},
+		{
+			// The window keeps half of it again, for events arriving late, and the bucket still filling
+			name:    "late event within half a window",
+			events:  []add{{true, "a", 0}, {false, "b", 15}, {false, "a", 9}},
+			matches: 1,
+			buckets: 4,
+		},
+		{
+			name:    "buckets beyond the window are dropped",
+			events:  []add{{true, "a", 0}, {false, "b", 60}},
+			matches: 0,
+			buckets: 4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			join := NewSlidingWindowJoin(10*time.Minute, keyPredicates(), 16)
+			for _, e := range test.events {
+				event := models.NewEvent(at(e.minutes), map[string]interface{}{"key": e.key})
+				if err := join.addEvent(context.Background(), event, e.left); err != nil {
+					t.Fatalf("adding %v: %v", e, err)
+				}
+			}
+			if matches := len(join.resultsChan); matches != test.matches {
+				t.Errorf("%d matches, expected %d", matches, test.matches)
+			}
+			if buckets := len(join.timeBuckets); buckets != test.buckets {
+				t.Errorf("%d buckets, expected %d", buckets, test.buckets)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"stream_combination/engine"
+	"stream_combination/models"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server REST API for persistent queries, and streaming endpoints for push queries
+//
+//	POST   /statements     run a statement such as SHOW QUERIES or TERMINATE: {"sql": "..."}
+//	POST   /queries        start a persistent query: {"sql": "CREATE STREAM ... AS SELECT ..."}
+//	GET    /queries        list queries
+//	GET    /queries/{id}   describe a query, including its error
+//	DELETE /queries/{id}   terminate a query
+//	POST   /query          run a push query, streaming rows as NDJSON, or SSE with `Accept: text/event-stream`
+//	GET    /query/ws       run a push query over a WebSocket; the first message is {"sql": "..."}
+type Server struct {
+	engine   *engine.Engine
+	mux      *http.ServeMux
+	upgrader websocket.Upgrader
+}
+
+type queryRequest struct {
+	SQL string `json:"sql"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func New(e *engine.Engine) *Server {
+	s := &Server{
+		engine: e,
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("POST /statements", s.executeStatement)
+	s.mux.HandleFunc("POST /queries", s.createQuery)
+	s.mux.HandleFunc("GET /queries", s.listQueries)
+	s.mux.HandleFunc("GET /queries/{id}", s.getQuery)
+	s.mux.HandleFunc("DELETE /queries/{id}", s.terminateQuery)
+	s.mux.HandleFunc("POST /query", s.pushQuery)
+	s.mux.HandleFunc("GET /query/ws", s.pushQueryWebSocket)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func readQueryRequest(r *http.Request) (queryRequest, error) {
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, fmt.Errorf("invalid request body: %w", err)
+	}
+	if strings.TrimSpace(req.SQL) == "" {
+		return req, errors.New("sql is required")
+	}
+	return req, nil
+}
+
+func (s *Server) executeStatement(w http.ResponseWriter, r *http.Request) {
+	req, err := readQueryRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	result, err := s.engine.Execute(r.Context(), req.SQL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) createQuery(w http.ResponseWriter, r *http.Request) {
+	req, err := readQueryRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	query, err := s.engine.StartPersistent(req.SQL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, query.Info())
+}
+
+func (s *Server) listQueries(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.List())
+}
+
+func (s *Server) getQuery(w http.ResponseWriter, r *http.Request) {
+	query, ok := s.engine.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("query %s not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, query.Info())
+}
+
+func (s *Server) terminateQuery(w http.ResponseWriter, r *http.Request) {
+	if err := s.engine.Terminate(r.PathValue("id")); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rowWriter Write rows of a push query in a streaming format, flushing each one.
+type rowWriter interface {
+	Row(event models.EventLike) error
+	Error(err error) error
+}
+
+func (s *Server) pushQuery(w http.ResponseWriter, r *http.Request) {
+	req, err := readQueryRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	// The query is cancelled along with the request, when the client disconnects
+	query, err := s.engine.StartTransient(r.Context(), req.SQL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer s.engine.Terminate(query.ID)
+
+	var rows rowWriter
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		rows = newSSEWriter(w, flusher)
+	} else {
+		rows = newNDJSONWriter(w, flusher)
+	}
+	w.Header().Set("X-Query-Id", query.ID)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	streamRows(query, rows)
+}
+
+// streamRows Write rows until the query stops or the client goes away.
+func streamRows(query *engine.Query, rows rowWriter) {
+	for {
+		select {
+		case event := <-query.Rows():
+			if err := rows.Row(event); err != nil {
+				slog.Info("Push query client went away", "id", query.ID, "error", err)
+				return
+			}
+		case <-query.Done():
+			if err := query.Err(); err != nil {
+				_ = rows.Error(err)
+			}
+			return
+		}
+	}
+}
+
+func (s *Server) pushQueryWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written the error response
+		return
+	}
+	defer conn.Close()
+
+	var req queryRequest
+	if err := conn.ReadJSON(&req); err != nil {
+		_ = conn.WriteJSON(errorResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	query, err := s.engine.StartTransient(r.Context(), req.SQL)
+	if err != nil {
+		_ = conn.WriteJSON(errorResponse{Error: err.Error()})
+		return
+	}
+	defer s.engine.Terminate(query.ID)
+
+	// Any message from the client, or it closing the socket, cancels the query
+	go func() {
+		_, _, _ = conn.ReadMessage()
+		_ = s.engine.Terminate(query.ID)
+	}()
+
+	streamRows(query, &webSocketWriter{conn: conn})
+}
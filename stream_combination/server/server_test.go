@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"stream_combination/engine"
+	"stream_combination/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlers(t *testing.T) {
+	server := New(engine.New(nil, nil, nil))
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		// response The JSON the handler responds with
+		response string
+	}{
+		{"statement without a body", http.MethodPost, "/statements", "", http.StatusBadRequest, `{"error":"invalid request body: EOF"}`},
+		{"statement without sql", http.MethodPost, "/statements", `{"sql": " "}`, http.StatusBadRequest, `{"error":"sql is required"}`},
+		{"query without sql", http.MethodPost, "/queries", `{}`, http.StatusBadRequest, `{"error":"sql is required"}`},
+		{"push query without sql", http.MethodPost, "/query", `{"sql": ""}`, http.StatusBadRequest, `{"error":"sql is required"}`},
+		{"no queries", http.MethodGet, "/queries", "", http.StatusOK, `[]`},
+		{"unknown query", http.MethodGet, "/queries/CSAS_TOTALS", "", http.StatusNotFound, `{"error":"query CSAS_TOTALS not found"}`},
+		{"terminate an unknown query", http.MethodDelete, "/queries/CSAS_TOTALS", "", http.StatusNotFound, `{"error":"query CSAS_TOTALS not found"}`},
+		{"unknown method", http.MethodPut, "/queries", "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+			if recorder.Code != test.status {
+				t.Errorf("status %d, expected %d", recorder.Code, test.status)
+			}
+			if test.response == "" {
+				return
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("content type %q, expected JSON", contentType)
+			}
+			if got := strings.TrimSpace(recorder.Body.String()); got != test.response {
+				t.Errorf("response %s, expected %s", got, test.response)
+			}
+		})
+	}
+}
+
+func TestRowWriters(t *testing.T) {
+	tests := []struct {
+		name        string
+		writer      func(recorder *httptest.ResponseRecorder) rowWriter
+		contentType string
+		expected    string
+	}{
+		{"NDJSON", func(recorder *httptest.ResponseRecorder) rowWriter { return newNDJSONWriter(recorder, recorder) }, "application/x-ndjson",
+			"{\"id\":1}\n{\"id\":2}\n{\"error\":\"stream orders deleted\"}\n"},
+		{"SSE", func(recorder *httptest.ResponseRecorder) rowWriter { return newSSEWriter(recorder, recorder) }, "text/event-stream",
+			"event: row\ndata: {\"id\":1}\n\nevent: row\ndata: {\"id\":2}\n\nevent: error\ndata: {\"error\":\"stream orders deleted\"}\n\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			rows := test.writer(recorder)
+			for id := 1; id <= 2; id++ {
+				if err := rows.Row(models.NewEvent(time.Now(), map[string]interface{}{"id": id})); err != nil {
+					t.Fatal(err)
+				}
+				// Each row is flushed as it's written, rather than when the response ends
+				if !recorder.Flushed {
+					t.Errorf("row %d wasn't flushed", id)
+				}
+				recorder.Flushed = false
+			}
+			if err := rows.Error(errors.New("stream orders deleted")); err != nil {
+				t.Fatal(err)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("content type %q, expected %q", contentType, test.contentType)
+			}
+			if got := recorder.Body.String(); got != test.expected {
+				t.Errorf("wrote %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
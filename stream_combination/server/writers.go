@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"stream_combination/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// ndjsonWriter One JSON object per line over chunked HTTP
+type ndjsonWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	encoder *json.Encoder
+}
+
+func newNDJSONWriter(w http.ResponseWriter, flusher http.Flusher) *ndjsonWriter {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	return &ndjsonWriter{w: w, flusher: flusher, encoder: json.NewEncoder(w)}
+}
+
+func (nw *ndjsonWriter) Row(event models.EventLike) error {
+	if err := nw.encoder.Encode(event); err != nil {
+		return err
+	}
+	nw.flusher.Flush()
+	return nil
+}
+
+func (nw *ndjsonWriter) Error(err error) error {
+	if err := nw.encoder.Encode(errorResponse{Error: err.Error()}); err != nil {
+		return err
+	}
+	nw.flusher.Flush()
+	return nil
+}
+
+// sseWriter Server-Sent Events, with `row` and `error` events
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSEWriter(w http.ResponseWriter, flusher http.Flusher) *sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	return &sseWriter{w: w, flusher: flusher}
+}
+
+func (sw *sseWriter) event(name string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(sw.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+func (sw *sseWriter) Row(event models.EventLike) error {
+	return sw.event("row", event)
+}
+
+func (sw *sseWriter) Error(err error) error {
+	return sw.event("error", errorResponse{Error: err.Error()})
+}
+
+// webSocketWriter One JSON message per row
+type webSocketWriter struct {
+	conn *websocket.Conn
+}
+
+func (ww *webSocketWriter) Row(event models.EventLike) error {
+	return ww.conn.WriteJSON(event)
+}
+
+func (ww *webSocketWriter) Error(err error) error {
+	return ww.conn.WriteJSON(errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"stream_combination/engine"
+	"stream_combination/models"
+	"strings"
+)
+
+const minColumnWidth = 16
+
+// tableWriter Render rows as they arrive. Widths are fixed from the headers, as rows can't be buffered.
+type tableWriter struct {
+	w       io.Writer
+	columns []string
+	widths  []int
+}
+
+func newTableWriter(w io.Writer, columns []string) *tableWriter {
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		widths[i] = max(len(column), minColumnWidth)
+	}
+	return &tableWriter{w: w, columns: columns, widths: widths}
+}
+
+func (tw *tableWriter) border() {
+	parts := make([]string, len(tw.widths))
+	for i, width := range tw.widths {
+		parts[i] = strings.Repeat("-", width+2)
+	}
+	fmt.Fprintf(tw.w, "+%s+\n", strings.Join(parts, "+"))
+}
+
+func (tw *tableWriter) line(values []string) {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		width := tw.widths[i]
+		if len(value) > width {
+			value = value[:width-3] + "..."
+		}
+		parts[i] = fmt.Sprintf(" %-*s ", width, value)
+	}
+	fmt.Fprintf(tw.w, "|%s|\n", strings.Join(parts, "|"))
+}
+
+func (tw *tableWriter) Header() {
+	tw.border()
+	tw.line(tw.columns)
+	tw.border()
+}
+
+func (tw *tableWriter) Row(event models.EventLike) {
+	values := make([]string, len(tw.columns))
+	for i, column := range tw.columns {
+		if column == "*" {
+			data, _ := json.Marshal(event)
+			values[i] = string(data)
+			continue
+		}
+		if value := event.GetField(column); value != nil {
+			values[i] = fmt.Sprintf("%v", value)
+		}
+	}
+	tw.line(values)
+}
+
+func (tw *tableWriter) Footer() {
+	tw.border()
+}
+
+// printResult Print the result of a statement, sizing columns to fit every row.
+func printResult(w io.Writer, result *engine.Result) {
+	if result.Message != "" {
+		fmt.Fprintln(w, result.Message)
+	}
+	if len(result.Columns) == 0 {
+		return
+	}
+	tw := newTableWriter(w, result.Columns)
+	for i := range tw.widths {
+		tw.widths[i] = len(result.Columns[i])
+		for _, row := range result.Rows {
+			tw.widths[i] = max(tw.widths[i], len(row[i]))
+		}
+	}
+	tw.Header()
+	for _, row := range result.Rows {
+		tw.line(row)
+	}
+	tw.Footer()
+	fmt.Fprintf(w, "%d rows\n", len(result.Rows))
+}